@@ -1,11 +1,13 @@
 package app
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/webrtc/v3"
@@ -25,6 +27,7 @@ type Room struct {
 	ID           string
 	Type         string // "audio", "video", "data"
 	Participants map[string]*Participant
+	AllowedUsers map[string]bool // nil = open room, otherwise only these users can join
 	mu           sync.RWMutex
 }
 
@@ -42,11 +45,6 @@ type DataEvent struct {
 	Timestamp int64                  `msgpack:"timestamp"`
 }
 
-type PubSubMessage struct {
-	Topic   string                 `msgpack:"topic"`
-	Payload map[string]interface{} `msgpack:"payload"`
-}
-
 // ==================== GLOBALS ====================
 
 var (
@@ -56,40 +54,16 @@ var (
 	scriptManager      *ScriptManager
 	locationManager    *LocationManager
 	userChannelManager *UserChannelManager
+	callManager        *CallManager
+	roomAnalytics      *RoomAnalytics
+	topicAuthorizer    *TopicAuthorizer
+	routerBridge       *RouterBridge
+	rateLimiter        *RateLimiter
+	webPush            *WebPushService
+	presenceTracker    *PresenceTracker
+	locationPrivacy    *LocationPrivacyStore
 )
 
-// ==================== PUB/SUB ====================
-
-type PubSub struct {
-	subscribers map[string][]chan PubSubMessage
-	mu          sync.RWMutex
-}
-
-func NewPubSub() *PubSub {
-	return &PubSub{
-		subscribers: make(map[string][]chan PubSubMessage),
-	}
-}
-
-func (ps *PubSub) Subscribe(topic string) chan PubSubMessage {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
-	ch := make(chan PubSubMessage, 100)
-	ps.subscribers[topic] = append(ps.subscribers[topic], ch)
-	return ch
-}
-
-func (ps *PubSub) Publish(topic string, msg PubSubMessage) {
-	ps.mu.RLock()
-	defer ps.mu.RUnlock()
-	for _, ch := range ps.subscribers[topic] {
-		select {
-		case ch <- msg:
-		default:
-		}
-	}
-}
-
 // ==================== WEBRTC CONFIG ====================
 
 func createPeerConnection() (*webrtc.PeerConnection, error) {
@@ -122,8 +96,12 @@ func getOrCreateRoom(roomID, roomType string) *Room {
 
 func (r *Room) AddParticipant(p *Participant) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 	r.Participants[p.ID] = p
+	r.mu.Unlock()
+
+	if roomAnalytics != nil {
+		roomAnalytics.StartSession(r.ID, p)
+	}
 
 	// Broadcast join event
 	r.broadcastEvent("participant_joined", map[string]interface{}{
@@ -134,14 +112,23 @@ func (r *Room) AddParticipant(p *Participant) {
 
 func (r *Room) RemoveParticipant(participantID string) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	if _, exists := r.Participants[participantID]; !exists {
+		r.mu.Unlock()
+		return
+	}
 	delete(r.Participants, participantID)
+	r.mu.Unlock()
+
+	if roomAnalytics != nil {
+		roomAnalytics.EndSession(participantID)
+	}
 
 	r.broadcastEvent("participant_left", map[string]interface{}{
 		"participant_id": participantID,
 	})
 }
 
+// Never called with r.mu held: publishing may wait on the subscribers
 func (r *Room) broadcastEvent(eventType string, data map[string]interface{}) {
 	event := DataEvent{
 		Type:      eventType,
@@ -152,9 +139,25 @@ func (r *Room) broadcastEvent(eventType string, data map[string]interface{}) {
 
 	payload, _ := msgpack.Marshal(event)
 
+	r.mu.RLock()
+	channels := make([]*webrtc.DataChannel, 0, len(r.Participants))
 	for _, p := range r.Participants {
-		if p.DataChannel != nil && p.DataChannel.ReadyState() == webrtc.DataChannelStateOpen {
-			p.DataChannel.Send(payload)
+		if p.DataChannel != nil {
+			channels = append(channels, p.DataChannel)
+		}
+	}
+	r.mu.RUnlock()
+
+	// Mirror on the hierarchical room topic (room.<id>.<event>)
+	topic := RoomTopic(r.ID, eventType)
+	pubsub.Publish(topic, PubSubMessage{
+		Topic:   topic,
+		Payload: data,
+	})
+
+	for _, dc := range channels {
+		if dc.ReadyState() == webrtc.DataChannelStateOpen {
+			dc.Send(payload)
 		}
 	}
 }
@@ -163,6 +166,27 @@ func (r *Room) broadcastEvent(eventType string, data map[string]interface{}) {
 
 func SetupCollections(app core.App) error {
 	return app.RunInTransaction(func(txApp core.App) error {
+		// Articles Collection
+		articles := core.NewBaseCollection("articles")
+		articles.Fields.Add(
+			&core.TextField{Name: "title", Required: true},
+			&core.TextField{Name: "desc"},
+			&core.NumberField{Name: "prixOriginal", Min: types.Pointer(0.0)},
+			&core.NumberField{Name: "prix", Required: true, Min: types.Pointer(0.0)},
+			&core.NumberField{Name: "quantite", Required: true, Min: types.Pointer(0.0)},
+			&core.DateField{Name: "dueDate"},
+			&core.FileField{Name: "images", MaxSelect: 3, MaxSize: 5242880, MimeTypes: []string{"image/jpeg", "image/png", "image/webp"}},
+			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1},
+		)
+		articles.ListRule = types.Pointer("")
+		articles.ViewRule = types.Pointer("")
+		articles.CreateRule = types.Pointer(`@request.auth.id != "" && user = @request.auth.id`)
+		articles.UpdateRule = types.Pointer(`user = @request.auth.id`)
+		articles.DeleteRule = types.Pointer(`user = @request.auth.id`)
+		if err := txApp.Save(articles); err != nil {
+			return err
+		}
+
 		// Posts Collection
 		posts := core.NewBaseCollection("posts")
 		posts.Fields.Add(
@@ -173,44 +197,33 @@ func SetupCollections(app core.App) error {
 			&core.TextField{Name: "content"},
 			&core.FileField{Name: "images", MaxSelect: 3, MaxSize: 5242880, MimeTypes: []string{"image/jpeg", "image/png", "image/webp"}},
 			&core.FileField{Name: "video", MaxSelect: 1, MaxSize: 52428800, MimeTypes: []string{"video/mp4"}},
-			&core.RelationField{Name: "article", CollectionId: "articles", MaxSelect: 1},
+			&core.RelationField{Name: "article", CollectionId: articles.Id, MaxSelect: 1},
 			&core.SelectField{Name: "action", MaxSelect: 1, Values: []string{"none", "buy", "join", "subscribe", "read", "listen"}},
 			&core.TextField{Name: "actionText"},
 			&core.JSONField{Name: "dataAction"},
 			&core.NumberField{Name: "likesCount", Min: types.Pointer(0.0)},
 			&core.NumberField{Name: "commentsCount", Min: types.Pointer(0.0)},
 		)
+		posts.ListRule = types.Pointer(`isPublic = true || user = @request.auth.id`)
+		posts.ViewRule = types.Pointer(`isPublic = true || user = @request.auth.id`)
+		posts.CreateRule = types.Pointer(`@request.auth.id != "" && user = @request.auth.id`)
+		posts.UpdateRule = types.Pointer(`user = @request.auth.id`)
+		posts.DeleteRule = types.Pointer(`user = @request.auth.id`)
 		if err := txApp.Save(posts); err != nil {
 			return err
 		}
 
-		// Articles Collection
-		articles := core.NewBaseCollection("articles")
-		articles.Fields.Add(
-			&core.TextField{Name: "title", Required: true},
-			&core.TextField{Name: "desc"},
-			&core.NumberField{Name: "prixOriginal", Min: types.Pointer(0.0)},
-			&core.NumberField{Name: "prix", Required: true, Min: types.Pointer(0.0)},
-			&core.NumberField{Name: "quantite", Required: true, Min: types.Pointer(0.0)},
-			&core.DateField{Name: "dueDate"},
-			&core.FileField{Name: "images", MaxSelect: 3, MaxSize: 5242880, MimeTypes: []string{"image/jpeg", "image/png", "image/webp"}},
-			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1},
-		)
-		if err := txApp.Save(articles); err != nil {
-			return err
-		}
-
 		// VentesArticle Collection
 		ventes := core.NewBaseCollection("ventesArticle")
 		ventes.Fields.Add(
-			&core.RelationField{Name: "article", Required: true, CollectionId: "articles", MaxSelect: 1},
+			&core.RelationField{Name: "article", Required: true, CollectionId: articles.Id, MaxSelect: 1},
 			&core.NumberField{Name: "montant", Required: true, Min: types.Pointer(0.0)},
 			&core.SelectField{Name: "status", Required: true, MaxSelect: 1, Values: []string{"paye", "encours", "echec", "annule"}},
 			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1},
 			&core.DateField{Name: "paiementDate"},
 			&core.DateField{Name: "cancelDate"},
 			&core.DateField{Name: "failDate"},
-			&core.RelationField{Name: "fromPost", CollectionId: "posts", MaxSelect: 1},
+			&core.RelationField{Name: "fromPost", CollectionId: posts.Id, MaxSelect: 1},
 		)
 		if err := txApp.Save(ventes); err != nil {
 			return err
@@ -220,7 +233,7 @@ func SetupCollections(app core.App) error {
 		operations := core.NewBaseCollection("operations")
 		operations.Fields.Add(
 			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1},
-			&core.RelationField{Name: "vente", CollectionId: "ventesArticle", MaxSelect: 1},
+			&core.RelationField{Name: "vente", CollectionId: ventes.Id, MaxSelect: 1},
 			&core.NumberField{Name: "montant", Required: true},
 			&core.SelectField{Name: "operation", Required: true, MaxSelect: 1, Values: []string{"cashin", "cashout"}},
 			&core.TextField{Name: "desc"},
@@ -234,7 +247,7 @@ func SetupCollections(app core.App) error {
 		likes := core.NewBaseCollection("likes")
 		likes.Fields.Add(
 			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1},
-			&core.RelationField{Name: "post", Required: true, CollectionId: "posts", MaxSelect: 1},
+			&core.RelationField{Name: "post", Required: true, CollectionId: posts.Id, MaxSelect: 1},
 			&core.SelectField{Name: "reaction", MaxSelect: 1, Values: []string{"like", "love", "fire", "wow", "sad", "angry"}},
 		)
 		likes.Indexes = []string{"CREATE UNIQUE INDEX idx_user_post ON likes (user, post)"}
@@ -246,14 +259,19 @@ func SetupCollections(app core.App) error {
 		comments := core.NewBaseCollection("comments")
 		comments.Fields.Add(
 			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1},
-			&core.RelationField{Name: "post", Required: true, CollectionId: "posts", MaxSelect: 1},
+			&core.RelationField{Name: "post", Required: true, CollectionId: posts.Id, MaxSelect: 1},
 			&core.TextField{Name: "content", Required: true},
-			&core.RelationField{Name: "parentComment", CollectionId: "comments", MaxSelect: 1},
 		)
 		if err := txApp.Save(comments); err != nil {
 			return err
 		}
 
+		// Self relation, once the collection exists
+		comments.Fields.Add(&core.RelationField{Name: "parentComment", CollectionId: comments.Id, MaxSelect: 1})
+		if err := txApp.Save(comments); err != nil {
+			return err
+		}
+
 		// Rooms Collection (pour persistance)
 		roomsColl := core.NewBaseCollection("rooms")
 		roomsColl.Fields.Add(
@@ -280,9 +298,6 @@ func handleCreateRoom(c *core.RequestEvent) error {
 		return c.JSON(400, map[string]string{"error": "invalid request"})
 	}
 
-	// Check if respond_to=sse parameter is present
-	respondTo := c.Request.URL.Query().Get("respond_to")
-
 	roomID := generateID()
 	room := getOrCreateRoom(roomID, req.RoomType)
 
@@ -292,14 +307,7 @@ func handleCreateRoom(c *core.RequestEvent) error {
 		"name":      req.Name,
 	}
 
-	// If respond_to=sse, send response via SSE
-	if respondTo == "sse" {
-		userID := c.Get("userID").(string)
-		requestID := c.Request.Header.Get("X-Request-ID")
-		userChannelManager.SendToSSE(userID, "room_created", result, requestID)
-		return c.JSON(202, map[string]string{"status": "response_sent_via_sse"})
-	}
-
+	// ?respond_to=sse is handled by respondViaChannelMiddleware
 	return c.JSON(200, result)
 }
 
@@ -307,6 +315,14 @@ func handleJoinRoom(c *core.RequestEvent) error {
 	roomID := c.Request.PathValue("roomId")
 
 	room := getOrCreateRoom(roomID, "audio")
+	userID := c.Get("userID").(string)
+
+	room.mu.RLock()
+	allowed := room.AllowedUsers == nil || room.AllowedUsers[userID]
+	room.mu.RUnlock()
+	if !allowed {
+		return c.JSON(403, map[string]string{"error": "private room"})
+	}
 
 	pc, err := createPeerConnection()
 	if err != nil {
@@ -317,7 +333,7 @@ func handleJoinRoom(c *core.RequestEvent) error {
 	participant := &Participant{
 		ID:       participantID,
 		PeerConn: pc,
-		UserID:   c.Get("userID").(string),
+		UserID:   userID,
 	}
 
 	// Setup DataChannel
@@ -338,6 +354,13 @@ func handleJoinRoom(c *core.RequestEvent) error {
 		}
 	})
 
+	// Leave room when the peer connection goes away
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateFailed {
+			room.RemoveParticipant(participantID)
+		}
+	})
+
 	// Handle tracks
 	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		log.Printf("Track received: %s", track.Kind())
@@ -430,13 +453,18 @@ func handleDataEvent(room *Room, sender *Participant, event DataEvent) {
 			"message": event.Data["message"],
 		})
 	case "reaction":
+		payload := map[string]interface{}{
+			"room_id": room.ID,
+			"user_id": sender.UserID,
+			"type":    event.Data["type"],
+		}
 		pubsub.Publish("reactions", PubSubMessage{
-			Topic: "reactions",
-			Payload: map[string]interface{}{
-				"room_id": room.ID,
-				"user_id": sender.UserID,
-				"type":    event.Data["type"],
-			},
+			Topic:   "reactions",
+			Payload: payload,
+		})
+		pubsub.Publish(RoomTopic(room.ID, "reactions"), PubSubMessage{
+			Topic:   RoomTopic(room.ID, "reactions"),
+			Payload: payload,
 		})
 	}
 }
@@ -612,9 +640,10 @@ func handleBuyArticle(c *core.RequestEvent) error {
 
 var idCounter uint64
 
+// Also used in URLs (geofences, calls): printable, and unique across the
+// concurrent handlers
 func generateID() string {
-	idCounter++
-	return time.Now().Format("20060102150405") + "-" + string(rune(idCounter))
+	return time.Now().Format("20060102150405") + "-" + strconv.FormatUint(atomic.AddUint64(&idCounter, 1), 10)
 }
 
 // ==================== MAIN ====================
@@ -653,6 +682,166 @@ func Execute() {
 		"fallback the request to index.html on missing static path, e.g. when pretty urls are used with SPA",
 	)
 
+	var persistEvents bool
+	app.RootCmd.PersistentFlags().BoolVar(
+		&persistEvents,
+		"persistEvents",
+		false,
+		"persist SSE events in the eventLog collection so that Last-Event-ID replay survives restarts",
+	)
+
+	var pubsubBroker string
+	app.RootCmd.PersistentFlags().StringVar(
+		&pubsubBroker,
+		"pubsubBroker",
+		"",
+		"run the embedded PubSub broker on this address (ex: :7070)",
+	)
+
+	var pubsubBrokerURL string
+	app.RootCmd.PersistentFlags().StringVar(
+		&pubsubBrokerURL,
+		"pubsubBrokerURL",
+		"",
+		"address of the PubSub broker to relay events between instances (ex: 10.0.0.1:7070)",
+	)
+
+	var pubsubBrokerSecret string
+	app.RootCmd.PersistentFlags().StringVar(
+		&pubsubBrokerSecret,
+		"pubsubBrokerSecret",
+		"",
+		"shared secret required to connect to the PubSub broker",
+	)
+
+	var pubsubBrokerCert string
+	app.RootCmd.PersistentFlags().StringVar(
+		&pubsubBrokerCert,
+		"pubsubBrokerCert",
+		"",
+		"TLS certificate (PEM) of the embedded PubSub broker",
+	)
+
+	var pubsubBrokerKey string
+	app.RootCmd.PersistentFlags().StringVar(
+		&pubsubBrokerKey,
+		"pubsubBrokerKey",
+		"",
+		"TLS private key (PEM) of the embedded PubSub broker",
+	)
+
+	var pubsubBrokerCA string
+	app.RootCmd.PersistentFlags().StringVar(
+		&pubsubBrokerCA,
+		"pubsubBrokerCA",
+		"",
+		"CA bundle (PEM) trusted for a tls:// PubSub broker URL, the system roots by default",
+	)
+
+	var sseBufferSize int
+	app.RootCmd.PersistentFlags().IntVar(
+		&sseBufferSize,
+		"sseBufferSize",
+		DefaultSubscribeOptions.BufferSize,
+		"default buffer size of the SSE and PubSub subscriptions",
+	)
+
+	var ssePolicy string
+	app.RootCmd.PersistentFlags().StringVar(
+		&ssePolicy,
+		"ssePolicy",
+		string(DefaultSubscribeOptions.Policy),
+		"default policy when a subscriber buffer is full (drop_newest, drop_oldest, disconnect)",
+	)
+
+	var sseBlockTimeout time.Duration
+	app.RootCmd.PersistentFlags().DurationVar(
+		&sseBlockTimeout,
+		"sseBlockTimeout",
+		DefaultSubscribeOptions.BlockTimeout,
+		"how long the block policy of the server side subscriptions waits for a slow subscriber",
+	)
+
+	var vapidPrivateKey string
+	app.RootCmd.PersistentFlags().StringVar(
+		&vapidPrivateKey,
+		"vapidPrivateKey",
+		"",
+		"VAPID private key of Web Push (base64url), generated in pb_data/vapid_keys.json if empty",
+	)
+
+	var vapidSubject string
+	app.RootCmd.PersistentFlags().StringVar(
+		&vapidSubject,
+		"vapidSubject",
+		"mailto:admin@localhost",
+		"contact sent to the push services with the VAPID token (mailto: or https:)",
+	)
+
+	var presenceAwayAfter time.Duration
+	app.RootCmd.PersistentFlags().DurationVar(
+		&presenceAwayAfter,
+		"presenceAwayAfter",
+		DefaultPresenceOptions.AwayAfter,
+		"inactivity before a connected user is shown away",
+	)
+
+	var presenceOfflineGrace time.Duration
+	app.RootCmd.PersistentFlags().DurationVar(
+		&presenceOfflineGrace,
+		"presenceOfflineGrace",
+		DefaultPresenceOptions.OfflineGrace,
+		"delay after the last connection drops before the user is shown offline",
+	)
+
+	var locationHistoryInterval time.Duration
+	app.RootCmd.PersistentFlags().DurationVar(
+		&locationHistoryInterval,
+		"locationHistoryInterval",
+		DefaultLocationHistoryOptions.Interval,
+		"minimum delay between two recorded positions of a user",
+	)
+
+	var locationHistoryDistance float64
+	app.RootCmd.PersistentFlags().Float64Var(
+		&locationHistoryDistance,
+		"locationHistoryDistance",
+		DefaultLocationHistoryOptions.Distance,
+		"minimum distance in meters between two recorded positions of a user",
+	)
+
+	var locationHistoryRetention time.Duration
+	app.RootCmd.PersistentFlags().DurationVar(
+		&locationHistoryRetention,
+		"locationHistoryRetention",
+		DefaultLocationHistoryOptions.Retention,
+		"how long recorded positions are kept (0 keeps them forever)",
+	)
+
+	var rpcConcurrency int
+	app.RootCmd.PersistentFlags().IntVar(
+		&rpcConcurrency,
+		"rpcConcurrency",
+		DefaultRPCLimits.Concurrency,
+		"API requests run at the same time per user over the DataChannel and WebSocket",
+	)
+
+	var rpcQueueSize int
+	app.RootCmd.PersistentFlags().IntVar(
+		&rpcQueueSize,
+		"rpcQueueSize",
+		DefaultRPCLimits.QueueSize,
+		"API requests waiting per user before answering 429",
+	)
+
+	var rpcTimeout time.Duration
+	app.RootCmd.PersistentFlags().DurationVar(
+		&rpcTimeout,
+		"rpcTimeout",
+		DefaultRPCLimits.Timeout,
+		"server deadline of the API requests received over the user channels",
+	)
+
 	// set commandes
 
 	// migrate command (with js templates)
@@ -728,11 +917,183 @@ func Execute() {
 			log.Println("Follow/Room collections setup:", err)
 		}
 
+		// Setup calls collection
+		if err := SetupCallCollections(app); err != nil {
+			log.Println("Calls collection setup:", err)
+		}
+
+		// Setup room analytics collection
+		if err := SetupRoomAnalyticsCollections(app); err != nil {
+			log.Println("Room analytics collection setup:", err)
+		}
+
+		// Setup topic rules collection
+		if err := SetupTopicRulesCollection(app); err != nil {
+			log.Println("Topic rules collection setup:", err)
+		}
+
+		// Setup rate limit rules collection
+		if err := SetupRateLimitRulesCollection(app); err != nil {
+			log.Println("Rate limit rules collection setup:", err)
+		}
+
+		// Setup location privacy collection
+		if err := SetupLocationPrivacyCollection(app); err != nil {
+			log.Println("Location privacy collection setup:", err)
+		}
+
+		// Setup Web Push collections
+		if err := SetupPushCollections(app); err != nil {
+			log.Println("Push collections setup:", err)
+		}
+
+		// Setup notifications collection
+		if err := SetupNotificationsCollection(app); err != nil {
+			log.Println("Notifications collection setup:", err)
+		}
+
+		// Backpressure defaults
+		policy, policyErr := ParseBackpressurePolicy(ssePolicy)
+		if policyErr == nil && policy == PolicyBlock {
+			policyErr = errClientBlockPolicy
+		}
+		if policyErr != nil {
+			log.Fatal(policyErr)
+		}
+		DefaultSubscribeOptions = resolveSubscribeOptions([]SubscribeOptions{{
+			BufferSize:   sseBufferSize,
+			Policy:       policy,
+			BlockTimeout: sseBlockTimeout,
+		}})
+
+		// Channel RPC limits, invalid values keep the defaults
+		if rpcConcurrency > 0 {
+			DefaultRPCLimits.Concurrency = rpcConcurrency
+		}
+		if rpcQueueSize > 0 {
+			DefaultRPCLimits.QueueSize = rpcQueueSize
+		}
+		if rpcTimeout > 0 {
+			DefaultRPCLimits.Timeout = rpcTimeout
+		}
+
+		// Multi-node PubSub
+		var brokerAddr string
+		var brokerTLS *tls.Config
+		if pubsubBroker != "" {
+			serverTLS, err := brokerServerTLS(pubsubBrokerCert, pubsubBrokerKey)
+			if err != nil {
+				log.Fatal("Failed to start PubSub broker:", err)
+			}
+			if _, err := StartPubSubBroker(pubsubBroker, pubsubBrokerSecret, serverTLS); err != nil {
+				log.Fatal("Failed to start PubSub broker:", err)
+			}
+			if pubsubBrokerURL == "" {
+				brokerAddr = pubsubBroker
+				if serverTLS != nil {
+					brokerTLS = pinnedBrokerTLS(serverTLS)
+				}
+			}
+		}
+		if pubsubBrokerURL != "" {
+			var err error
+			if brokerAddr, brokerTLS, err = brokerClientTLS(pubsubBrokerURL, pubsubBrokerCA); err != nil {
+				log.Fatal("Failed to connect to the PubSub broker:", err)
+			}
+		}
+		var brokerPubSub *BrokerPubSub
+		if brokerAddr != "" {
+			if local, ok := pubsub.(*MemoryPubSub); ok {
+				var err error
+				if brokerPubSub, err = NewBrokerPubSub(local, brokerAddr, pubsubBrokerSecret, brokerTLS); err != nil {
+					log.Fatal("Failed to connect to the PubSub broker:", err)
+				}
+				pubsub = brokerPubSub
+			}
+		}
+
+		// Persisted event log for SSE replay
+		var eventStore *RecordEventStore
+		if persistEvents {
+			if err := SetupEventLogCollection(app); err != nil {
+				log.Println("Event log collection setup:", err)
+			}
+			eventStore = NewRecordEventStore(app)
+			pubsub.SetEventStore(eventStore)
+		}
+
+		// Initialiser les règles d'accès aux topics
+		topicAuthorizer = NewTopicAuthorizer(app)
+
+		// Initialiser le rate limiting
+		rateLimiter = NewRateLimiter(app)
+
 		// Initialiser le Location Manager
 		locationManager = NewLocationManager(app)
+		locationManager.LoadGeoFences()
+		locationPrivacy = NewLocationPrivacyStore(app)
+		locationHistory := NewLocationHistory(app, LocationHistoryOptions{
+			Interval:  locationHistoryInterval,
+			Distance:  locationHistoryDistance,
+			Retention: locationHistoryRetention,
+		})
+		locationManager.SetHistory(locationHistory)
+
+		go func() {
+			ticker := time.NewTicker(1 * time.Hour)
+			defer ticker.Stop()
+			for range ticker.C {
+				locationHistory.Cleanup()
+			}
+		}()
 
 		// Initialiser le User Channel Manager
 		userChannelManager = NewUserChannelManager(app)
+		if brokerPubSub != nil {
+			userChannelManager.EnableRelay(brokerPubSub)
+		}
+		routerBridge = NewRouterBridge(app)
+		presenceTracker = NewPresenceTracker(app, PresenceOptions{
+			AwayAfter:    presenceAwayAfter,
+			OfflineGrace: presenceOfflineGrace,
+		})
+		if err := presenceTracker.ResetPersisted(); err != nil {
+			log.Println("Presence reset:", err)
+		}
+		if eventStore != nil {
+			userChannelManager.SetEventStore(eventStore)
+
+			go func() {
+				ticker := time.NewTicker(1 * time.Hour)
+				defer ticker.Stop()
+				for range ticker.C {
+					eventStore.Cleanup(24 * time.Hour)
+				}
+			}()
+		}
+
+		// Initialiser Web Push
+		vapidKeys := VAPIDKeys{PrivateKey: vapidPrivateKey}
+		if vapidPrivateKey == "" {
+			var keysErr error
+			if vapidKeys, keysErr = LoadOrCreateVAPIDKeys(app); keysErr != nil {
+				log.Println("VAPID keys:", keysErr)
+			}
+		}
+		if service, pushErr := NewWebPushService(app, vapidKeys, vapidSubject); pushErr != nil {
+			log.Println("Web Push disabled:", pushErr)
+		} else {
+			webPush = service
+		}
+
+		// Initialiser le Call Manager
+		callManager = NewCallManager(app)
+
+		// Initialiser les Room Analytics
+		roomAnalytics = NewRoomAnalytics(app)
+		if err := roomAnalytics.CloseOrphanedSessions(); err != nil {
+			log.Println("Error closing orphaned room sessions:", err)
+		}
 
 		// Initialiser le Script Manager
 		var err error
@@ -784,6 +1145,20 @@ func Execute() {
 			return handleGetLocation(c)
 		}).Bind(apis.RequireAuth())
 
+		// Location privacy settings
+		e.Router.GET("/api/location/privacy", func(c *core.RequestEvent) error {
+			return handleGetLocationPrivacy(c)
+		}).Bind(apis.RequireAuth())
+
+		e.Router.PUT("/api/location/privacy", func(c *core.RequestEvent) error {
+			return handleUpdateLocationPrivacy(c)
+		}).Bind(apis.RequireAuth())
+
+		// Track of a user, ?format=gpx|geojson
+		e.Router.GET("/api/location/history/{userId}", func(c *core.RequestEvent) error {
+			return handleGetLocationHistory(c)
+		}).Bind(apis.RequireAuth())
+
 		// Find nearby users
 		e.Router.POST("/api/location/nearby", func(c *core.RequestEvent) error {
 			return handleFindNearby(c)
@@ -846,6 +1221,103 @@ func Execute() {
 			return handleUserRoomAnswer(c)
 		}).Bind(apis.RequireAuth())
 
+		// Active sessions of the user
+		e.Router.GET("/api/user/sessions", func(c *core.RequestEvent) error {
+			return handleUserSessions(c)
+		}).Bind(apis.RequireAuth())
+
+		// Update presence status
+		e.Router.POST("/api/presence/update", func(c *core.RequestEvent) error {
+			return handleUpdatePresence(c)
+		}).Bind(apis.RequireAuth())
+
+		// Presence heartbeat (SSE and HTTP-only clients)
+		e.Router.POST("/api/presence/heartbeat", func(c *core.RequestEvent) error {
+			return handlePresenceHeartbeat(c)
+		}).Bind(apis.RequireAuth())
+
+		// Followed users currently online, away or busy
+		e.Router.GET("/api/user/online-following", func(c *core.RequestEvent) error {
+			return handleGetOnlineFollowing(c)
+		}).Bind(apis.RequireAuth())
+
+		// ==================== NOTIFICATION ROUTES ====================
+
+		e.Router.GET("/api/notifications", func(c *core.RequestEvent) error {
+			return handleListNotifications(c)
+		}).Bind(apis.RequireAuth())
+
+		e.Router.GET("/api/notifications/unread-count", func(c *core.RequestEvent) error {
+			return handleUnreadCount(c)
+		}).Bind(apis.RequireAuth())
+
+		e.Router.POST("/api/notifications/read-all", func(c *core.RequestEvent) error {
+			return handleMarkAllNotificationsRead(c)
+		}).Bind(apis.RequireAuth())
+
+		e.Router.POST("/api/notifications/{notificationId}/read", func(c *core.RequestEvent) error {
+			return handleMarkNotificationRead(c)
+		}).Bind(apis.RequireAuth())
+
+		// ==================== WEB PUSH ROUTES ====================
+
+		e.Router.GET("/api/push/vapid-public-key", func(c *core.RequestEvent) error {
+			return handleVAPIDPublicKey(c)
+		})
+
+		e.Router.POST("/api/push/subscriptions", func(c *core.RequestEvent) error {
+			return handleSubscribePush(c)
+		}).Bind(apis.RequireAuth())
+
+		e.Router.DELETE("/api/push/subscriptions", func(c *core.RequestEvent) error {
+			return handleUnsubscribePush(c)
+		}).Bind(apis.RequireAuth())
+
+		e.Router.GET("/api/user/push-settings", func(c *core.RequestEvent) error {
+			return handleGetPushSettings(c)
+		}).Bind(apis.RequireAuth())
+
+		e.Router.PUT("/api/user/push-settings", func(c *core.RequestEvent) error {
+			return handleUpdatePushSettings(c)
+		}).Bind(apis.RequireAuth())
+
+		// ==================== CALL ROUTES ====================
+
+		// Start a call
+		e.Router.POST("/api/calls", func(c *core.RequestEvent) error {
+			return handleStartCall(c)
+		}).Bind(apis.RequireAuth())
+
+		// Call history (?status=missed)
+		e.Router.GET("/api/calls", func(c *core.RequestEvent) error {
+			return handleGetCallHistory(c)
+		}).Bind(apis.RequireAuth())
+
+		// Current call
+		e.Router.GET("/api/calls/active", func(c *core.RequestEvent) error {
+			return handleGetActiveCall(c)
+		}).Bind(apis.RequireAuth())
+
+		// Accept call
+		e.Router.POST("/api/calls/{callId}/accept", func(c *core.RequestEvent) error {
+			return handleAcceptCall(c)
+		}).Bind(apis.RequireAuth())
+
+		// Decline call
+		e.Router.POST("/api/calls/{callId}/decline", func(c *core.RequestEvent) error {
+			return handleDeclineCall(c)
+		}).Bind(apis.RequireAuth())
+
+		// Cancel call
+		e.Router.POST("/api/calls/{callId}/cancel", func(c *core.RequestEvent) error {
+			return handleCancelCall(c)
+		}).Bind(apis.RequireAuth())
+
+		// End call
+		e.Router.POST("/api/calls/{callId}/end", func(c *core.RequestEvent) error {
+			return handleEndCall(c)
+		}).Bind(apis.RequireAuth())
+
 		// ==================== FOLLOW/FOLLOWER ROUTES ====================
 
 		// Get follow settings
@@ -955,6 +1427,18 @@ func Execute() {
 			return handleUpdateRoomSettings(c)
 		}).Bind(apis.RequireAuth())
 
+		// ==================== ROOM ANALYTICS ROUTES ====================
+
+		// Room usage report (owner), ?format=csv
+		e.Router.GET("/api/rooms/{roomId}/analytics", func(c *core.RequestEvent) error {
+			return handleGetRoomAnalytics(c)
+		}).Bind(apis.RequireAuth())
+
+		// Global usage report, ?format=csv
+		e.Router.GET("/api/analytics/rooms", func(c *core.RequestEvent) error {
+			return handleGetGlobalRoomAnalytics(c)
+		}).Bind(apis.RequireSuperuserAuth())
+
 		// Script Management Routes (optional, pour debug)
 		e.Router.GET("/api/scripts/status", func(c *core.RequestEvent) error {
 			if scriptManager == nil {
@@ -992,33 +1476,63 @@ func Execute() {
 
 		// SSE for real-time events
 		e.Router.GET("/api/events/{topic}", func(c *core.RequestEvent) error {
-			topic := c.Request.PathValue("topic")
-
-			c.Response.Header().Set("Content-Type", "text/event-stream")
-			c.Response.Header().Set("Cache-Control", "no-cache")
-			c.Response.Header().Set("Connection", "keep-alive")
+			return handleTopicEvents(c)
+		})
 
-			ch := pubsub.Subscribe(topic)
-			defer close(ch)
+		// WebSocket transport (topics, user channel, API requests)
+		e.Router.GET("/api/ws", func(c *core.RequestEvent) error {
+			return handleWebSocket(c)
+		})
 
-			for msg := range ch {
-				data, _ := json.Marshal(msg.Payload)
-				c.Response.Write([]byte("data: " + string(data) + "\n\n"))
-				if f, ok := c.Response.(http.Flusher); ok {
-					f.Flush()
-				}
-				// c.Response.Flush()
-			}
+		// Subscribers per topic
+		e.Router.GET("/api/pubsub/stats", func(c *core.RequestEvent) error {
+			return handleTopicStats(c)
+		}).Bind(apis.RequireSuperuserAuth())
 
-			return nil
-		})
+		// Rate limit counters per route group
+		e.Router.GET("/api/ratelimit/stats", func(c *core.RequestEvent) error {
+			return handleRateLimitStats(c)
+		}).Bind(apis.RequireSuperuserAuth())
 
 		// OpenAPI/Swagger endpoint
 		e.Router.GET("/api/openapi", func(c *core.RequestEvent) error {
 			return c.JSON(200, getOpenAPISpec())
 		})
 
-		return e.Next()
+		// Expose the authenticated record id to the handlers
+		e.Router.Bind(&hook.Handler[*core.RequestEvent]{
+			Id: "taniaUserID",
+			Func: func(c *core.RequestEvent) error {
+				if c.Auth != nil {
+					c.Set("userID", c.Auth.Id)
+				}
+				return c.Next()
+			},
+			Priority: apis.DefaultLoadAuthTokenMiddlewarePriority + 1,
+		})
+
+		// Token buckets per route group, also applied to the user channel APIRequests
+		e.Router.Bind(&hook.Handler[*core.RequestEvent]{
+			Id:       "taniaRateLimit",
+			Func:     rateLimitMiddleware,
+			Priority: apis.DefaultLoadAuthTokenMiddlewarePriority + 2,
+		})
+
+		// ?respond_to=sse|room: 202, then the response on the user channel
+		e.Router.Bind(&hook.Handler[*core.RequestEvent]{
+			Id:       "taniaRespondVia",
+			Func:     respondViaChannelMiddleware,
+			Priority: apis.DefaultLoadAuthTokenMiddlewarePriority + 3,
+		})
+
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		// The router is built by now, APIRequests from the user channels go through it
+		routerBridge.SetHandler(e.Server.Handler)
+
+		return nil
 	})
 
 	// Periodic task: check expired subscriptions (every hour)
@@ -1030,6 +1544,78 @@ func Execute() {
 		}
 	}()
 
+	// Periodic task: away/offline presence (every 30s)
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if presenceTracker != nil {
+				presenceTracker.Sweep()
+			}
+		}
+	}()
+
+	// Reload topic rules when they change
+	reloadTopicRules := func(e *core.RecordEvent) error {
+		if topicAuthorizer != nil {
+			topicAuthorizer.Reload()
+		}
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess("topicRules").BindFunc(reloadTopicRules)
+	app.OnRecordAfterUpdateSuccess("topicRules").BindFunc(reloadTopicRules)
+	app.OnRecordAfterDeleteSuccess("topicRules").BindFunc(reloadTopicRules)
+
+	reloadRateLimitRules := func(e *core.RecordEvent) error {
+		if rateLimiter != nil {
+			rateLimiter.Reload()
+		}
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess("rateLimitRules").BindFunc(reloadRateLimitRules)
+	app.OnRecordAfterUpdateSuccess("rateLimitRules").BindFunc(reloadRateLimitRules)
+	app.OnRecordAfterDeleteSuccess("rateLimitRules").BindFunc(reloadRateLimitRules)
+
+	// Privacy settings changed (API or admin UI)
+	invalidateLocationPrivacy := func(e *core.RecordEvent) error {
+		locationPrivacy.Invalidate(e.Record.GetString("user"))
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess("locationPrivacy").BindFunc(invalidateLocationPrivacy)
+	app.OnRecordAfterUpdateSuccess("locationPrivacy").BindFunc(invalidateLocationPrivacy)
+	app.OnRecordAfterDeleteSuccess("locationPrivacy").BindFunc(invalidateLocationPrivacy)
+
+	// Geofences saved through the records API (admin UI): a geometry the
+	// LocationManager can read
+	validateGeoFence := func(e *core.RecordRequestEvent) error {
+		if err := validateGeoFenceRecord(e.Record); err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("geofences").BindFunc(validateGeoFence)
+	app.OnRecordUpdateRequest("geofences").BindFunc(validateGeoFence)
+
+	// Keep the geofences of the LocationManager in sync (API and admin UI)
+	app.OnRecordAfterCreateSuccess("geofences").BindFunc(func(e *core.RecordEvent) error {
+		if locationManager != nil {
+			locationManager.SyncGeoFence(e.Record, false)
+		}
+		return e.Next()
+	})
+	app.OnRecordAfterUpdateSuccess("geofences").BindFunc(func(e *core.RecordEvent) error {
+		if locationManager != nil {
+			locationManager.SyncGeoFence(e.Record, false)
+		}
+		return e.Next()
+	})
+	app.OnRecordAfterDeleteSuccess("geofences").BindFunc(func(e *core.RecordEvent) error {
+		if locationManager != nil {
+			locationManager.SyncGeoFence(e.Record, true)
+		}
+		return e.Next()
+	})
+
 	// Record hooks for real-time events
 	app.OnRecordAfterCreateSuccess("posts").BindFunc(func(e *core.RecordEvent) error {
 		payload := map[string]interface{}{
@@ -1068,8 +1654,7 @@ func Execute() {
 		post, _ := app.FindRecordById("posts", e.Record.GetString("post"))
 		if post != nil {
 			postOwner := post.GetString("user")
-			userChannelManager.SendToSSE(postOwner, "post_liked", payload, "")
-			userChannelManager.SendToUserRoom(postOwner, "notification", payload, "")
+			userChannelManager.Notify(postOwner, "post_liked", payload)
 		}
 
 		return nil
@@ -1093,8 +1678,7 @@ func Execute() {
 		post, _ := app.FindRecordById("posts", e.Record.GetString("post"))
 		if post != nil {
 			postOwner := post.GetString("user")
-			userChannelManager.SendToSSE(postOwner, "post_commented", payload, "")
-			userChannelManager.SendToUserRoom(postOwner, "notification", payload, "")
+			userChannelManager.Notify(postOwner, "post_commented", payload)
 		}
 
 		return nil
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ==================== BACKPRESSURE POLICIES ====================
+
+// BackpressurePolicy - Comportement quand le buffer d'un abonné est plein
+type BackpressurePolicy string
+
+const (
+	PolicyDropNewest BackpressurePolicy = "drop_newest" // le nouveau message est perdu
+	PolicyDropOldest BackpressurePolicy = "drop_oldest" // les plus anciens sont évincés
+	PolicyBlock      BackpressurePolicy = "block"       // attend BlockTimeout puis abandonne
+	PolicyDisconnect BackpressurePolicy = "disconnect"  // l'abonné lent est déconnecté
+)
+
+const maxSubscriptionBuffer = 10000
+
+// block makes the publisher wait for the subscriber: a slow client would
+// stall every publisher of its topics, only server side subscriptions use it
+var errClientBlockPolicy = errors.New("the block policy is reserved to server side subscriptions")
+
+// Reason given to a consumer disconnected by PolicyDisconnect
+const reasonSlowConsumer = "slow_consumer"
+
+type SubscribeOptions struct {
+	BufferSize   int
+	Policy       BackpressurePolicy
+	BlockTimeout time.Duration
+	Filter       *EventFilter // messages not matching are never queued
+}
+
+// Defaults, overridden by the --sseBufferSize, --ssePolicy and --sseBlockTimeout flags
+var DefaultSubscribeOptions = SubscribeOptions{
+	BufferSize:   100,
+	Policy:       PolicyDropNewest,
+	BlockTimeout: time.Second,
+}
+
+func ParseBackpressurePolicy(value string) (BackpressurePolicy, error) {
+	switch policy := BackpressurePolicy(value); policy {
+	case PolicyDropNewest, PolicyDropOldest, PolicyBlock, PolicyDisconnect:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid backpressure policy %q (drop_newest, drop_oldest, block, disconnect)", value)
+}
+
+// Fill the unset options with the defaults
+func resolveSubscribeOptions(opts []SubscribeOptions) SubscribeOptions {
+	resolved := DefaultSubscribeOptions
+	if len(opts) == 0 {
+		return resolved
+	}
+
+	if opts[0].BufferSize > 0 {
+		resolved.BufferSize = opts[0].BufferSize
+	}
+	if resolved.BufferSize > maxSubscriptionBuffer {
+		resolved.BufferSize = maxSubscriptionBuffer
+	}
+	if opts[0].Policy != "" {
+		resolved.Policy = opts[0].Policy
+	}
+	if opts[0].BlockTimeout > 0 {
+		resolved.BlockTimeout = opts[0].BlockTimeout
+	}
+	resolved.Filter = opts[0].Filter
+	return resolved
+}
+
+// Options from ?buffer=500&policy=drop_oldest&filter=...
+func subscribeOptionsFromRequest(c *core.RequestEvent) (SubscribeOptions, error) {
+	var opts SubscribeOptions
+	query := c.Request.URL.Query()
+
+	if value := query.Get("buffer"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return opts, fmt.Errorf("invalid buffer size %q", value)
+		}
+		opts.BufferSize = size
+	}
+
+	if value := query.Get("policy"); value != "" {
+		policy, err := ParseBackpressurePolicy(value)
+		if err != nil {
+			return opts, err
+		}
+		if policy == PolicyBlock {
+			return opts, errClientBlockPolicy
+		}
+		opts.Policy = policy
+	}
+
+	filter, err := CompileEventFilter(query.Get("filter"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Filter = filter
+
+	return resolveSubscribeOptions([]SubscribeOptions{opts}), nil
+}
+
+// Offer a message to a bounded channel according to the policy.
+// The caller must prevent ch from being closed meanwhile.
+// Returns the number of dropped messages and whether the consumer must be disconnected.
+func offer[T any](ch chan T, msg T, opts SubscribeOptions) (int, bool) {
+	select {
+	case ch <- msg:
+		return 0, false
+	default:
+	}
+
+	switch opts.Policy {
+	case PolicyDropOldest:
+		dropped := 0
+		for {
+			select {
+			case <-ch:
+				dropped++
+			default:
+			}
+			select {
+			case ch <- msg:
+				return dropped, false
+			default:
+			}
+		}
+
+	case PolicyBlock:
+		timer := time.NewTimer(opts.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case ch <- msg:
+			return 0, false
+		case <-timer.C:
+			return 1, false
+		}
+
+	case PolicyDisconnect:
+		return 1, true
+	}
+
+	return 1, false
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfferPolicies(t *testing.T) {
+	full := func() chan int {
+		ch := make(chan int, 2)
+		ch <- 1
+		ch <- 2
+		return ch
+	}
+
+	ch := full()
+	dropped, disconnect := offer(ch, 3, SubscribeOptions{Policy: PolicyDropNewest})
+	assert.Equal(t, 1, dropped)
+	assert.False(t, disconnect)
+	assert.Equal(t, []int{1, 2}, []int{<-ch, <-ch})
+
+	ch = full()
+	dropped, disconnect = offer(ch, 3, SubscribeOptions{Policy: PolicyDropOldest})
+	assert.Equal(t, 1, dropped)
+	assert.False(t, disconnect)
+	assert.Equal(t, []int{2, 3}, []int{<-ch, <-ch})
+
+	ch = full()
+	start := time.Now()
+	dropped, _ = offer(ch, 3, SubscribeOptions{Policy: PolicyBlock, BlockTimeout: 20 * time.Millisecond})
+	assert.Equal(t, 1, dropped)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	ch = full()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-ch
+	}()
+	dropped, _ = offer(ch, 3, SubscribeOptions{Policy: PolicyBlock, BlockTimeout: time.Second})
+	assert.Equal(t, 0, dropped)
+
+	ch = full()
+	dropped, disconnect = offer(ch, 3, SubscribeOptions{Policy: PolicyDisconnect})
+	assert.Equal(t, 1, dropped)
+	assert.True(t, disconnect)
+}
+
+func TestClientsCannotBlock(t *testing.T) {
+	options := func(query string) (SubscribeOptions, error) {
+		c := &core.RequestEvent{Event: router.Event{Request: httptest.NewRequest("GET", "/api/events/x?"+query, nil)}}
+		return subscribeOptionsFromRequest(c)
+	}
+
+	_, err := options("policy=block")
+	assert.ErrorIs(t, err, errClientBlockPolicy)
+
+	opts, err := options("policy=drop_oldest&buffer=5")
+	require.NoError(t, err)
+	assert.Equal(t, PolicyDropOldest, opts.Policy)
+	assert.Equal(t, 5, opts.BufferSize)
+}
+
+func TestRoomBroadcastOutsideLock(t *testing.T) {
+	saved := pubsub
+	pubsub = NewMemoryPubSub()
+	t.Cleanup(func() { pubsub = saved })
+
+	room := &Room{ID: "r1", Participants: make(map[string]*Participant)}
+
+	// A server side subscriber that stalls the publisher
+	sub := pubsub.Subscribe(RoomTopic("r1", "participant_joined"), SubscribeOptions{
+		BufferSize:   1,
+		Policy:       PolicyBlock,
+		BlockTimeout: 500 * time.Millisecond,
+	})
+	defer sub.Unsubscribe()
+	pubsub.Publish(RoomTopic("r1", "participant_joined"), PubSubMessage{})
+
+	added := make(chan struct{})
+	go func() {
+		room.AddParticipant(&Participant{ID: "p1", UserID: "alice"})
+		close(added)
+	}()
+
+	// The room stays usable while the publish waits
+	time.Sleep(50 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		room.mu.Lock()
+		_, exists := room.Participants["p1"]
+		room.mu.Unlock()
+		assert.True(t, exists)
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("room.mu held while publishing")
+	}
+	<-added
+}
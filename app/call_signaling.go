@@ -0,0 +1,575 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ==================== CALL TYPES ====================
+
+type CallStatus string
+
+const (
+	CallStatusRinging   CallStatus = "ringing"
+	CallStatusAccepted  CallStatus = "accepted"
+	CallStatusDeclined  CallStatus = "declined"
+	CallStatusBusy      CallStatus = "busy"
+	CallStatusMissed    CallStatus = "missed"
+	CallStatusCancelled CallStatus = "cancelled"
+	CallStatusEnded     CallStatus = "ended"
+)
+
+// Call - Appel one-to-one entre deux utilisateurs
+type Call struct {
+	ID         string     `json:"id"`
+	CallerID   string     `json:"caller_id"`
+	CalleeID   string     `json:"callee_id"`
+	CallType   string     `json:"call_type"` // audio, video
+	Status     CallStatus `json:"status"`
+	RoomID     string     `json:"room_id,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	AnsweredAt time.Time  `json:"answered_at,omitempty"`
+	EndedAt    time.Time  `json:"ended_at,omitempty"`
+
+	recordID string     // guarded by saving
+	saving   sync.Mutex // one save of the call at a time
+	timer    *time.Timer
+}
+
+func (call *Call) isActive() bool {
+	return call.Status == CallStatusRinging || call.Status == CallStatusAccepted
+}
+
+// Copy of the call state, taken under CallManager.mu
+func (call *Call) snapshot() *Call {
+	return &Call{
+		ID:         call.ID,
+		CallerID:   call.CallerID,
+		CalleeID:   call.CalleeID,
+		CallType:   call.CallType,
+		Status:     call.Status,
+		RoomID:     call.RoomID,
+		StartedAt:  call.StartedAt,
+		AnsweredAt: call.AnsweredAt,
+		EndedAt:    call.EndedAt,
+	}
+}
+
+func (call *Call) toMap() map[string]interface{} {
+	result := map[string]interface{}{
+		"call_id":    call.ID,
+		"caller_id":  call.CallerID,
+		"callee_id":  call.CalleeID,
+		"call_type":  call.CallType,
+		"status":     call.Status,
+		"started_at": call.StartedAt.Unix(),
+	}
+	if call.RoomID != "" {
+		result["room_id"] = call.RoomID
+	}
+	return result
+}
+
+// Errors mapped to 404 and 403 by the handlers
+var (
+	errCallNotFound         = errors.New("call not found")
+	errCallPermissionDenied = errors.New("permission denied")
+)
+
+// ==================== CALL MANAGER ====================
+
+type CallManager struct {
+	calls       map[string]*Call  // callID -> call
+	userCalls   map[string]string // userID -> active callID
+	ringTimeout time.Duration
+	app         core.App
+	mu          sync.Mutex
+}
+
+func NewCallManager(app core.App) *CallManager {
+	return &CallManager{
+		calls:       make(map[string]*Call),
+		userCalls:   make(map[string]string),
+		ringTimeout: 30 * time.Second,
+		app:         app,
+	}
+}
+
+// Start a call: the callee is rung on their SSE channel and user room
+func (cm *CallManager) StartCall(callerID, calleeID, callType string) (*Call, error) {
+	if callerID == calleeID {
+		return nil, fmt.Errorf("cannot call yourself")
+	}
+	if callType == "" {
+		callType = "audio"
+	}
+	if callType != "audio" && callType != "video" {
+		return nil, fmt.Errorf("invalid call type: %s", callType)
+	}
+
+	call := &Call{
+		ID:        generateID(),
+		CallerID:  callerID,
+		CalleeID:  calleeID,
+		CallType:  callType,
+		Status:    CallStatusRinging,
+		StartedAt: time.Now(),
+	}
+
+	cm.mu.Lock()
+	if _, inCall := cm.userCalls[callerID]; inCall {
+		cm.mu.Unlock()
+		return nil, fmt.Errorf("caller already in a call")
+	}
+
+	// Callee already in a call: the attempt is recorded as busy
+	if _, inCall := cm.userCalls[calleeID]; inCall {
+		call.Status = CallStatusBusy
+		call.EndedAt = call.StartedAt
+		cm.mu.Unlock()
+
+		cm.persist(call)
+		signalUser(callerID, "call_busy", call.toMap())
+		return call, nil
+	}
+
+	// Saved before any answer or timeout can save it again
+	call.saving.Lock()
+
+	cm.calls[call.ID] = call
+	cm.userCalls[callerID] = call.ID
+	cm.userCalls[calleeID] = call.ID
+	call.timer = time.AfterFunc(cm.ringTimeout, func() {
+		cm.handleRingTimeout(call.ID)
+	})
+	state := call.snapshot()
+	cm.mu.Unlock()
+
+	cm.saveLocked(call, state)
+	call.saving.Unlock()
+
+	signalUser(calleeID, "call_incoming", state.toMap())
+	log.Printf("📞 Call %s: %s -> %s (%s)", call.ID, callerID, calleeID, callType)
+
+	return state, nil
+}
+
+// Accept a ringing call and create a private room for both users
+func (cm *CallManager) AcceptCall(callID, userID string) (*Call, error) {
+	cm.mu.Lock()
+	call, err := cm.getCallLocked(callID, userID)
+	if err != nil {
+		cm.mu.Unlock()
+		return nil, err
+	}
+	if call.CalleeID != userID {
+		cm.mu.Unlock()
+		return nil, fmt.Errorf("only the callee can accept")
+	}
+	if call.Status != CallStatusRinging {
+		cm.mu.Unlock()
+		return nil, fmt.Errorf("call is not ringing")
+	}
+
+	call.timer.Stop()
+	call.Status = CallStatusAccepted
+	call.AnsweredAt = time.Now()
+	call.RoomID = generateID()
+
+	room := getOrCreateRoom(call.RoomID, call.CallType)
+	room.mu.Lock()
+	room.AllowedUsers = map[string]bool{
+		call.CallerID: true,
+		call.CalleeID: true,
+	}
+	room.mu.Unlock()
+	state := call.snapshot()
+	cm.mu.Unlock()
+
+	cm.persist(call)
+	signalUser(state.CallerID, "call_accepted", state.toMap())
+
+	return state, nil
+}
+
+// Decline a ringing call
+func (cm *CallManager) DeclineCall(callID, userID string) (*Call, error) {
+	cm.mu.Lock()
+	call, err := cm.getCallLocked(callID, userID)
+	if err != nil {
+		cm.mu.Unlock()
+		return nil, err
+	}
+	if call.CalleeID != userID {
+		cm.mu.Unlock()
+		return nil, fmt.Errorf("only the callee can decline")
+	}
+	if call.Status != CallStatusRinging {
+		cm.mu.Unlock()
+		return nil, fmt.Errorf("call is not ringing")
+	}
+
+	cm.finishLocked(call, CallStatusDeclined)
+	state := call.snapshot()
+	cm.mu.Unlock()
+
+	cm.persist(call)
+	signalUser(state.CallerID, "call_declined", state.toMap())
+
+	return state, nil
+}
+
+// Cancel a ringing call (caller hangs up before answer)
+func (cm *CallManager) CancelCall(callID, userID string) (*Call, error) {
+	cm.mu.Lock()
+	call, err := cm.getCallLocked(callID, userID)
+	if err != nil {
+		cm.mu.Unlock()
+		return nil, err
+	}
+	if call.CallerID != userID {
+		cm.mu.Unlock()
+		return nil, fmt.Errorf("only the caller can cancel")
+	}
+	if call.Status != CallStatusRinging {
+		cm.mu.Unlock()
+		return nil, fmt.Errorf("call is not ringing")
+	}
+
+	cm.finishLocked(call, CallStatusCancelled)
+	state := call.snapshot()
+	cm.mu.Unlock()
+
+	cm.persist(call)
+	signalUser(state.CalleeID, "call_cancelled", state.toMap())
+
+	return state, nil
+}
+
+// End an accepted call (either party hangs up)
+func (cm *CallManager) EndCall(callID, userID string) (*Call, error) {
+	cm.mu.Lock()
+	call, err := cm.getCallLocked(callID, userID)
+	if err != nil {
+		cm.mu.Unlock()
+		return nil, err
+	}
+	if call.Status != CallStatusAccepted {
+		cm.mu.Unlock()
+		return nil, fmt.Errorf("call is not in progress")
+	}
+
+	cm.finishLocked(call, CallStatusEnded)
+	state := call.snapshot()
+	cm.mu.Unlock()
+
+	closeCallRoom(state.RoomID)
+	cm.persist(call)
+
+	otherID := state.CallerID
+	if userID == state.CallerID {
+		otherID = state.CalleeID
+	}
+	signalUser(otherID, "call_ended", state.toMap())
+
+	return state, nil
+}
+
+// Get active call of a user
+func (cm *CallManager) GetActiveCall(userID string) (*Call, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	callID, exists := cm.userCalls[userID]
+	if !exists {
+		return nil, false
+	}
+	return cm.calls[callID].snapshot(), true
+}
+
+// Stop the ring timers of the pending calls (shutdown, tests)
+func (cm *CallManager) Close() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	for _, call := range cm.calls {
+		if call.timer != nil {
+			call.timer.Stop()
+		}
+	}
+}
+
+func (cm *CallManager) handleRingTimeout(callID string) {
+	cm.mu.Lock()
+	call, exists := cm.calls[callID]
+	if !exists || call.Status != CallStatusRinging {
+		cm.mu.Unlock()
+		return
+	}
+
+	cm.finishLocked(call, CallStatusMissed)
+	state := call.snapshot()
+	cm.mu.Unlock()
+
+	cm.persist(call)
+	signalUser(state.CallerID, "call_missed", state.toMap())
+	signalUser(state.CalleeID, "call_missed", state.toMap())
+
+	log.Printf("📵 Call %s missed (no answer from %s)", state.ID, state.CalleeID)
+}
+
+func (cm *CallManager) getCallLocked(callID, userID string) (*Call, error) {
+	call, exists := cm.calls[callID]
+	if !exists {
+		return nil, errCallNotFound
+	}
+	if call.CallerID != userID && call.CalleeID != userID {
+		return nil, errCallPermissionDenied
+	}
+	return call, nil
+}
+
+// Move the call to a final state and release both users
+func (cm *CallManager) finishLocked(call *Call, status CallStatus) {
+	if call.timer != nil {
+		call.timer.Stop()
+	}
+	call.Status = status
+	call.EndedAt = time.Now()
+
+	delete(cm.calls, call.ID)
+	if cm.userCalls[call.CallerID] == call.ID {
+		delete(cm.userCalls, call.CallerID)
+	}
+	if cm.userCalls[call.CalleeID] == call.ID {
+		delete(cm.userCalls, call.CalleeID)
+	}
+}
+
+// Save call to database, in its current state. The saves of a call are
+// serialized so that the first one creates the record and the others update it.
+func (cm *CallManager) persist(call *Call) {
+	call.saving.Lock()
+	defer call.saving.Unlock()
+
+	cm.mu.Lock()
+	state := call.snapshot()
+	cm.mu.Unlock()
+
+	cm.saveLocked(call, state)
+}
+
+// Save state as the record of call, call.saving held
+func (cm *CallManager) saveLocked(call *Call, state *Call) {
+	var record *core.Record
+	if call.recordID != "" {
+		record, _ = cm.app.FindRecordById("calls", call.recordID)
+	}
+	if record == nil {
+		collection, err := cm.app.FindCollectionByNameOrId("calls")
+		if err != nil {
+			log.Printf("Error saving call %s: %v", state.ID, err)
+			return
+		}
+		record = core.NewRecord(collection)
+		record.Set("callId", state.ID)
+		record.Set("caller", state.CallerID)
+		record.Set("callee", state.CalleeID)
+		record.Set("callType", state.CallType)
+		record.Set("startedAt", state.StartedAt)
+	}
+
+	record.Set("status", string(state.Status))
+	record.Set("roomId", state.RoomID)
+	if !state.AnsweredAt.IsZero() {
+		record.Set("answeredAt", state.AnsweredAt)
+	}
+	if !state.EndedAt.IsZero() {
+		record.Set("endedAt", state.EndedAt)
+		if !state.AnsweredAt.IsZero() {
+			record.Set("duration", int(state.EndedAt.Sub(state.AnsweredAt).Seconds()))
+		}
+	}
+
+	if err := cm.app.Save(record); err != nil {
+		log.Printf("Error saving call %s: %v", state.ID, err)
+		return
+	}
+	call.recordID = record.Id
+}
+
+// Send call signaling to both SSE channel and user room
+func signalUser(userID, eventType string, data map[string]interface{}) {
+	if userChannelManager == nil {
+		return
+	}
+	userChannelManager.SendToSSE(userID, eventType, data, "")
+	userChannelManager.SendToUserRoom(userID, eventType, data, "")
+}
+
+// Close the private room of a finished call
+func closeCallRoom(roomID string) {
+	if roomID == "" {
+		return
+	}
+
+	roomsMutex.Lock()
+	room, exists := rooms[roomID]
+	delete(rooms, roomID)
+	roomsMutex.Unlock()
+
+	if !exists {
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	for _, p := range room.Participants {
+		if p.PeerConn != nil {
+			p.PeerConn.Close()
+		}
+	}
+}
+
+// ==================== HTTP HANDLERS ====================
+
+// Start a call
+func handleStartCall(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	var req struct {
+		CalleeID string `json:"callee_id"`
+		CallType string `json:"call_type"`
+	}
+
+	if err := c.BindBody(&req); err != nil || req.CalleeID == "" {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	if _, err := c.App.FindRecordById("users", req.CalleeID); err != nil {
+		return c.JSON(404, map[string]string{"error": "user not found"})
+	}
+
+	call, err := callManager.StartCall(userID, req.CalleeID, req.CallType)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	if call.Status == CallStatusBusy {
+		return c.JSON(409, call.toMap())
+	}
+
+	return c.JSON(200, call.toMap())
+}
+
+// Accept a call
+func handleAcceptCall(c *core.RequestEvent) error {
+	return handleCallAction(c, callManager.AcceptCall)
+}
+
+// Decline a call
+func handleDeclineCall(c *core.RequestEvent) error {
+	return handleCallAction(c, callManager.DeclineCall)
+}
+
+// Cancel a call
+func handleCancelCall(c *core.RequestEvent) error {
+	return handleCallAction(c, callManager.CancelCall)
+}
+
+// End a call
+func handleEndCall(c *core.RequestEvent) error {
+	return handleCallAction(c, callManager.EndCall)
+}
+
+func handleCallAction(c *core.RequestEvent, action func(callID, userID string) (*Call, error)) error {
+	userID := c.Get("userID").(string)
+	callID := c.Request.PathValue("callId")
+
+	call, err := action(callID, userID)
+	if err != nil {
+		if errors.Is(err, errCallNotFound) {
+			return c.JSON(404, map[string]string{"error": err.Error()})
+		}
+		if errors.Is(err, errCallPermissionDenied) {
+			return c.JSON(403, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(200, call.toMap())
+}
+
+// Get active call
+func handleGetActiveCall(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	call, exists := callManager.GetActiveCall(userID)
+	if !exists {
+		return c.JSON(404, map[string]string{"error": "no active call"})
+	}
+
+	return c.JSON(200, call.toMap())
+}
+
+// Call history (filter by status, e.g. ?status=missed)
+func handleGetCallHistory(c *core.RequestEvent) error {
+	app := c.App
+	userID := c.Get("userID").(string)
+	status := c.Request.URL.Query().Get("status")
+
+	filter := "(caller = {:user} || callee = {:user})"
+	params := dbx.Params{"user": userID}
+	if status != "" {
+		filter += " && status = {:status}"
+		params["status"] = status
+	}
+
+	calls, err := app.FindRecordsByFilter("calls", filter, "-startedAt", 100, 0, params)
+	if err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	result := make([]map[string]interface{}, len(calls))
+	for i, call := range calls {
+		result[i] = recordToMap(call)
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"calls": result,
+		"count": len(result),
+	})
+}
+
+// ==================== SETUP COLLECTIONS ====================
+
+func SetupCallCollections(app core.App) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		calls := core.NewBaseCollection("calls")
+		calls.Fields.Add(
+			&core.TextField{Name: "callId", Required: true},
+			&core.RelationField{Name: "caller", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1},
+			&core.RelationField{Name: "callee", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1},
+			&core.SelectField{Name: "callType", Required: true, MaxSelect: 1, Values: []string{"audio", "video"}},
+			&core.SelectField{Name: "status", Required: true, MaxSelect: 1, Values: []string{"ringing", "accepted", "declined", "busy", "missed", "cancelled", "ended"}},
+			&core.TextField{Name: "roomId"},
+			&core.DateField{Name: "startedAt"},
+			&core.DateField{Name: "answeredAt"},
+			&core.DateField{Name: "endedAt"},
+			&core.NumberField{Name: "duration"},
+		)
+		calls.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_calls_call_id ON calls (callId)",
+			"CREATE INDEX idx_calls_caller ON calls (caller)",
+			"CREATE INDEX idx_calls_callee ON calls (callee)",
+		}
+
+		return txApp.Save(calls)
+	})
+}
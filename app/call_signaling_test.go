@@ -0,0 +1,260 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCallManager(t *testing.T) (*tests.TestApp, *CallManager) {
+	app := newTestApp(t)
+	require.NoError(t, SetupCallCollections(app))
+
+	// The ring timers would fire after the app is torn down
+	cm := NewCallManager(app)
+	t.Cleanup(cm.Close)
+
+	return app, cm
+}
+
+func persistedCallStatus(t *testing.T, app core.App, callID string) string {
+	record, err := app.FindFirstRecordByData("calls", "callId", callID)
+	require.NoError(t, err)
+	return record.GetString("status")
+}
+
+func TestCallRingAndAccept(t *testing.T) {
+	app, cm := newTestCallManager(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	call, err := cm.StartCall(alice, bob, "video")
+	require.NoError(t, err)
+	assert.Equal(t, CallStatusRinging, call.Status)
+	assert.Equal(t, "ringing", persistedCallStatus(t, app, call.ID))
+
+	active, exists := cm.GetActiveCall(bob)
+	require.True(t, exists)
+	assert.Equal(t, call.ID, active.ID)
+
+	// Only the callee answers
+	_, err = cm.AcceptCall(call.ID, alice)
+	assert.Error(t, err)
+
+	call, err = cm.AcceptCall(call.ID, bob)
+	require.NoError(t, err)
+	assert.Equal(t, CallStatusAccepted, call.Status)
+	assert.NotEmpty(t, call.RoomID)
+	assert.Equal(t, "accepted", persistedCallStatus(t, app, call.ID))
+
+	roomsMutex.RLock()
+	room := rooms[call.RoomID]
+	roomsMutex.RUnlock()
+	require.NotNil(t, room)
+	assert.Equal(t, map[string]bool{alice: true, bob: true}, room.AllowedUsers)
+
+	// Accepting twice fails
+	_, err = cm.AcceptCall(call.ID, bob)
+	assert.Error(t, err)
+
+	call, err = cm.EndCall(call.ID, alice)
+	require.NoError(t, err)
+	assert.Equal(t, CallStatusEnded, call.Status)
+	assert.Equal(t, "ended", persistedCallStatus(t, app, call.ID))
+
+	roomsMutex.RLock()
+	_, roomExists := rooms[call.RoomID]
+	roomsMutex.RUnlock()
+	assert.False(t, roomExists)
+
+	_, exists = cm.GetActiveCall(alice)
+	assert.False(t, exists)
+}
+
+func TestCallDecline(t *testing.T) {
+	app, cm := newTestCallManager(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	call, err := cm.StartCall(alice, bob, "")
+	require.NoError(t, err)
+	assert.Equal(t, "audio", call.CallType)
+
+	_, err = cm.DeclineCall(call.ID, alice)
+	assert.Error(t, err)
+
+	call, err = cm.DeclineCall(call.ID, bob)
+	require.NoError(t, err)
+	assert.Equal(t, CallStatusDeclined, call.Status)
+	assert.Equal(t, "declined", persistedCallStatus(t, app, call.ID))
+
+	// Both users are free again
+	_, exists := cm.GetActiveCall(alice)
+	assert.False(t, exists)
+	call, err = cm.StartCall(bob, alice, "audio")
+	require.NoError(t, err)
+	_, err = cm.CancelCall(call.ID, bob)
+	require.NoError(t, err)
+}
+
+func TestCallBusy(t *testing.T) {
+	app, cm := newTestCallManager(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+	carol := createTestUser(t, app, "carol@example.com")
+
+	first, err := cm.StartCall(alice, bob, "audio")
+	require.NoError(t, err)
+
+	busy, err := cm.StartCall(carol, bob, "audio")
+	require.NoError(t, err)
+	assert.Equal(t, CallStatusBusy, busy.Status)
+	assert.Equal(t, "busy", persistedCallStatus(t, app, busy.ID))
+
+	// The busy attempt doesn't touch the call in progress
+	active, exists := cm.GetActiveCall(bob)
+	require.True(t, exists)
+	assert.Equal(t, first.ID, active.ID)
+	_, exists = cm.GetActiveCall(carol)
+	assert.False(t, exists)
+
+	// The caller can't start a second call
+	_, err = cm.StartCall(alice, carol, "audio")
+	assert.Error(t, err)
+
+	_, err = cm.CancelCall(first.ID, alice)
+	require.NoError(t, err)
+}
+
+func TestCallPersistConcurrent(t *testing.T) {
+	app, cm := newTestCallManager(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	call, err := cm.StartCall(alice, bob, "audio")
+	require.NoError(t, err)
+
+	// Answered and saved while other saves of the call are running
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cm.mu.Lock()
+			active := cm.calls[call.ID]
+			cm.mu.Unlock()
+			cm.persist(active)
+		}()
+	}
+	_, err = cm.AcceptCall(call.ID, bob)
+	require.NoError(t, err)
+	wg.Wait()
+
+	records, err := app.FindAllRecords("calls")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "accepted", records[0].GetString("status"))
+
+	_, err = cm.EndCall(call.ID, bob)
+	require.NoError(t, err)
+}
+
+func TestCallRingTimeout(t *testing.T) {
+	app, cm := newTestCallManager(t)
+	cm.ringTimeout = 50 * time.Millisecond
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	call, err := cm.StartCall(alice, bob, "audio")
+	require.NoError(t, err)
+
+	// Released, then saved
+	assert.Eventually(t, func() bool {
+		_, exists := cm.GetActiveCall(bob)
+		record, err := app.FindFirstRecordByData("calls", "callId", call.ID)
+		return !exists && err == nil && record.GetString("status") == "missed"
+	}, time.Second, 10*time.Millisecond)
+
+	// Too late to answer
+	_, err = cm.AcceptCall(call.ID, bob)
+	assert.True(t, errors.Is(err, errCallNotFound))
+}
+
+func TestCallErrors(t *testing.T) {
+	app, cm := newTestCallManager(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+	carol := createTestUser(t, app, "carol@example.com")
+
+	_, err := cm.StartCall(alice, alice, "audio")
+	assert.Error(t, err)
+	_, err = cm.StartCall(alice, bob, "fax")
+	assert.Error(t, err)
+
+	_, err = cm.AcceptCall("unknown", bob)
+	assert.True(t, errors.Is(err, errCallNotFound))
+
+	call, err := cm.StartCall(alice, bob, "audio")
+	require.NoError(t, err)
+	_, err = cm.CancelCall(call.ID, carol)
+	assert.True(t, errors.Is(err, errCallPermissionDenied))
+
+	call, err = cm.CancelCall(call.ID, alice)
+	require.NoError(t, err)
+	assert.Equal(t, CallStatusCancelled, call.Status)
+}
+
+func TestCallHistoryStatusFilter(t *testing.T) {
+	app, cm := newTestCallManager(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+	carol := createTestUser(t, app, "carol@example.com")
+	dave := createTestUser(t, app, "dave@example.com")
+
+	call, err := cm.StartCall(bob, carol, "audio")
+	require.NoError(t, err)
+	_, err = cm.DeclineCall(call.ID, carol)
+	require.NoError(t, err)
+	call, err = cm.StartCall(alice, dave, "audio")
+	require.NoError(t, err)
+	_, err = cm.DeclineCall(call.ID, dave)
+	require.NoError(t, err)
+
+	r, err := apis.NewRouter(app)
+	require.NoError(t, err)
+	r.GET("/api/calls/history", func(e *core.RequestEvent) error {
+		e.Set("userID", alice)
+		return handleGetCallHistory(e)
+	})
+	mux, err := r.BuildMux()
+	require.NoError(t, err)
+
+	count := func(query string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/calls/history?"+query, nil)
+		mux.ServeHTTP(rec, req)
+		require.Equal(t, 200, rec.Code, rec.Body.String())
+
+		var body struct {
+			Count int `json:"count"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body.Count
+	}
+
+	assert.Equal(t, 1, count(""))
+	assert.Equal(t, 1, count("status=declined"))
+	assert.Equal(t, 0, count("status=missed"))
+	// The status is bound, not spliced into the filter
+	assert.Equal(t, 0, count("status=x%27%20%7C%7C%20caller%20%21%3D%20%27"))
+}
@@ -0,0 +1,310 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v3"
+	"github.com/pocketbase/pocketbase/tools/security"
+	msgpack "github.com/vmihailenco/msgpack/v5"
+)
+
+// ==================== DATACHANNEL FRAGMENTATION ====================
+
+// Messages larger than maxDataChannelMessage are split into "fragment"
+// frames, in both directions. The receiver concatenates the data of the
+// fragments sharing a fragment_id and decodes the result as a normal message.
+//
+//	{"type":"fragment","fragment_id":"...","index":0,"total":3,"data":<bytes>}
+type dataChannelFragment struct {
+	Type  string `msgpack:"type"`
+	ID    string `msgpack:"fragment_id"`
+	Index int    `msgpack:"index"`
+	Total int    `msgpack:"total"`
+	Data  []byte `msgpack:"data"`
+}
+
+const (
+	maxDataChannelMessage  = 16 * 1024 // safe for every SCTP implementation
+	fragmentOverhead       = 128
+	maxReassembledMessage  = 8 << 20
+	maxPendingFragmented   = 4        // messages being reassembled per room
+	maxPendingFragmentSize = 16 << 20 // bytes held by those messages
+	fragmentTimeout        = 30 * time.Second
+	maxDataChannelBuffered = 1 << 20 // wait above this many queued bytes
+)
+
+// Send a payload, fragmented if needed. low is signaled when the buffered
+// amount of dc goes down.
+func sendDataChannel(dc *webrtc.DataChannel, low <-chan struct{}, payload []byte) error {
+	if len(payload) <= maxDataChannelMessage {
+		waitDataChannelBuffer(dc, low)
+		return dc.Send(payload)
+	}
+
+	chunkSize := maxDataChannelMessage - fragmentOverhead
+	total := (len(payload) + chunkSize - 1) / chunkSize
+	id := security.RandomString(10)
+
+	for i := 0; i < total; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		frame, err := msgpack.Marshal(dataChannelFragment{
+			Type:  "fragment",
+			ID:    id,
+			Index: i,
+			Total: total,
+			Data:  payload[i*chunkSize : end],
+		})
+		if err != nil {
+			return err
+		}
+
+		waitDataChannelBuffer(dc, low)
+		if err := dc.Send(frame); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Slow down when the peer doesn't keep up, instead of queueing without bound
+func waitDataChannelBuffer(dc *webrtc.DataChannel, low <-chan struct{}) {
+	for dc.BufferedAmount() > maxDataChannelBuffered {
+		if dc.ReadyState() != webrtc.DataChannelStateOpen {
+			return
+		}
+		// Checked again now and then, a signal may come before the wait
+		select {
+		case <-low:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// ==================== SEND QUEUE ====================
+
+// The messages of a user room are queued and written to the DataChannel by
+// one goroutine per room, so a slow peer never blocks the senders (HTTP
+// handlers, presence and notification fan-out). A room whose queue is full
+// is closed as a slow consumer; the client reconnects and replays its inbox.
+// Only the streamed responses wait for the queue, on their request goroutine.
+const (
+	userRoomQueueSize  = 256      // messages waiting for the writer
+	userRoomQueueBytes = 16 << 20 // bytes held by those messages
+)
+
+// Start the writer of an open DataChannel, room.mu held
+func (room *UserRoom) startWriterLocked(dc *webrtc.DataChannel) {
+	if room.out != nil {
+		return
+	}
+
+	low := make(chan struct{}, 1)
+	dc.SetBufferedAmountLowThreshold(maxDataChannelBuffered / 2)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case low <- struct{}{}:
+		default:
+		}
+	})
+
+	room.out = make(chan []byte, userRoomQueueSize)
+	room.outDone = make(chan struct{})
+	go room.writeLoop(dc, low, room.out, room.outDone)
+}
+
+// Stop the writer, the messages still queued are dropped. room.mu held.
+func (room *UserRoom) closeQueueLocked() {
+	if room.outDone != nil && !room.outClosed {
+		room.outClosed = true
+		close(room.outDone)
+	}
+}
+
+// Queue a message for the DataChannel, dropped when the room isn't open
+func (room *UserRoom) enqueue(payload []byte) {
+	room.mu.Lock()
+	if room.out == nil || room.outClosed {
+		room.mu.Unlock()
+		return
+	}
+
+	queued := room.queued+len(payload) <= userRoomQueueBytes
+	if queued {
+		select {
+		case room.out <- payload:
+			room.queued += len(payload)
+		default:
+			queued = false
+		}
+	}
+	if !queued {
+		room.closeQueueLocked()
+	}
+	room.mu.Unlock()
+
+	if !queued {
+		log.Printf("⚠️  User room queue full for user %s session %s, closing (%s)", room.UserID, room.SessionID, reasonSlowConsumer)
+		go room.close()
+	}
+}
+
+// Queue a message, waiting for room in the queue. For the streamed chunks:
+// their request is paced by the peer, the other senders are not.
+func (room *UserRoom) enqueueWait(ctx context.Context, payload []byte) {
+	room.mu.Lock()
+	out, done := room.out, room.outDone
+	closed := room.outClosed
+	if out != nil && !closed {
+		room.queued += len(payload)
+	}
+	room.mu.Unlock()
+
+	if out == nil || closed {
+		return
+	}
+
+	select {
+	case out <- payload:
+		return
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	room.mu.Lock()
+	room.queued -= len(payload)
+	room.mu.Unlock()
+}
+
+func (room *UserRoom) writeLoop(dc *webrtc.DataChannel, low <-chan struct{}, out <-chan []byte, done <-chan struct{}) {
+	for {
+		select {
+		case payload := <-out:
+			room.mu.Lock()
+			room.queued -= len(payload)
+			room.mu.Unlock()
+
+			if err := sendDataChannel(dc, low, payload); err != nil {
+				log.Printf("Error sending to user room: %v", err)
+				room.mu.Lock()
+				room.closeQueueLocked()
+				room.mu.Unlock()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// fragmentAssembler - Fragments reçus en attente de reconstitution
+type fragmentAssembler struct {
+	pending map[string]*fragmentBuffer
+	size    int // bytes of every pending buffer
+	mu      sync.Mutex
+}
+
+type fragmentBuffer struct {
+	parts    [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+func newFragmentAssembler() *fragmentAssembler {
+	return &fragmentAssembler{pending: make(map[string]*fragmentBuffer)}
+}
+
+// Add a fragment, returns the full message once every fragment is received
+func (fa *fragmentAssembler) Add(frame dataChannelFragment) ([]byte, error) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+
+	// Drop the messages that will never complete
+	for id, buffer := range fa.pending {
+		if time.Since(buffer.started) > fragmentTimeout {
+			fa.dropLocked(id)
+		}
+	}
+
+	maxFragments := maxReassembledMessage/(maxDataChannelMessage-fragmentOverhead) + 1
+	if frame.ID == "" || frame.Total <= 0 || frame.Total > maxFragments || frame.Index < 0 || frame.Index >= frame.Total {
+		return nil, fmt.Errorf("invalid fragment")
+	}
+
+	buffer, exists := fa.pending[frame.ID]
+	if !exists {
+		if len(fa.pending) >= maxPendingFragmented {
+			return nil, fmt.Errorf("too many fragmented messages in progress (max %d)", maxPendingFragmented)
+		}
+		buffer = &fragmentBuffer{
+			parts:   make([][]byte, frame.Total),
+			started: time.Now(),
+		}
+		fa.pending[frame.ID] = buffer
+	}
+
+	if len(buffer.parts) != frame.Total {
+		fa.dropLocked(frame.ID)
+		return nil, fmt.Errorf("inconsistent fragment count")
+	}
+	if buffer.parts[frame.Index] != nil {
+		return nil, nil // duplicate
+	}
+
+	if buffer.size+len(frame.Data) > maxReassembledMessage {
+		fa.dropLocked(frame.ID)
+		return nil, fmt.Errorf("message too large (max %d bytes)", maxReassembledMessage)
+	}
+	if fa.size+len(frame.Data) > maxPendingFragmentSize {
+		fa.dropLocked(frame.ID)
+		return nil, fmt.Errorf("too much fragmented data in progress (max %d bytes)", maxPendingFragmentSize)
+	}
+	buffer.size += len(frame.Data)
+	fa.size += len(frame.Data)
+
+	buffer.parts[frame.Index] = frame.Data
+	buffer.received++
+
+	if buffer.received < frame.Total {
+		return nil, nil
+	}
+
+	fa.dropLocked(frame.ID)
+
+	message := make([]byte, 0, buffer.size)
+	for _, part := range buffer.parts {
+		message = append(message, part...)
+	}
+	return message, nil
+}
+
+func (fa *fragmentAssembler) dropLocked(id string) {
+	if buffer, exists := fa.pending[id]; exists {
+		fa.size -= buffer.size
+		delete(fa.pending, id)
+	}
+}
+
+// ==================== STREAMED RESPONSES ====================
+
+// APIStreamFrame - Réponse streamée: des frames "chunk" avec le request_id
+// de la requête, puis une frame "end" avec le status final.
+// Une route HTTP stream en appelant Flush (http.Flusher), chaque flush
+// devient un chunk.
+type APIStreamFrame struct {
+	Type       string `json:"type" msgpack:"type"` // chunk, end
+	RequestID  string `json:"request_id" msgpack:"request_id"`
+	Seq        int    `json:"seq" msgpack:"seq"`
+	Data       []byte `json:"data,omitempty" msgpack:"data,omitempty"`
+	StatusCode int    `json:"status_code,omitempty" msgpack:"status_code,omitempty"`
+	Error      string `json:"error,omitempty" msgpack:"error,omitempty"`
+}
@@ -0,0 +1,289 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	msgpack "github.com/vmihailenco/msgpack/v5"
+)
+
+func fragmentsOf(id string, payload []byte, total int) []dataChannelFragment {
+	size := (len(payload) + total - 1) / total
+	fragments := make([]dataChannelFragment, total)
+	for i := range fragments {
+		start, end := i*size, (i+1)*size
+		if start > len(payload) {
+			start = len(payload)
+		}
+		if end > len(payload) {
+			end = len(payload)
+		}
+		fragments[i] = dataChannelFragment{Type: "fragment", ID: id, Index: i, Total: total, Data: payload[start:end]}
+	}
+	return fragments
+}
+
+func TestFragmentAssembler(t *testing.T) {
+	fa := newFragmentAssembler()
+	fragments := fragmentsOf("a", []byte("hello fragmented world"), 3)
+
+	// Out of order, with a duplicate
+	for _, i := range []int{2, 0, 0} {
+		message, err := fa.Add(fragments[i])
+		require.NoError(t, err)
+		assert.Nil(t, message)
+	}
+	message, err := fa.Add(fragments[1])
+	require.NoError(t, err)
+	assert.Equal(t, "hello fragmented world", string(message))
+	assert.Empty(t, fa.pending)
+	assert.Zero(t, fa.size)
+
+	for _, invalid := range []dataChannelFragment{
+		{ID: "", Index: 0, Total: 1},
+		{ID: "b", Index: 0, Total: 0},
+		{ID: "b", Index: 2, Total: 2},
+		{ID: "b", Index: -1, Total: 2},
+		{ID: "b", Index: 0, Total: 1 << 20},
+	} {
+		_, err := fa.Add(invalid)
+		assert.Error(t, err, "%+v", invalid)
+	}
+
+	_, err = fa.Add(dataChannelFragment{ID: "c", Index: 0, Total: 2, Data: []byte("x")})
+	require.NoError(t, err)
+	_, err = fa.Add(dataChannelFragment{ID: "c", Index: 1, Total: 3, Data: []byte("y")})
+	assert.Error(t, err, "inconsistent count")
+	assert.Empty(t, fa.pending)
+	assert.Zero(t, fa.size)
+}
+
+func TestFragmentAssemblerLimits(t *testing.T) {
+	fa := newFragmentAssembler()
+
+	// Message ids in progress
+	for i := 0; i < maxPendingFragmented; i++ {
+		_, err := fa.Add(dataChannelFragment{ID: string(rune('a' + i)), Index: 0, Total: 2, Data: []byte("x")})
+		require.NoError(t, err)
+	}
+	_, err := fa.Add(dataChannelFragment{ID: "z", Index: 0, Total: 2, Data: []byte("x")})
+	assert.Error(t, err)
+
+	// Expired messages free their slot
+	fa.pending["a"].started = time.Now().Add(-fragmentTimeout - time.Second)
+	_, err = fa.Add(dataChannelFragment{ID: "z", Index: 0, Total: 2, Data: []byte("x")})
+	assert.NoError(t, err)
+	assert.Equal(t, maxPendingFragmented, fa.size)
+
+	// Bytes of a single message
+	fa = newFragmentAssembler()
+	chunk := make([]byte, maxDataChannelMessage-fragmentOverhead)
+	total := maxReassembledMessage/len(chunk) + 1
+	var lastErr error
+	for i := 0; i < total && lastErr == nil; i++ {
+		_, lastErr = fa.Add(dataChannelFragment{ID: "big", Index: i, Total: total, Data: chunk})
+	}
+	assert.ErrorContains(t, lastErr, "message too large")
+	assert.Empty(t, fa.pending)
+	assert.Zero(t, fa.size)
+
+	// Bytes of every message in progress
+	fa = newFragmentAssembler()
+	perMessage := maxPendingFragmentSize / 2 / len(chunk)
+	lastErr = nil
+	for id := 0; id < 3 && lastErr == nil; id++ {
+		for i := 0; i < perMessage && lastErr == nil; i++ {
+			_, lastErr = fa.Add(dataChannelFragment{ID: string(rune('a' + id)), Index: i, Total: perMessage + 1, Data: chunk})
+		}
+	}
+	assert.ErrorContains(t, lastErr, "too much fragmented data")
+	assert.LessOrEqual(t, fa.size, maxPendingFragmentSize)
+	assert.Len(t, fa.pending, 2)
+}
+
+func TestUserRoomFragmentedCancel(t *testing.T) {
+	app := newTestApp(t)
+	ucm := NewUserChannelManager(app)
+	room := &UserRoom{UserID: "alice", SessionID: "s1", fragments: newFragmentAssembler()}
+
+	cancelled := make(chan struct{})
+	require.NoError(t, ucm.rpc.Submit("alice", "s1", "r1", 0, func(ctx context.Context) {
+		<-ctx.Done()
+		close(cancelled)
+	}))
+
+	// The type is read from the reassembled message
+	payload, err := msgpack.Marshal(map[string]interface{}{"type": "cancel", "request_id": "r1"})
+	require.NoError(t, err)
+	for _, fragment := range fragmentsOf("f1", payload, 2) {
+		data, err := msgpack.Marshal(fragment)
+		require.NoError(t, err)
+		ucm.handleUserRoomMessage(room, data)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("fragmented cancel was not applied")
+	}
+}
+
+// Open room queue without writer, as a peer that reads nothing
+func newTestRoomQueue() *UserRoom {
+	return &UserRoom{
+		UserID:    "alice",
+		SessionID: "s1",
+		out:       make(chan []byte, userRoomQueueSize),
+		outDone:   make(chan struct{}),
+	}
+}
+
+func TestUserRoomQueueOverflow(t *testing.T) {
+	// Not open yet: dropped
+	(&UserRoom{}).enqueue([]byte("x"))
+
+	room := newTestRoomQueue()
+	for i := 0; i < userRoomQueueSize; i++ {
+		room.enqueue([]byte("x"))
+	}
+	assert.False(t, room.outClosed)
+	assert.Equal(t, userRoomQueueSize, room.queued)
+
+	// Full: the room is closed instead of blocking the sender
+	room.enqueue([]byte("x"))
+	assert.True(t, room.outClosed)
+	select {
+	case <-room.outDone:
+	default:
+		t.Fatal("writer not stopped")
+	}
+	room.enqueue([]byte("x"))
+	assert.Len(t, room.out, userRoomQueueSize)
+
+	// Same with the bytes held
+	room = newTestRoomQueue()
+	room.enqueue(make([]byte, userRoomQueueBytes/2))
+	room.enqueue(make([]byte, userRoomQueueBytes/2))
+	assert.False(t, room.outClosed)
+	room.enqueue([]byte("x"))
+	assert.True(t, room.outClosed)
+}
+
+func TestUserRoomQueueWait(t *testing.T) {
+	room := newTestRoomQueue()
+	for i := 0; i < userRoomQueueSize; i++ {
+		room.enqueue([]byte("x"))
+	}
+
+	// Streamed chunks wait for room in the queue, until their request ends
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		room.enqueueWait(ctx, []byte("chunk"))
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+		t.Fatal("queued in a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-room.out
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("still waiting with room in the queue")
+	}
+
+	// Request cancelled while waiting
+	cancelled := make(chan struct{})
+	go func() {
+		room.enqueueWait(ctx, []byte("chunk"))
+		close(cancelled)
+	}()
+	cancel()
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after the cancel")
+	}
+	assert.False(t, room.outClosed)
+}
+
+func TestUserRoomWriter(t *testing.T) {
+	offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	defer offerer.Close()
+	answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	// The peer reassembles what the room writer sends
+	received := make(chan []byte, 10)
+	fragments := newFragmentAssembler()
+	answerer.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			var frame dataChannelFragment
+			if msgpack.Unmarshal(msg.Data, &frame) == nil && frame.Type == "fragment" {
+				message, err := fragments.Add(frame)
+				if err == nil && message != nil {
+					received <- message
+				}
+				return
+			}
+			received <- msg.Data
+		})
+	})
+
+	room := &UserRoom{UserID: "alice", SessionID: "s1"}
+	dc, err := offerer.CreateDataChannel("api", nil)
+	require.NoError(t, err)
+	dc.OnOpen(func() {
+		room.mu.Lock()
+		room.startWriterLocked(dc)
+		room.mu.Unlock()
+	})
+
+	offer, err := offerer.CreateOffer(nil)
+	require.NoError(t, err)
+	gathered := webrtc.GatheringCompletePromise(offerer)
+	require.NoError(t, offerer.SetLocalDescription(offer))
+	<-gathered
+	require.NoError(t, answerer.SetRemoteDescription(*offerer.LocalDescription()))
+	answer, err := answerer.CreateAnswer(nil)
+	require.NoError(t, err)
+	gathered = webrtc.GatheringCompletePromise(answerer)
+	require.NoError(t, answerer.SetLocalDescription(answer))
+	<-gathered
+	require.NoError(t, offerer.SetRemoteDescription(*answerer.LocalDescription()))
+
+	require.Eventually(t, func() bool {
+		room.mu.Lock()
+		defer room.mu.Unlock()
+		return room.out != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	large := make([]byte, 5*maxDataChannelMessage)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	room.enqueue([]byte("small"))
+	room.enqueue(large)
+
+	for _, expected := range [][]byte{[]byte("small"), large} {
+		select {
+		case message := <-received:
+			assert.Equal(t, expected, message)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+
+	room.mu.Lock()
+	room.closeQueueLocked()
+	room.mu.Unlock()
+}
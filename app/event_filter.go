@@ -0,0 +1,349 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ganigeorgiev/fexpr"
+)
+
+// ==================== EVENT FILTERS ====================
+
+// EventFilter - Expression évaluée sur le payload avant la livraison,
+// syntaxe proche des filtres PocketBase:
+//
+//	post_id = "abc" && type = "comment"
+//	amount >= 10 || (tags ?= "urgent" && data.user_id != "")
+//
+// Les champs sont lus dans le payload (chemins avec "."), @topic et @id
+// désignent le topic et l'id du message. Comme dans PocketBase, les groupes
+// sont combinés de gauche à droite.
+type EventFilter struct {
+	source string
+	groups []fexpr.ExprGroup
+	likes  map[string]*regexp.Regexp // "~" patterns of the expression, compiled once
+}
+
+const maxEventFilterLength = 2000
+
+// Compile a filter, an empty expression gives a nil filter matching everything
+func CompileEventFilter(expr string) (*EventFilter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	if len(expr) > maxEventFilterLength {
+		return nil, fmt.Errorf("filter too long (max %d chars)", maxEventFilterLength)
+	}
+
+	groups, err := fexpr.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	filter := &EventFilter{source: expr, groups: groups, likes: make(map[string]*regexp.Regexp)}
+	if err := filter.validate(groups); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func (f *EventFilter) validate(groups []fexpr.ExprGroup) error {
+	for _, group := range groups {
+		switch item := group.Item.(type) {
+		case fexpr.Expr:
+			for _, token := range []fexpr.Token{item.Left, item.Right} {
+				if token.Type == fexpr.TokenFunction {
+					return fmt.Errorf("invalid filter: functions are not supported (%s)", token.Literal)
+				}
+			}
+			if strings.HasSuffix(string(item.Op), "~") && item.Right.Type == fexpr.TokenText {
+				pattern := strings.ToLower(item.Right.Literal)
+				if strings.Contains(pattern, "%") {
+					re, err := compileLikePattern(pattern)
+					if err != nil {
+						return fmt.Errorf("invalid filter: %w", err)
+					}
+					f.likes[pattern] = re
+				}
+			}
+		case []fexpr.ExprGroup:
+			if err := f.validate(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *EventFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.source
+}
+
+// Check a message against the filter (a nil filter matches everything)
+func (f *EventFilter) Match(msg PubSubMessage) bool {
+	if f == nil {
+		return true
+	}
+	return f.matchGroups(f.groups, msg)
+}
+
+func (f *EventFilter) matchGroups(groups []fexpr.ExprGroup, msg PubSubMessage) bool {
+	result := false
+	for i, group := range groups {
+		var matched bool
+		switch item := group.Item.(type) {
+		case fexpr.Expr:
+			matched = f.matchExpr(item, msg)
+		case []fexpr.ExprGroup:
+			matched = f.matchGroups(item, msg)
+		}
+
+		if i == 0 {
+			result = matched
+		} else if group.Join == fexpr.JoinOr {
+			result = result || matched
+		} else {
+			result = result && matched
+		}
+	}
+	return result
+}
+
+func (f *EventFilter) matchExpr(expr fexpr.Expr, msg PubSubMessage) bool {
+	left := resolveFilterOperand(expr.Left, msg)
+	right := resolveFilterOperand(expr.Right, msg)
+
+	op := string(expr.Op)
+	anyOp := strings.HasPrefix(op, "?")
+	op = strings.TrimPrefix(op, "?")
+
+	// Arrays: "?=" needs one matching item, the other operators all of them
+	if items, ok := left.([]interface{}); ok {
+		if len(items) == 0 {
+			return !anyOp && (op == "!=" || op == "!~")
+		}
+		for _, item := range items {
+			matched := f.compare(item, fexpr.SignOp(op), right)
+			if anyOp && matched {
+				return true
+			}
+			if !anyOp && !matched {
+				return false
+			}
+		}
+		return !anyOp
+	}
+
+	return f.compare(left, fexpr.SignOp(op), right)
+}
+
+func resolveFilterOperand(token fexpr.Token, msg PubSubMessage) interface{} {
+	switch token.Type {
+	case fexpr.TokenText:
+		return token.Literal
+	case fexpr.TokenNumber:
+		n, _ := strconv.ParseFloat(token.Literal, 64)
+		return n
+	case fexpr.TokenIdentifier:
+		switch token.Literal {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		case "@topic":
+			return msg.Topic
+		case "@id":
+			return float64(msg.ID)
+		}
+		return lookupPayloadPath(msg.Payload, token.Literal)
+	}
+	return nil
+}
+
+// Value at a dotted path (ex: data.user_id)
+func lookupPayloadPath(payload map[string]interface{}, path string) interface{} {
+	var current interface{} = payload
+	for _, key := range strings.Split(path, ".") {
+		m, ok := normalizeFilterValue(current).(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[key]
+	}
+	return normalizeFilterValue(current)
+}
+
+// Publishers put structs, typed maps and slices in payloads: see them as
+// they would be serialized, so that paths and the "?" operators work
+func normalizeFilterValue(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, string, bool, map[string]interface{}, []interface{}:
+		return v
+	}
+	if _, ok := toFilterNumber(v); ok {
+		return v
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil
+	}
+	return normalized
+}
+
+func (f *EventFilter) compare(left interface{}, op fexpr.SignOp, right interface{}) bool {
+	switch op {
+	case fexpr.SignEq:
+		return filterValuesEqual(left, right)
+	case fexpr.SignNeq:
+		return !filterValuesEqual(left, right)
+	case fexpr.SignLike:
+		return f.like(left, right)
+	case fexpr.SignNlike:
+		return !f.like(left, right)
+	}
+
+	// Ordering: numeric when possible, else lexical
+	var cmp int
+	lf, lok := toFilterNumber(left)
+	rf, rok := toFilterNumber(right)
+	if lok && rok {
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		}
+	} else {
+		if left == nil || right == nil {
+			return false
+		}
+		cmp = strings.Compare(toFilterString(left), toFilterString(right))
+	}
+
+	switch op {
+	case fexpr.SignLt:
+		return cmp < 0
+	case fexpr.SignLte:
+		return cmp <= 0
+	case fexpr.SignGt:
+		return cmp > 0
+	case fexpr.SignGte:
+		return cmp >= 0
+	}
+	return false
+}
+
+func filterValuesEqual(left, right interface{}) bool {
+	// null matches missing and empty values, like in PocketBase
+	if left == nil || right == nil {
+		return isEmptyFilterValue(left) && isEmptyFilterValue(right)
+	}
+
+	if lf, ok := toFilterNumber(left); ok {
+		if rf, ok := toFilterNumber(right); ok {
+			return lf == rf
+		}
+	}
+
+	return toFilterString(left) == toFilterString(right)
+}
+
+// "~" is a case insensitive contains, or a LIKE pattern when "%" is used
+func (f *EventFilter) like(left, right interface{}) bool {
+	if left == nil || right == nil {
+		return false
+	}
+
+	value := strings.ToLower(toFilterString(left))
+	pattern := strings.ToLower(toFilterString(right))
+
+	if !strings.Contains(pattern, "%") {
+		return strings.Contains(value, pattern)
+	}
+
+	// Patterns read from the payload are not known in advance
+	re, ok := f.likes[pattern]
+	if !ok {
+		var err error
+		if re, err = compileLikePattern(pattern); err != nil {
+			return false
+		}
+	}
+	return re.MatchString(value)
+}
+
+func compileLikePattern(pattern string) (*regexp.Regexp, error) {
+	parts := strings.Split(pattern, "%")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
+
+func isEmptyFilterValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+func toFilterNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func toFilterString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	if n, ok := toFilterNumber(v); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileEventFilter(t *testing.T) {
+	filter, err := CompileEventFilter("  ")
+	require.NoError(t, err)
+	assert.Nil(t, filter)
+	assert.True(t, filter.Match(PubSubMessage{}), "a nil filter matches everything")
+
+	filter, err = CompileEventFilter(`type = "comment"`)
+	require.NoError(t, err)
+	assert.Equal(t, `type = "comment"`, filter.String())
+
+	for _, invalid := range []string{
+		`type = `,
+		`(type = "a"`,
+		`type = "a" &&`,
+		`count(tags) > 1`,
+		`type = lower("A")`,
+		"type = '" + strings.Repeat("a", maxEventFilterLength) + "'",
+	} {
+		_, err := CompileEventFilter(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestEventFilterMatch(t *testing.T) {
+	msg := PubSubMessage{
+		ID:    42,
+		Topic: "room.abc.chat",
+		Payload: map[string]interface{}{
+			"type":    "comment",
+			"post_id": "abc",
+			"amount":  15,
+			"empty":   "",
+			"tags":    []interface{}{"urgent", "news"},
+			"none":    []interface{}{},
+			"title":   "Hello World",
+			"data":    map[string]interface{}{"user_id": "u1", "score": 2.5},
+		},
+	}
+
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{`type = "comment"`, true},
+		{`type != "comment"`, false},
+		{`type = 'reaction'`, false},
+		{`amount = 15`, true},
+		{`amount >= 10 && amount < 20`, true},
+		{`amount > 15`, false},
+		{`post_id > "abb"`, true},
+		{`data.user_id = "u1"`, true},
+		{`data.score <= 2.5`, true},
+		{`data.missing = null`, true},
+		{`missing.path = null`, true},
+		{`empty = null`, true},
+		{`type = null`, false},
+		{`missing > 1`, false},
+		{`tags ?= "urgent"`, true},
+		{`tags ?= "sport"`, false},
+		{`tags = "urgent"`, false},
+		{`tags != "sport"`, true},
+		{`none ?= "urgent"`, false},
+		{`none != "urgent"`, true},
+		{`title ~ "world"`, true},
+		{`title ~ "hello%"`, true},
+		{`title ~ "%earth"`, false},
+		{`title !~ "%earth"`, true},
+		{`title ~ "h_llo%"`, false},
+		{`tags ?~ "urg%"`, true},
+		{`@topic = "room.abc.chat"`, true},
+		{`@topic ~ "room.%.chat"`, true},
+		{`@id = 42`, true},
+		{`type = "comment" || amount > 100`, true},
+		{`type = "x" || (amount > 10 && tags ?= "news")`, true},
+		{`type = "x" && amount > 10 || post_id = "abc"`, true},
+		{`true = true`, true},
+	}
+
+	for _, tc := range cases {
+		filter, err := CompileEventFilter(tc.expr)
+		require.NoError(t, err, tc.expr)
+		assert.Equal(t, tc.match, filter.Match(msg), tc.expr)
+	}
+}
+
+type filterTestAuthor struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+func TestEventFilterTypedPayload(t *testing.T) {
+	// What publishers put in payloads before they are serialized
+	msg := PubSubMessage{
+		Topic: "post_events",
+		Payload: map[string]interface{}{
+			"author":  filterTestAuthor{ID: "u1", Roles: []string{"admin", "editor"}},
+			"ref":     &filterTestAuthor{ID: "u2"},
+			"labels":  map[string]string{"lang": "fr"},
+			"ids":     []int{1, 2, 3},
+			"viewers": []string{"u3", "u4"},
+		},
+	}
+
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{`author.id = "u1"`, true},
+		{`author.roles ?= "editor"`, true},
+		{`author.roles ?= "viewer"`, false},
+		{`ref.id = "u2"`, true},
+		{`labels.lang = "fr"`, true},
+		{`ids ?= 2`, true},
+		{`ids > 0`, true},
+		{`viewers ?= "u4"`, true},
+		{`author.missing = null`, true},
+	}
+
+	for _, tc := range cases {
+		filter, err := CompileEventFilter(tc.expr)
+		require.NoError(t, err, tc.expr)
+		assert.Equal(t, tc.match, filter.Match(msg), tc.expr)
+	}
+}
+
+func TestEventFilterLikePatterns(t *testing.T) {
+	filter, err := CompileEventFilter(`title ~ "Hello%" || body !~ "%spam%" || title ~ "plain"`)
+	require.NoError(t, err)
+
+	// Compiled with the filter, not on every message
+	assert.Len(t, filter.likes, 2)
+	assert.Contains(t, filter.likes, "hello%")
+	assert.Contains(t, filter.likes, "%spam%")
+
+	// A pattern read from the payload
+	filter, err = CompileEventFilter(`title ~ pattern`)
+	require.NoError(t, err)
+	assert.Empty(t, filter.likes)
+	assert.True(t, filter.Match(PubSubMessage{Payload: map[string]interface{}{"title": "a.b", "pattern": "a.%"}}))
+	assert.False(t, filter.Match(PubSubMessage{Payload: map[string]interface{}{"title": "axb", "pattern": "a.%"}}))
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// ==================== EVENT IDS ====================
+
+// Seeded with the current time (µs) so that IDs keep increasing across
+// restarts and stay below 2^53 (stored as a PocketBase number). The clock
+// may go back between two runs: the RecordEventStore also moves the seed
+// past the last persisted ID.
+var lastEventID = uint64(time.Now().UnixMicro())
+
+func nextEventID() uint64 {
+	return atomic.AddUint64(&lastEventID, 1)
+}
+
+// Keep local IDs ahead of the IDs received from other nodes
+func observeEventID(id uint64) {
+	for {
+		current := atomic.LoadUint64(&lastEventID)
+		if id <= current || atomic.CompareAndSwapUint64(&lastEventID, current, id) {
+			return
+		}
+	}
+}
+
+// ==================== IN-MEMORY EVENT LOG ====================
+
+type loggedEvent struct {
+	msg PubSubMessage
+	at  time.Time
+}
+
+// EventLog - Journal borné des derniers messages par topic
+type EventLog struct {
+	entries map[string][]loggedEvent
+	size    int           // max messages kept per topic
+	maxAge  time.Duration // messages older than this are dropped
+	appends int
+	mu      sync.RWMutex
+}
+
+func NewEventLog(size int, maxAge time.Duration) *EventLog {
+	return &EventLog{
+		entries: make(map[string][]loggedEvent),
+		size:    size,
+		maxAge:  maxAge,
+	}
+}
+
+func (l *EventLog) Append(msg PubSubMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := append(l.entries[msg.Topic], loggedEvent{msg: msg, at: time.Now()})
+	if len(entries) > l.size {
+		entries = entries[len(entries)-l.size:]
+	}
+	l.entries[msg.Topic] = entries
+
+	// Periodically forget idle topics
+	l.appends++
+	if l.appends%1000 == 0 {
+		l.pruneLocked()
+	}
+}
+
+// Messages of topics matching pattern with an ID greater than afterID
+func (l *EventLog) Since(pattern string, afterID uint64) []PubSubMessage {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	cutoff := time.Now().Add(-l.maxAge)
+	result := []PubSubMessage{}
+
+	for topic, entries := range l.entries {
+		if !TopicMatches(pattern, topic) {
+			continue
+		}
+		for _, e := range entries {
+			if e.msg.ID > afterID && e.at.After(cutoff) {
+				result = append(result, e.msg)
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
+
+func (l *EventLog) pruneLocked() {
+	cutoff := time.Now().Add(-l.maxAge)
+	for topic, entries := range l.entries {
+		if len(entries) == 0 || entries[len(entries)-1].at.Before(cutoff) {
+			delete(l.entries, topic)
+		}
+	}
+}
+
+// ==================== PERSISTENT EVENT STORE ====================
+
+// EventStore - Persistance optionnelle des messages pour le replay
+type EventStore interface {
+	Append(msg PubSubMessage)
+	Since(pattern string, afterID uint64, limit int) ([]PubSubMessage, error)
+}
+
+// RecordEventStore - EventStore basé sur la collection eventLog
+type RecordEventStore struct {
+	app   core.App
+	queue chan PubSubMessage
+}
+
+func NewRecordEventStore(app core.App) *RecordEventStore {
+	store := &RecordEventStore{
+		app:   app,
+		queue: make(chan PubSubMessage, 1000),
+	}
+
+	// Never hand out an ID already persisted, whatever the clock says
+	var last struct {
+		ID float64 `db:"id"`
+	}
+	err := app.DB().NewQuery("SELECT COALESCE(MAX(eventId), 0) AS id FROM eventLog").One(&last)
+	if err != nil {
+		log.Printf("Error reading the last event id: %v", err)
+	}
+	observeEventID(uint64(last.ID))
+
+	go store.run()
+
+	return store
+}
+
+// Append queues the message, writes happen in the background
+func (s *RecordEventStore) Append(msg PubSubMessage) {
+	select {
+	case s.queue <- msg:
+	default:
+		log.Printf("⚠️  Event store queue full, event %d not persisted", msg.ID)
+	}
+}
+
+func (s *RecordEventStore) run() {
+	for msg := range s.queue {
+		collection, err := s.app.FindCollectionByNameOrId("eventLog")
+		if err != nil {
+			log.Printf("Error persisting event: %v", err)
+			continue
+		}
+
+		record := core.NewRecord(collection)
+		record.Set("eventId", msg.ID)
+		record.Set("topic", msg.Topic)
+		record.Set("payload", msg.Payload)
+
+		if err := s.app.Save(record); err != nil {
+			log.Printf("Error persisting event %d: %v", msg.ID, err)
+		}
+	}
+}
+
+// Rows read per query when replaying
+const minEventPageSize = 200
+
+func (s *RecordEventStore) Since(pattern string, afterID uint64, limit int) ([]PubSubMessage, error) {
+	filter := "eventId > {:after}"
+	params := map[string]interface{}{"after": afterID}
+
+	// Narrow the query on the literal prefix of the pattern
+	if IsWildcardTopic(pattern) {
+		prefix := ""
+		for _, token := range strings.Split(pattern, ".") {
+			if token == "*" || token == ">" {
+				break
+			}
+			prefix += token + "."
+		}
+		if prefix != "" {
+			filter += " && topic ~ {:prefix}"
+			params["prefix"] = prefix + "%"
+		}
+	} else {
+		filter += " && topic = {:topic}"
+		params["topic"] = pattern
+	}
+
+	// The prefix also matches topics the pattern rejects: page until limit
+	// messages actually match
+	pageSize := limit
+	if pageSize < minEventPageSize {
+		pageSize = minEventPageSize
+	}
+
+	result := []PubSubMessage{}
+	for offset := 0; len(result) < limit; offset += pageSize {
+		records, err := s.app.FindRecordsByFilter("eventLog", filter, "eventId", pageSize, offset, params)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			topic := record.GetString("topic")
+			if !TopicMatches(pattern, topic) {
+				continue
+			}
+
+			var payload map[string]interface{}
+			record.UnmarshalJSONField("payload", &payload)
+
+			result = append(result, PubSubMessage{
+				ID:      uint64(record.GetFloat("eventId")),
+				Topic:   topic,
+				Payload: payload,
+			})
+			if len(result) == limit {
+				break
+			}
+		}
+
+		if len(records) < pageSize {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// Delete persisted events older than maxAge
+func (s *RecordEventStore) Cleanup(maxAge time.Duration) {
+	cutoff, _ := types.ParseDateTime(time.Now().Add(-maxAge))
+
+	_, err := s.app.DB().NewQuery("DELETE FROM eventLog WHERE created < {:cutoff}").
+		Bind(map[string]interface{}{"cutoff": cutoff.String()}).
+		Execute()
+	if err != nil {
+		log.Printf("Error cleaning event log: %v", err)
+	}
+}
+
+// ==================== SETUP COLLECTIONS ====================
+
+func SetupEventLogCollection(app core.App) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		eventLog := core.NewBaseCollection("eventLog")
+		eventLog.Fields.Add(
+			&core.NumberField{Name: "eventId", Required: true},
+			&core.TextField{Name: "topic", Required: true},
+			&core.JSONField{Name: "payload"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+		)
+		eventLog.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_event_log_id ON eventLog (eventId)",
+			"CREATE INDEX idx_event_log_topic ON eventLog (topic, eventId)",
+		}
+
+		return txApp.Save(eventLog)
+	})
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func saveTestEvent(t *testing.T, app core.App, id uint64, topic string) {
+	t.Helper()
+
+	collection, err := app.FindCollectionByNameOrId("eventLog")
+	require.NoError(t, err)
+
+	record := core.NewRecord(collection)
+	record.Set("eventId", id)
+	record.Set("topic", topic)
+	record.Set("payload", map[string]interface{}{"id": id})
+	require.NoError(t, app.Save(record))
+}
+
+func TestEventLogSince(t *testing.T) {
+	log := NewEventLog(2, time.Hour)
+
+	log.Append(PubSubMessage{ID: 1, Topic: "room.a.chat"})
+	log.Append(PubSubMessage{ID: 2, Topic: "room.b.chat"})
+	log.Append(PubSubMessage{ID: 3, Topic: "room.a.chat"})
+	log.Append(PubSubMessage{ID: 4, Topic: "room.a.video"})
+	log.Append(PubSubMessage{ID: 5, Topic: "room.a.chat"})
+
+	// Two messages kept per topic, sorted by id across topics
+	ids := func(messages []PubSubMessage) []uint64 {
+		result := []uint64{}
+		for _, msg := range messages {
+			result = append(result, msg.ID)
+		}
+		return result
+	}
+	assert.Equal(t, []uint64{2, 3, 5}, ids(log.Since("room.*.chat", 0)))
+	assert.Equal(t, []uint64{5}, ids(log.Since("room.a.chat", 3)))
+	assert.Equal(t, []uint64{3, 4, 5}, ids(log.Since("room.a.>", 0)))
+}
+
+func TestRecordEventStoreWildcardLimit(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupEventLogCollection(app))
+
+	// room.* narrows the query to room.%, most rows are other events
+	id := uint64(1)
+	for i := 0; i < 3*minEventPageSize; i++ {
+		saveTestEvent(t, app, id, "room.abc.video")
+		id++
+		if i%10 == 0 {
+			saveTestEvent(t, app, id, "room.abc.chat")
+			id++
+		}
+	}
+
+	store := NewRecordEventStore(app)
+
+	messages, err := store.Since("room.*.chat", 0, 50)
+	require.NoError(t, err)
+	assert.Len(t, messages, 50)
+	for i, msg := range messages {
+		assert.Equal(t, "room.abc.chat", msg.Topic)
+		if i > 0 {
+			assert.Greater(t, msg.ID, messages[i-1].ID)
+		}
+	}
+
+	messages, err = store.Since("room.*.chat", 0, 500)
+	require.NoError(t, err)
+	assert.Len(t, messages, 3*minEventPageSize/10)
+
+	messages, err = store.Since("room.abc.chat", messages[len(messages)-2].ID, 500)
+	require.NoError(t, err)
+	assert.Len(t, messages, 1)
+}
+
+func TestEventIDsAfterClockRollback(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupEventLogCollection(app))
+
+	saved := atomic.LoadUint64(&lastEventID)
+	t.Cleanup(func() { atomic.StoreUint64(&lastEventID, saved) })
+
+	// An event persisted by a run whose clock was ahead
+	persisted := saved + 1_000_000_000
+	saveTestEvent(t, app, persisted, "room.abc.chat")
+
+	NewRecordEventStore(app)
+
+	assert.Greater(t, nextEventID(), persisted)
+}
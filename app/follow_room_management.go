@@ -5,6 +5,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/types"
 )
@@ -169,6 +170,11 @@ func SetupFollowAndRoomCollections(app core.App) error {
 			roomsColl.Type = core.CollectionTypeBase
 		}
 
+		// The first schema required a creator, the rooms now have an owner
+		if creator, ok := roomsColl.Fields.GetByName("creator").(*core.RelationField); ok {
+			creator.Required = false
+		}
+
 		roomsColl.Fields.Add(
 			&core.SelectField{
 				Name:      "roomType",
@@ -227,7 +233,7 @@ func SetupFollowAndRoomCollections(app core.App) error {
 			&core.RelationField{
 				Name:         "room",
 				Required:     true,
-				CollectionId: "rooms", MaxSelect: 1,
+				CollectionId: roomsColl.Id, MaxSelect: 1,
 			},
 			&core.RelationField{
 				Name:         "user",
@@ -428,19 +434,15 @@ func handleFollowUser(c *core.RequestEvent) error {
 
 	// Notify
 	if status == "pending" {
-		userChannelManager.SendToSSE(followingID, "follow_request", map[string]interface{}{
+		userChannelManager.Notify(followingID, "follow_request", map[string]interface{}{
 			"follower_id": followerID,
 			"follow_id":   follow.Id,
-		}, "")
-		userChannelManager.SendToUserRoom(followingID, "notification", map[string]interface{}{
-			"type":    "follow_request",
-			"user_id": followerID,
-		}, "")
+		})
 	} else if status == "active" {
-		userChannelManager.SendToSSE(followingID, "new_follower", map[string]interface{}{
+		userChannelManager.Notify(followingID, "new_follower", map[string]interface{}{
 			"follower_id": followerID,
 			"follow_id":   follow.Id,
-		}, "")
+		})
 	}
 
 	return c.JSON(200, map[string]interface{}{
@@ -481,10 +483,10 @@ func handleApproveFollow(c *core.RequestEvent) error {
 
 	// Notify follower
 	followerID := follow.GetString("follower")
-	userChannelManager.SendToSSE(followerID, "follow_approved", map[string]interface{}{
+	userChannelManager.Notify(followerID, "follow_approved", map[string]interface{}{
 		"following_id": follow.GetString("following"),
 		"follow_id":    followID,
-	}, "")
+	})
 
 	return c.JSON(200, map[string]interface{}{"success": true})
 }
@@ -602,9 +604,9 @@ func handlePromoteFollowerToAdmin(c *core.RequestEvent) error {
 
 	// Notify
 	followerID := follow.GetString("follower")
-	userChannelManager.SendToSSE(followerID, "promoted_to_admin", map[string]interface{}{
+	userChannelManager.Notify(followerID, "promoted_to_admin", map[string]interface{}{
 		"following_id": userID,
-	}, "")
+	})
 
 	return c.JSON(200, map[string]interface{}{"success": true})
 }
@@ -811,9 +813,9 @@ func handleApproveRoomMember(c *core.RequestEvent) error {
 
 	// Notify member
 	targetUserID := member.GetString("user")
-	userChannelManager.SendToSSE(targetUserID, "room_join_approved", map[string]interface{}{
+	userChannelManager.Notify(targetUserID, "room_join_approved", map[string]interface{}{
 		"room_id": roomID,
-	}, "")
+	})
 
 	return c.JSON(200, map[string]interface{}{"success": true})
 }
@@ -869,9 +871,9 @@ func handlePromoteToRoomAdmin(c *core.RequestEvent) error {
 
 	// Notify
 	targetUserID := member.GetString("user")
-	userChannelManager.SendToSSE(targetUserID, "promoted_to_room_admin", map[string]interface{}{
+	userChannelManager.Notify(targetUserID, "promoted_to_room_admin", map[string]interface{}{
 		"room_id": roomID,
-	}, "")
+	})
 
 	return c.JSON(200, map[string]interface{}{"success": true})
 }
@@ -937,14 +939,17 @@ func handleBanRoomMember(c *core.RequestEvent) error {
 	if exists {
 		targetUserID := member.GetString("user")
 		room.mu.RLock()
+		participantIDs := []string{}
 		for _, p := range room.Participants {
 			if p.UserID == targetUserID {
-				room.mu.RUnlock()
-				room.RemoveParticipant(p.ID)
-				break
+				participantIDs = append(participantIDs, p.ID)
 			}
 		}
 		room.mu.RUnlock()
+
+		for _, participantID := range participantIDs {
+			room.RemoveParticipant(participantID)
+		}
 	}
 
 	return c.JSON(200, map[string]interface{}{"success": true})
@@ -1078,9 +1083,9 @@ func handleTransferRoomOwnership(c *core.RequestEvent) error {
 	app.Save(newOwnerMember)
 
 	// Notify
-	userChannelManager.SendToSSE(req.NewOwnerID, "room_ownership_transferred", map[string]interface{}{
+	userChannelManager.Notify(req.NewOwnerID, "room_ownership_transferred", map[string]interface{}{
 		"room_id": roomID,
-	}, "")
+	})
 
 	return c.JSON(200, map[string]interface{}{"success": true})
 }
@@ -1151,7 +1156,8 @@ func handleUpdateRoomSettings(c *core.RequestEvent) error {
 
 func IsRoomOwnerOrAdmin(app core.App, roomID, userID string) bool {
 	member, err := app.FindFirstRecordByFilter("roomMembers",
-		fmt.Sprintf("room = '%s' && user = '%s' && status = 'active'", roomID, userID))
+		"room = {:room} && user = {:user} && status = 'active'",
+		dbx.Params{"room": roomID, "user": userID})
 
 	if err != nil {
 		return false
@@ -1167,11 +1173,7 @@ func notifyRoomAdmins(app core.App, roomID string, eventType string, data map[st
 
 	for _, admin := range admins {
 		adminUserID := admin.GetString("user")
-		userChannelManager.SendToSSE(adminUserID, eventType, data, "")
-		userChannelManager.SendToUserRoom(adminUserID, "notification", map[string]interface{}{
-			"type": eventType,
-			"data": data,
-		}, "")
+		userChannelManager.Notify(adminUserID, eventType, data)
 	}
 }
 
@@ -1213,9 +1215,9 @@ func checkExpiredSubscriptions(app core.App) {
 		// Notify
 		followerID := follow.GetString("follower")
 		followingID := follow.GetString("following")
-		userChannelManager.SendToSSE(followerID, "follow_expired", map[string]interface{}{
+		userChannelManager.Notify(followerID, "follow_expired", map[string]interface{}{
 			"following_id": followingID,
-		}, "")
+		})
 	}
 
 	// Expire room memberships
@@ -1229,9 +1231,9 @@ func checkExpiredSubscriptions(app core.App) {
 		// Notify
 		userID := member.GetString("user")
 		roomID := member.GetString("room")
-		userChannelManager.SendToSSE(userID, "room_membership_expired", map[string]interface{}{
+		userChannelManager.Notify(userID, "room_membership_expired", map[string]interface{}{
 			"room_id": roomID,
-		}, "")
+		})
 	}
 
 	log.Printf("Expired %d follows and %d room memberships", len(expiredFollows), len(expiredMembers))
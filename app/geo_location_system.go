@@ -1,7 +1,6 @@
 package app
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"math"
@@ -46,13 +45,13 @@ type GeoFence struct {
 	Geometry    GeoJSONGeometry        `json:"geometry"`
 	Actions     []string               `json:"actions"`      // chat, notification, call, ads
 	TriggerType string                 `json:"trigger_type"` // enter, exit, dwell
-	Metadata    map[string]interface{} `json:"metadata"`
+	Metadata    map[string]interface{} `json:"metadata"`     // dwell_seconds for dwell
 	CreatedBy   string                 `json:"created_by"`
 	IsActive    bool                   `json:"is_active"`
 }
 
 type GeoEvent struct {
-	Type      string                 `json:"type"` // user_entered, user_exited, user_nearby
+	Type      string                 `json:"type"` // user_entered, user_exited, user_dwelled, user_nearby
 	UserID    string                 `json:"user_id"`
 	FenceID   string                 `json:"fence_id,omitempty"`
 	Location  Location               `json:"location"`
@@ -61,12 +60,33 @@ type GeoEvent struct {
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// Dwell time of the fences without dwell_seconds
+const defaultGeoFenceDwellTime = 5 * time.Minute
+
+// geoDwell - Entrée d'un utilisateur dans une geofence "dwell"
+type geoDwell struct {
+	enteredAt time.Time
+	timer     *time.Timer
+}
+
+// geoFenceCheck - Vérifications des geofences d'un utilisateur, une à la fois
+type geoFenceCheck struct {
+	mu      sync.Mutex
+	waiting int
+}
+
 // ==================== LOCATION MANAGER ====================
 
 type LocationManager struct {
-	locations  map[string]*UserLocation // userID -> location
-	fences     map[string]*GeoFence     // fenceID -> geofence
-	userFences map[string][]string      // userID -> fenceIDs user is inside
+	locations  map[string]*UserLocation  // userID -> location
+	fences     map[string]*GeoFence      // fenceID -> geofence
+	userFences map[string][]string       // userID -> fenceIDs user is inside
+	dwells     map[string]*geoDwell      // userID/fenceID -> entry in a dwell fence
+	checks     map[string]*geoFenceCheck // userID -> geofence checks in progress
+	history    *LocationHistory          // optional, sampled into locationHistory
+	userIndex  *SpatialIndex             // user positions
+	fenceIndex *SpatialIndex             // geofence bounding boxes
+	pending    sync.WaitGroup            // geofence checks and writes in the background
 	app        core.App
 	mu         sync.RWMutex
 }
@@ -76,10 +96,34 @@ func NewLocationManager(app core.App) *LocationManager {
 		locations:  make(map[string]*UserLocation),
 		fences:     make(map[string]*GeoFence),
 		userFences: make(map[string][]string),
+		dwells:     make(map[string]*geoDwell),
+		checks:     make(map[string]*geoFenceCheck),
+		userIndex:  NewSpatialIndex(spatialCellSize),
+		fenceIndex: NewSpatialIndex(spatialCellSize),
 		app:        app,
 	}
 }
 
+// Record the positions in the locationHistory collection
+func (lm *LocationManager) SetHistory(history *LocationHistory) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.history = history
+}
+
+func (lm *LocationManager) background(task func()) {
+	lm.pending.Add(1)
+	go func() {
+		defer lm.pending.Done()
+		task()
+	}()
+}
+
+// Wait for the geofence checks and writes started by UpdateLocation
+func (lm *LocationManager) Wait() {
+	lm.pending.Wait()
+}
+
 // Update user location
 func (lm *LocationManager) UpdateLocation(userID string, location Location, presence string) error {
 	lm.mu.Lock()
@@ -91,26 +135,33 @@ func (lm *LocationManager) UpdateLocation(userID string, location Location, pres
 		UpdatedAt: time.Now(),
 	}
 
-	oldLocation := lm.locations[userID]
 	lm.locations[userID] = userLoc
+	lm.userIndex.InsertPoint(userID, location.Point)
+	history := lm.history
 
 	lm.mu.Unlock()
 
 	// Check geofences
-	go lm.checkGeofences(userID, userLoc, oldLocation)
+	lm.background(func() { lm.checkGeofences(userID) })
 
 	// Broadcast location update
+	locationPayload := map[string]interface{}{
+		"user_id":  userID,
+		"location": location,
+		"presence": presence,
+	}
 	pubsub.Publish("location_updates", PubSubMessage{
-		Topic: "location_updates",
-		Payload: map[string]interface{}{
-			"user_id":  userID,
-			"location": location,
-			"presence": presence,
-		},
+		Topic:   "location_updates",
+		Payload: locationPayload,
+	})
+	pubsub.Publish(UserTopic(userID, "location"), PubSubMessage{
+		Topic:   UserTopic(userID, "location"),
+		Payload: locationPayload,
 	})
 
 	// Save to database
-	go lm.saveLocationToDB(userID, location, presence)
+	lm.background(func() { lm.saveLocationToDB(userID, location, presence) })
+	lm.background(func() { history.Record(userID, location, presence) })
 
 	return nil
 }
@@ -130,8 +181,9 @@ func (lm *LocationManager) FindNearby(point Point, radiusMeters float64, exclude
 
 	nearby := []*UserLocation{}
 
-	for userID, userLoc := range lm.locations {
-		if userID == excludeUserID {
+	for _, userID := range lm.userIndex.Search(circleBounds(point, radiusMeters)) {
+		userLoc := lm.locations[userID]
+		if userID == excludeUserID || userLoc == nil {
 			continue
 		}
 
@@ -156,8 +208,9 @@ func (lm *LocationManager) FindInPolygon(polygon []Point) []*UserLocation {
 
 	inside := []*UserLocation{}
 
-	for _, userLoc := range lm.locations {
-		if time.Since(userLoc.UpdatedAt) > 5*time.Minute {
+	for _, userID := range lm.userIndex.Search(polygonBounds(polygon)) {
+		userLoc := lm.locations[userID]
+		if userLoc == nil || time.Since(userLoc.UpdatedAt) > 5*time.Minute {
 			continue
 		}
 
@@ -169,6 +222,18 @@ func (lm *LocationManager) FindInPolygon(polygon []Point) []*UserLocation {
 	return inside
 }
 
+// Update the presence of a known location, without publishing it
+func (lm *LocationManager) SetPresence(userID, presence string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if userLoc, exists := lm.locations[userID]; exists {
+		updated := *userLoc
+		updated.Presence = presence
+		lm.locations[userID] = &updated
+	}
+}
+
 // Get all users with specific presence
 func (lm *LocationManager) GetUsersByPresence(presence string) []*UserLocation {
 	lm.mu.RLock()
@@ -189,6 +254,7 @@ func (lm *LocationManager) AddGeoFence(fence *GeoFence) {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 	lm.fences[fence.ID] = fence
+	lm.fenceIndex.Insert(fence.ID, geoFenceBounds(fence))
 }
 
 // Remove geofence
@@ -196,38 +262,165 @@ func (lm *LocationManager) RemoveGeoFence(fenceID string) {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 	delete(lm.fences, fenceID)
+	lm.fenceIndex.Remove(fenceID)
 }
 
-// Check if user entered/exited geofences
-func (lm *LocationManager) checkGeofences(userID string, newLoc *UserLocation, oldLoc *UserLocation) {
-	lm.mu.RLock()
-	activeFences := make([]*GeoFence, 0, len(lm.fences))
-	for _, fence := range lm.fences {
+// Load the fences of the geofences collection, inactive ones included so
+// that they can be listed and reactivated
+func (lm *LocationManager) LoadGeoFences() {
+	records, err := lm.app.FindAllRecords("geofences")
+	if err != nil {
+		log.Printf("Error loading geofences: %v", err)
+		return
+	}
+
+	fences := make(map[string]*GeoFence, len(records))
+	index := NewSpatialIndex(spatialCellSize)
+	active := 0
+	for _, record := range records {
+		fence := geoFenceFromRecord(record)
+		fences[fence.ID] = fence
+		index.Insert(fence.ID, geoFenceBounds(fence))
 		if fence.IsActive {
-			activeFences = append(activeFences, fence)
+			active++
+		}
+	}
+
+	lm.mu.Lock()
+	lm.fences = fences
+	lm.fenceIndex = index
+	lm.mu.Unlock()
+
+	log.Printf("🗺️ %d geofences loaded (%d active)", len(fences), active)
+}
+
+// Apply a change of the geofences collection (API or admin UI)
+func (lm *LocationManager) SyncGeoFence(record *core.Record, deleted bool) {
+	// fenceId edited in the admin UI
+	if previous := record.Original().GetString("fenceId"); previous != "" && previous != record.GetString("fenceId") {
+		lm.RemoveGeoFence(previous)
+	}
+
+	if deleted {
+		lm.RemoveGeoFence(record.GetString("fenceId"))
+		return
+	}
+	lm.AddGeoFence(geoFenceFromRecord(record))
+}
+
+func geoFenceFromRecord(record *core.Record) *GeoFence {
+	fence := &GeoFence{
+		ID:          record.GetString("fenceId"),
+		Name:        record.GetString("name"),
+		TriggerType: record.GetString("triggerType"),
+		CreatedBy:   record.GetString("createdBy"),
+		IsActive:    record.GetBool("isActive"),
+	}
+
+	if err := record.UnmarshalJSONField("geometry", &fence.Geometry); err != nil {
+		log.Printf("Invalid geometry for geofence %s: %v", fence.ID, err)
+	}
+	record.UnmarshalJSONField("actions", &fence.Actions)
+	record.UnmarshalJSONField("metadata", &fence.Metadata)
+
+	return fence
+}
+
+func geoFenceToRecord(fence *GeoFence, record *core.Record) {
+	record.Set("fenceId", fence.ID)
+	record.Set("name", fence.Name)
+	record.Set("geometry", fence.Geometry)
+	record.Set("actions", fence.Actions)
+	record.Set("triggerType", fence.TriggerType)
+	record.Set("metadata", fence.Metadata)
+	record.Set("createdBy", fence.CreatedBy)
+	record.Set("isActive", fence.IsActive)
+}
+
+// One geofence check at a time per user: each one reads userFences and
+// writes it back
+func (lm *LocationManager) lockGeoFenceCheck(userID string) func() {
+	lm.mu.Lock()
+	check, exists := lm.checks[userID]
+	if !exists {
+		check = &geoFenceCheck{}
+		lm.checks[userID] = check
+	}
+	check.waiting++
+	lm.mu.Unlock()
+
+	check.mu.Lock()
+	return func() {
+		check.mu.Unlock()
+
+		lm.mu.Lock()
+		check.waiting--
+		if check.waiting == 0 {
+			delete(lm.checks, userID)
 		}
+		lm.mu.Unlock()
+	}
+}
+
+// Check if user entered/exited geofences. The checks of quick updates may
+// run out of order, each one uses the latest location.
+func (lm *LocationManager) checkGeofences(userID string) {
+	unlock := lm.lockGeoFenceCheck(userID)
+	defer unlock()
+
+	lm.mu.RLock()
+	newLoc := lm.locations[userID]
+	if newLoc == nil {
+		lm.mu.RUnlock()
+		return
 	}
 	previousFences := lm.userFences[userID]
+
+	// Fences around the position, and the ones the user may have left
+	candidates := lm.fenceIndex.Search(pointBounds(newLoc.Location.Point))
+	for _, fenceID := range previousFences {
+		if !contains(candidates, fenceID) {
+			candidates = append(candidates, fenceID)
+		}
+	}
+
+	activeFences := make([]*GeoFence, 0, len(candidates))
+	for _, fenceID := range candidates {
+		if fence, exists := lm.fences[fenceID]; exists && fence.IsActive {
+			activeFences = append(activeFences, fence)
+		}
+	}
 	lm.mu.RUnlock()
 
 	currentFences := []string{}
+	viewers := map[string]*locationViewer{}
 
 	for _, fence := range activeFences {
-		isInside := lm.IsInsideGeoFence(newLoc.Location.Point, fence)
+		wasInside := contains(previousFences, fence.ID)
+
+		// A fence sees the user as its creator does: hidden users neither
+		// enter nor leave it, the others are tested on the fuzzed position
+		seen, visible := geoFenceView(viewers, fence, newLoc)
+		if !visible {
+			if wasInside {
+				currentFences = append(currentFences, fence.ID)
+			}
+			continue
+		}
+
+		isInside := lm.IsInsideGeoFence(seen.Location.Point, fence)
 
 		if isInside {
 			currentFences = append(currentFences, fence.ID)
 		}
 
-		wasInside := contains(previousFences, fence.ID)
-
 		// User entered fence
 		if isInside && !wasInside && (fence.TriggerType == "enter" || fence.TriggerType == "") {
 			lm.triggerGeoEvent(GeoEvent{
 				Type:      "user_entered",
 				UserID:    userID,
 				FenceID:   fence.ID,
-				Location:  newLoc.Location,
+				Location:  seen.Location,
 				Timestamp: time.Now(),
 			}, fence)
 		}
@@ -238,10 +431,20 @@ func (lm *LocationManager) checkGeofences(userID string, newLoc *UserLocation, o
 				Type:      "user_exited",
 				UserID:    userID,
 				FenceID:   fence.ID,
-				Location:  newLoc.Location,
+				Location:  seen.Location,
 				Timestamp: time.Now(),
 			}, fence)
 		}
+
+		// Dwell: timer from the entry, reset on exit
+		if fence.TriggerType == "dwell" {
+			if isInside && !wasInside {
+				lm.startDwell(userID, fence)
+			}
+			if !isInside && wasInside {
+				lm.stopDwell(userID, fence.ID)
+			}
+		}
 	}
 
 	lm.mu.Lock()
@@ -249,42 +452,186 @@ func (lm *LocationManager) checkGeofences(userID string, newLoc *UserLocation, o
 	lm.mu.Unlock()
 }
 
-// Check if point is inside geofence
-func (lm *LocationManager) IsInsideGeoFence(point Point, fence *GeoFence) bool {
-	switch fence.Geometry.Type {
-	case "Point":
-		// Check circle around point
-		coords := fence.Geometry.Coordinates.([]interface{})
-		center := Point{
-			Lat: coords[1].(float64),
-			Lng: coords[0].(float64),
+// Position of the user as the fence creator sees it, false when hidden. The
+// fences without creator (admin UI) see the exact position.
+func geoFenceView(viewers map[string]*locationViewer, fence *GeoFence, userLoc *UserLocation) (*UserLocation, bool) {
+	if fence.CreatedBy == "" {
+		return userLoc, true
+	}
+
+	viewer, exists := viewers[fence.CreatedBy]
+	if !exists {
+		viewer = locationPrivacy.Viewer(fence.CreatedBy, false)
+		viewers[fence.CreatedBy] = viewer
+	}
+	return viewer.View(userLoc)
+}
+
+// Dwell time of a fence, from metadata.dwell_seconds
+func geoFenceDwellTime(fence *GeoFence) time.Duration {
+	if seconds, ok := fence.Metadata["dwell_seconds"].(float64); ok && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	return defaultGeoFenceDwellTime
+}
+
+func dwellKey(userID, fenceID string) string {
+	return userID + "/" + fenceID
+}
+
+func (lm *LocationManager) startDwell(userID string, fence *GeoFence) {
+	key := dwellKey(userID, fence.ID)
+	fenceID := fence.ID
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if _, exists := lm.dwells[key]; exists {
+		return
+	}
+
+	dwell := &geoDwell{enteredAt: time.Now()}
+	dwell.timer = time.AfterFunc(geoFenceDwellTime(fence), func() {
+		lm.dwellElapsed(userID, fenceID, dwell)
+	})
+	lm.dwells[key] = dwell
+}
+
+func (lm *LocationManager) stopDwell(userID, fenceID string) {
+	key := dwellKey(userID, fenceID)
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if dwell, exists := lm.dwells[key]; exists {
+		dwell.timer.Stop()
+		delete(lm.dwells, key)
+	}
+}
+
+// Dwell time passed: user_dwelled if the user is still inside. The entry is
+// kept until the exit so that the event is sent once per visit.
+func (lm *LocationManager) dwellElapsed(userID, fenceID string, dwell *geoDwell) {
+	key := dwellKey(userID, fenceID)
+
+	lm.mu.Lock()
+	if lm.dwells[key] != dwell {
+		lm.mu.Unlock()
+		return // exited meanwhile
+	}
+
+	fence := lm.fences[fenceID]
+	userLoc := lm.locations[userID]
+	inside := fence != nil && fence.IsActive && fence.TriggerType == "dwell" &&
+		userLoc != nil && userLoc.Presence != "offline" && contains(lm.userFences[userID], fenceID)
+	if !inside {
+		// Fence removed or deactivated, user gone offline
+		delete(lm.dwells, key)
+	}
+	lm.mu.Unlock()
+
+	if !inside {
+		return
+	}
+
+	// Hidden from the fence creator since the entry: no event for this visit
+	seen, visible := geoFenceView(map[string]*locationViewer{}, fence, userLoc)
+	if !visible {
+		return
+	}
+
+	now := time.Now()
+	lm.triggerGeoEvent(GeoEvent{
+		Type:      "user_dwelled",
+		UserID:    userID,
+		FenceID:   fenceID,
+		Location:  seen.Location,
+		Timestamp: now,
+		Metadata: map[string]interface{}{
+			"entered_at":    dwell.enteredAt.Format(time.RFC3339),
+			"dwell_seconds": int(now.Sub(dwell.enteredAt).Seconds()),
+		},
+	}, fence)
+}
+
+func readGeoPoint(value interface{}) (Point, bool) {
+	c, ok := value.([]interface{})
+	if !ok || len(c) < 2 {
+		return Point{}, false
+	}
+	lng, okLng := c[0].(float64)
+	lat, okLat := c[1].(float64)
+	if !okLng || !okLat || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return Point{}, false
+	}
+	return Point{Lat: lat, Lng: lng}, true
+}
+
+// Center of a Point or Circle geometry, vertices of a Polygon
+func readGeoFenceShape(geometry GeoJSONGeometry) (center Point, polygon []Point, err error) {
+	switch geometry.Type {
+	case "Point", "Circle":
+		center, ok := readGeoPoint(geometry.Coordinates)
+		if !ok {
+			return Point{}, nil, fmt.Errorf("center coordinates must be [lng, lat]")
 		}
-		distance := HaversineDistance(point, center)
-		return distance <= fence.Geometry.Radius
-
-	case "Circle":
-		coords := fence.Geometry.Coordinates.([]interface{})
-		center := Point{
-			Lat: coords[1].(float64),
-			Lng: coords[0].(float64),
+		if geometry.Radius <= 0 {
+			return Point{}, nil, fmt.Errorf("radius must be positive")
 		}
-		distance := HaversineDistance(point, center)
-		return distance <= fence.Geometry.Radius
+		return center, nil, nil
 
 	case "Polygon":
-		coords := fence.Geometry.Coordinates.([]interface{})
+		// A flat ring, not the nested rings of GeoJSON
+		coords, ok := geometry.Coordinates.([]interface{})
+		if !ok || len(coords) < 3 {
+			return Point{}, nil, fmt.Errorf("polygon coordinates must be at least 3 [lng, lat] points")
+		}
 		polygon := make([]Point, len(coords))
 		for i, coord := range coords {
-			c := coord.([]interface{})
-			polygon[i] = Point{
-				Lat: c[1].(float64),
-				Lng: c[0].(float64),
+			if polygon[i], ok = readGeoPoint(coord); !ok {
+				return Point{}, nil, fmt.Errorf("polygon coordinates must be at least 3 [lng, lat] points")
 			}
 		}
-		return IsPointInPolygon(point, polygon)
+		return Point{}, polygon, nil
 	}
 
-	return false
+	return Point{}, nil, fmt.Errorf("geometry type must be Point, Circle or Polygon")
+}
+
+// Checked before saving a geofence, through the API or the records API
+func validateGeoFenceRecord(record *core.Record) error {
+	var geometry GeoJSONGeometry
+	if err := record.UnmarshalJSONField("geometry", &geometry); err != nil {
+		return fmt.Errorf("invalid geometry")
+	}
+	_, _, err := readGeoFenceShape(geometry)
+	return err
+}
+
+// Bounding box of a geofence, the whole world when the geometry can't be read
+func geoFenceBounds(fence *GeoFence) GeoBounds {
+	center, polygon, err := readGeoFenceShape(fence.Geometry)
+	switch {
+	case err != nil:
+		return GeoBounds{MinLat: -90, MinLng: -180, MaxLat: 90, MaxLng: 180}
+	case polygon != nil:
+		return polygonBounds(polygon)
+	default:
+		return circleBounds(center, fence.Geometry.Radius)
+	}
+}
+
+// Check if point is inside geofence, never for a geometry that can't be read
+func (lm *LocationManager) IsInsideGeoFence(point Point, fence *GeoFence) bool {
+	center, polygon, err := readGeoFenceShape(fence.Geometry)
+	switch {
+	case err != nil:
+		return false
+	case polygon != nil:
+		return IsPointInPolygon(point, polygon)
+	default:
+		return HaversineDistance(point, center) <= fence.Geometry.Radius
+	}
 }
 
 // Trigger geofence event
@@ -292,15 +639,23 @@ func (lm *LocationManager) triggerGeoEvent(event GeoEvent, fence *GeoFence) {
 	log.Printf("🎯 Geo Event: %s - User %s %s fence %s", event.Type, event.UserID, event.Type, fence.Name)
 
 	// Publish event
+	geoPayload := map[string]interface{}{
+		"type":     event.Type,
+		"user_id":  event.UserID,
+		"fence_id": event.FenceID,
+		"fence":    fence,
+		"location": event.Location,
+	}
+	if event.Metadata != nil {
+		geoPayload["metadata"] = event.Metadata
+	}
 	pubsub.Publish("geo_events", PubSubMessage{
-		Topic: "geo_events",
-		Payload: map[string]interface{}{
-			"type":     event.Type,
-			"user_id":  event.UserID,
-			"fence_id": event.FenceID,
-			"fence":    fence,
-			"location": event.Location,
-		},
+		Topic:   "geo_events",
+		Payload: geoPayload,
+	})
+	pubsub.Publish(UserTopic(event.UserID, "geo"), PubSubMessage{
+		Topic:   UserTopic(event.UserID, "geo"),
+		Payload: geoPayload,
 	})
 
 	// Execute actions
@@ -326,18 +681,23 @@ func (lm *LocationManager) executeAction(action string, event GeoEvent, fence *G
 }
 
 func (lm *LocationManager) sendNotification(event GeoEvent, fence *GeoFence) {
-	pubsub.Publish("notifications", PubSubMessage{
-		Topic: "notifications",
-		Payload: map[string]interface{}{
-			"type":    "geofence",
-			"user_id": event.UserID,
-			"title":   fmt.Sprintf("You entered %s", fence.Name),
-			"message": fence.Metadata["notification_message"],
-			"data": map[string]interface{}{
-				"fence_id": fence.ID,
-				"event":    event.Type,
-			},
+	payload := map[string]interface{}{
+		"type":    "geofence",
+		"user_id": event.UserID,
+		"title":   fmt.Sprintf("You entered %s", fence.Name),
+		"message": fence.Metadata["notification_message"],
+		"data": map[string]interface{}{
+			"fence_id": fence.ID,
+			"event":    event.Type,
 		},
+	}
+	pubsub.Publish("notifications", PubSubMessage{
+		Topic:   "notifications",
+		Payload: payload,
+	})
+	pubsub.Publish(UserTopic(event.UserID, "notifications"), PubSubMessage{
+		Topic:   UserTopic(event.UserID, "notifications"),
+		Payload: payload,
 	})
 
 	log.Printf("📬 Notification sent to user %s", event.UserID)
@@ -380,9 +740,40 @@ func (lm *LocationManager) initiateCall(event GeoEvent, fence *GeoFence) {
 		},
 	})
 
+	// Ring the configured target through the call subsystem
+	callTo, ok := fence.Metadata["call_to"].(string)
+	if !ok || callTo == "" || callManager == nil {
+		return
+	}
+	if !geoFenceCallAllowed(event, fence, callTo) {
+		log.Printf("Geofence %s: call from %s to %s not allowed", fence.ID, event.UserID, callTo)
+		return
+	}
+
+	callType, _ := fence.Metadata["call_type"].(string)
+	if _, err := callManager.StartCall(event.UserID, callTo, callType); err != nil {
+		log.Printf("Error starting geofence call for user %s: %v", event.UserID, err)
+		return
+	}
+
 	log.Printf("📞 Call initiated for user %s", event.UserID)
 }
 
+// The call is placed in the name of the entering user and tells the callee
+// where they are: only the fence creator can be rung, and only if the
+// entering user's privacy settings let the creator see their position
+func geoFenceCallAllowed(event GeoEvent, fence *GeoFence, callTo string) bool {
+	if fence.CreatedBy == "" || callTo != fence.CreatedBy {
+		return false
+	}
+
+	_, visible := locationPrivacy.Viewer(fence.CreatedBy, false).View(&UserLocation{
+		UserID:   event.UserID,
+		Location: event.Location,
+	})
+	return visible
+}
+
 // Save location to database
 func (lm *LocationManager) saveLocationToDB(userID string, location Location, presence string) {
 	user, err := lm.app.FindRecordById("users", userID)
@@ -470,9 +861,15 @@ func handleUpdateLocation(c *core.RequestEvent) error {
 	if err := c.BindBody(&req); err != nil {
 		return c.JSON(400, map[string]string{"error": "invalid request"})
 	}
+	if req.Presence != "" && !validPresence(req.Presence) {
+		return c.JSON(400, map[string]string{"error": "presence must be online, away, busy or offline"})
+	}
 
+	// Without presence the update counts as a heartbeat
 	if req.Presence == "" {
-		req.Presence = "online"
+		req.Presence = presenceTracker.Heartbeat(userID)
+	} else {
+		presenceTracker.SetManual(userID, req.Presence)
 	}
 
 	req.Location.Timestamp = time.Now()
@@ -494,6 +891,10 @@ func handleGetLocation(c *core.RequestEvent) error {
 	targetUserID := c.Request.PathValue("userId")
 
 	userLoc, exists := locationManager.GetLocation(targetUserID)
+	if exists {
+		userLoc, exists = locationViewerOf(c).View(userLoc)
+	}
+	// Hidden positions look unknown
 	if !exists {
 		return c.JSON(404, map[string]string{"error": "location not found"})
 	}
@@ -514,7 +915,7 @@ func handleFindNearby(c *core.RequestEvent) error {
 		return c.JSON(400, map[string]string{"error": "invalid request"})
 	}
 
-	nearby := locationManager.FindNearby(req.Point, req.Radius, userID)
+	nearby := locationViewerOf(c).FindNearby(req.Point, req.Radius, userID)
 
 	return c.JSON(200, map[string]interface{}{
 		"count": len(nearby),
@@ -532,7 +933,7 @@ func handleFindInPolygon(c *core.RequestEvent) error {
 		return c.JSON(400, map[string]string{"error": "invalid request"})
 	}
 
-	users := locationManager.FindInPolygon(req.Polygon)
+	users := locationViewerOf(c).FindInPolygon(req.Polygon)
 
 	return c.JSON(200, map[string]interface{}{
 		"count": len(users),
@@ -548,7 +949,7 @@ func handleGetByPresence(c *core.RequestEvent) error {
 		presence = "online"
 	}
 
-	users := locationManager.GetUsersByPresence(presence)
+	users := locationViewerOf(c).ViewAll(locationManager.GetUsersByPresence(presence))
 
 	return c.JSON(200, map[string]interface{}{
 		"presence": presence,
@@ -567,40 +968,27 @@ func handleCreateGeoFence(c *core.RequestEvent) error {
 		return c.JSON(400, map[string]string{"error": "invalid request"})
 	}
 
+	if _, _, err := readGeoFenceShape(fence.Geometry); err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
 	fence.ID = generateID()
 	fence.CreatedBy = userID
 	fence.IsActive = true
 
-	locationManager.AddGeoFence(&fence)
-
-	// Save to database
+	// Save to database, the record hooks add it to the LocationManager
 	r, err := app.FindCollectionByNameOrId("geofences")
 	if err != nil {
 		return c.JSON(500, map[string]string{"error": err.Error()})
 	}
 	geofenceRecord := core.NewRecord(r)
-	geofenceRecord.Set("fenceId", fence.ID)
-	geofenceRecord.Set("name", fence.Name)
-
-	geometryJSON, _ := json.Marshal(fence.Geometry)
-	geofenceRecord.Set("geometry", string(geometryJSON))
-
-	actionsJSON, _ := json.Marshal(fence.Actions)
-	geofenceRecord.Set("actions", string(actionsJSON))
-
-	geofenceRecord.Set("triggerType", fence.TriggerType)
-
-	metadataJSON, _ := json.Marshal(fence.Metadata)
-	geofenceRecord.Set("metadata", string(metadataJSON))
-
-	geofenceRecord.Set("createdBy", userID)
-	geofenceRecord.Set("isActive", true)
+	geoFenceToRecord(&fence, geofenceRecord)
 
 	if err := app.Save(geofenceRecord); err != nil {
 		return c.JSON(500, map[string]string{"error": err.Error()})
 	}
 
-	return c.JSON(200, fence)
+	return c.JSON(200, geoFenceFromRecord(geofenceRecord))
 }
 
 // Update geofence
@@ -617,26 +1005,40 @@ func handleUpdateGeoFence(c *core.RequestEvent) error {
 		return c.JSON(400, map[string]string{"error": "invalid request"})
 	}
 
-	locationManager.mu.Lock()
-	fence, exists := locationManager.fences[fenceID]
-	if !exists {
-		locationManager.mu.Unlock()
+	record, err := c.App.FindFirstRecordByData("geofences", "fenceId", fenceID)
+	if err != nil {
 		return c.JSON(404, map[string]string{"error": "geofence not found"})
 	}
+	if !canEditGeoFence(c, record) {
+		return c.JSON(403, map[string]string{"error": "permission denied"})
+	}
 
 	if updates.IsActive != nil {
-		fence.IsActive = *updates.IsActive
+		record.Set("isActive", *updates.IsActive)
 	}
 	if updates.Actions != nil {
-		fence.Actions = updates.Actions
+		record.Set("actions", updates.Actions)
 	}
 	if updates.Metadata != nil {
-		fence.Metadata = updates.Metadata
+		record.Set("metadata", updates.Metadata)
 	}
 
-	locationManager.mu.Unlock()
+	// The record hooks update the LocationManager
+	if err := c.App.Save(record); err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
 
-	return c.JSON(200, fence)
+	return c.JSON(200, geoFenceFromRecord(record))
+}
+
+// Only the creator and the superusers change a geofence, the ones without
+// creator (admin UI) are left to the superusers
+func canEditGeoFence(c *core.RequestEvent, record *core.Record) bool {
+	if c.Auth != nil && c.Auth.IsSuperuser() {
+		return true
+	}
+	createdBy := record.GetString("createdBy")
+	return createdBy != "" && createdBy == c.Get("userID").(string)
 }
 
 // Delete geofence
@@ -644,12 +1046,16 @@ func handleDeleteGeoFence(c *core.RequestEvent) error {
 	app := c.App
 	fenceID := c.Request.PathValue("fenceId")
 
-	locationManager.RemoveGeoFence(fenceID)
-
-	// Delete from database
-	geofences, _ := app.FindRecordsByFilter("geofences", fmt.Sprintf("fenceId = '%s'", fenceID), "", 1, 0)
-	if len(geofences) > 0 {
-		app.Delete(geofences[0])
+	// The record hooks remove it from the LocationManager
+	record, err := app.FindFirstRecordByData("geofences", "fenceId", fenceID)
+	if err != nil {
+		return c.JSON(404, map[string]string{"error": "geofence not found"})
+	}
+	if !canEditGeoFence(c, record) {
+		return c.JSON(403, map[string]string{"error": "permission denied"})
+	}
+	if err := app.Delete(record); err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
 	}
 
 	return c.JSON(200, map[string]interface{}{
@@ -689,12 +1095,14 @@ func handleNotifyInZone(c *core.RequestEvent) error {
 		return c.JSON(400, map[string]string{"error": "invalid request"})
 	}
 
+	// Only the users whose position the caller can see
 	var users []*UserLocation
+	viewer := locationViewerOf(c)
 
 	if req.Point != nil {
-		users = locationManager.FindNearby(*req.Point, req.Radius, "")
+		users = viewer.FindNearby(*req.Point, req.Radius, "")
 	} else if req.Polygon != nil {
-		users = locationManager.FindInPolygon(req.Polygon)
+		users = viewer.FindInPolygon(req.Polygon)
 	} else {
 		return c.JSON(400, map[string]string{"error": "point or polygon required"})
 	}
@@ -702,15 +1110,20 @@ func handleNotifyInZone(c *core.RequestEvent) error {
 	// Send notifications
 	notifiedCount := 0
 	for _, user := range users {
+		payload := map[string]interface{}{
+			"type":    "zone_notification",
+			"user_id": user.UserID,
+			"title":   req.Title,
+			"message": req.Message,
+			"data":    req.Data,
+		}
 		pubsub.Publish("notifications", PubSubMessage{
-			Topic: "notifications",
-			Payload: map[string]interface{}{
-				"type":    "zone_notification",
-				"user_id": user.UserID,
-				"title":   req.Title,
-				"message": req.Message,
-				"data":    req.Data,
-			},
+			Topic:   "notifications",
+			Payload: payload,
+		})
+		pubsub.Publish(UserTopic(user.UserID, "notifications"), PubSubMessage{
+			Topic:   UserTopic(user.UserID, "notifications"),
+			Payload: payload,
 		})
 		notifiedCount++
 	}
@@ -814,6 +1227,7 @@ func SetupLocationCollections(app core.App) error {
 			&core.JSONField{Name: "location", Required: true},
 			&core.TextField{Name: "presence"},
 			&core.NumberField{Name: "accuracy"},
+			&core.AutodateField{Name: "created", OnCreate: true},
 		)
 		locationHistory.Indexes = []string{"CREATE INDEX idx_user_created ON locationHistory (user, created)"}
 
@@ -0,0 +1,315 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setTestLocationPrivacy(t *testing.T, app core.App, userID string, fields map[string]any) {
+	t.Helper()
+
+	collection, err := app.FindCollectionByNameOrId("locationPrivacy")
+	require.NoError(t, err)
+
+	record, err := app.FindFirstRecordByData("locationPrivacy", "user", userID)
+	if err != nil {
+		record = core.NewRecord(collection)
+		record.Set("user", userID)
+	}
+	for key, value := range fields {
+		record.Set(key, value)
+	}
+	require.NoError(t, app.Save(record))
+	locationPrivacy.Invalidate(userID)
+}
+
+func TestGeoFenceCallAllowed(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupLocationPrivacyCollection(app))
+	locationPrivacy = NewLocationPrivacyStore(app)
+	t.Cleanup(func() { locationPrivacy = nil })
+
+	creator := createTestUser(t, app, "shop@example.com")
+	visitor := createTestUser(t, app, "visitor@example.com")
+	other := createTestUser(t, app, "other@example.com")
+
+	fence := &GeoFence{ID: "f1", CreatedBy: creator, Actions: []string{"call"}}
+	event := GeoEvent{Type: "user_entered", UserID: visitor, FenceID: fence.ID}
+
+	// Followers only by default, the creator doesn't follow the visitor
+	assert.False(t, geoFenceCallAllowed(event, fence, creator))
+
+	setTestLocationPrivacy(t, app, visitor, map[string]any{"visibility": "everyone", "precision": "exact"})
+	assert.True(t, geoFenceCallAllowed(event, fence, creator))
+
+	// Only the creator can be rung
+	assert.False(t, geoFenceCallAllowed(event, fence, other))
+
+	// Ghost mode hides the visitor from the creator
+	setTestLocationPrivacy(t, app, visitor, map[string]any{"ghost": true})
+	assert.False(t, geoFenceCallAllowed(event, fence, creator))
+
+	// Fences without creator (admin UI) never ring anyone
+	setTestLocationPrivacy(t, app, visitor, map[string]any{"ghost": false})
+	assert.False(t, geoFenceCallAllowed(event, &GeoFence{ID: "f2"}, creator))
+}
+
+func geometryFromJSON(t *testing.T, data string) GeoJSONGeometry {
+	t.Helper()
+
+	var geometry GeoJSONGeometry
+	require.NoError(t, json.Unmarshal([]byte(data), &geometry))
+	return geometry
+}
+
+func TestGeoFenceGeometry(t *testing.T) {
+	lm := NewLocationManager(nil)
+	paris := Point{Lat: 48.8566, Lng: 2.3522}
+
+	circle := &GeoFence{Geometry: geometryFromJSON(t, `{"type":"Circle","coordinates":[2.3522,48.8566],"radius":100}`)}
+	assert.True(t, lm.IsInsideGeoFence(paris, circle))
+	assert.False(t, lm.IsInsideGeoFence(Point{Lat: 48.86, Lng: 2.3522}, circle))
+
+	square := &GeoFence{Geometry: geometryFromJSON(t, `{"type":"Polygon","coordinates":[[2.35,48.85],[2.36,48.85],[2.36,48.86],[2.35,48.86]]}`)}
+	assert.True(t, lm.IsInsideGeoFence(paris, square))
+	assert.Equal(t, GeoBounds{MinLat: 48.85, MinLng: 2.35, MaxLat: 48.86, MaxLng: 2.36}, geoFenceBounds(square))
+
+	for _, invalid := range []string{
+		// GeoJSON rings
+		`{"type":"Polygon","coordinates":[[[2.35,48.85],[2.36,48.85],[2.36,48.86],[2.35,48.85]]]}`,
+		`{"type":"Polygon","coordinates":[[2.35,48.85],[2.36,48.85]]}`,
+		`{"type":"Polygon","coordinates":[[2.35,48.85],[2.36,"48.85"],[2.36,48.86]]}`,
+		`{"type":"Circle","coordinates":[2.3522,48.8566]}`,
+		`{"type":"Point","coordinates":[2.3522],"radius":100}`,
+		`{"type":"Point","coordinates":{"lat":48.8566,"lng":2.3522},"radius":100}`,
+		`{"type":"Circle","coordinates":[2.3522,148.8566],"radius":100}`,
+		`{"type":"LineString","coordinates":[[2.35,48.85],[2.36,48.85]]}`,
+	} {
+		geometry := geometryFromJSON(t, invalid)
+		_, _, err := readGeoFenceShape(geometry)
+		assert.Error(t, err, invalid)
+
+		// Loaded anyway from an older record: never inside, found everywhere
+		fence := &GeoFence{Geometry: geometry}
+		assert.False(t, lm.IsInsideGeoFence(paris, fence), invalid)
+		assert.Equal(t, GeoBounds{MinLat: -90, MinLng: -180, MaxLat: 90, MaxLng: 180}, geoFenceBounds(fence), invalid)
+	}
+}
+
+func TestGeoFenceHandlers(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupLocationCollections(app))
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	r, err := apis.NewRouter(app)
+	require.NoError(t, err)
+	withUser := func(handler func(*core.RequestEvent) error) func(*core.RequestEvent) error {
+		return func(e *core.RequestEvent) error {
+			e.Set("userID", e.Request.Header.Get("X-User"))
+			return handler(e)
+		}
+	}
+	r.POST("/api/geofences", withUser(handleCreateGeoFence))
+	r.PATCH("/api/geofences/{fenceId}", withUser(handleUpdateGeoFence))
+	r.DELETE("/api/geofences/{fenceId}", withUser(handleDeleteGeoFence))
+	mux, err := r.BuildMux()
+	require.NoError(t, err)
+
+	call := func(method, target, userID, body string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User", userID)
+		mux.ServeHTTP(rec, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec.Code, response
+	}
+
+	status, _ := call(http.MethodPost, "/api/geofences", alice,
+		`{"name":"shop","geometry":{"type":"Polygon","coordinates":[[[2.35,48.85],[2.36,48.85],[2.36,48.86],[2.35,48.85]]]}}`)
+	assert.Equal(t, 400, status)
+
+	status, fence := call(http.MethodPost, "/api/geofences", alice,
+		`{"name":"shop","geometry":{"type":"Circle","coordinates":[2.3522,48.8566],"radius":100}}`)
+	require.Equal(t, 200, status)
+	fenceID, _ := fence["id"].(string)
+	require.NotEmpty(t, fenceID)
+	assert.Equal(t, alice, fence["created_by"])
+
+	// Someone else's fence
+	status, _ = call(http.MethodPatch, "/api/geofences/"+fenceID, bob, `{"is_active":false}`)
+	assert.Equal(t, 403, status)
+	status, _ = call(http.MethodDelete, "/api/geofences/"+fenceID, bob, "")
+	assert.Equal(t, 403, status)
+
+	status, fence = call(http.MethodPatch, "/api/geofences/"+fenceID, alice, `{"is_active":false}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, false, fence["is_active"])
+	status, _ = call(http.MethodDelete, "/api/geofences/"+fenceID, alice, "")
+	assert.Equal(t, 200, status)
+	status, _ = call(http.MethodDelete, "/api/geofences/"+fenceID, alice, "")
+	assert.Equal(t, 404, status)
+}
+
+func newTestGeoFences(t *testing.T, fences ...*GeoFence) *LocationManager {
+	t.Helper()
+
+	saved := pubsub
+	pubsub = NewMemoryPubSub()
+	t.Cleanup(func() { pubsub = saved })
+
+	lm := NewLocationManager(nil)
+	for _, fence := range fences {
+		lm.AddGeoFence(fence)
+	}
+	return lm
+}
+
+func TestGeoFenceDwell(t *testing.T) {
+	fence := &GeoFence{
+		ID:          "shop",
+		Name:        "shop",
+		Geometry:    GeoJSONGeometry{Type: "Circle", Coordinates: []interface{}{2.3522, 48.8566}, Radius: 100},
+		TriggerType: "dwell",
+		Metadata:    map[string]interface{}{"dwell_seconds": 0.1},
+		IsActive:    true,
+	}
+	lm := newTestGeoFences(t, fence)
+	events := pubsub.Subscribe(UserTopic("alice", "geo"))
+	defer events.Unsubscribe()
+
+	inside := Point{Lat: 48.8566, Lng: 2.3522}
+	outside := Point{Lat: 48.87, Lng: 2.3522}
+
+	// Left before the dwell time: nothing, and the timer is reset
+	placeTestUser(lm, "alice", inside)
+	lm.checkGeofences("alice")
+	assert.Contains(t, lm.dwells, dwellKey("alice", "shop"))
+	placeTestUser(lm, "alice", outside)
+	lm.checkGeofences("alice")
+	assert.NotContains(t, lm.dwells, dwellKey("alice", "shop"))
+	time.Sleep(150 * time.Millisecond)
+	assertNoMessage(t, events)
+
+	// Stayed: one event per visit
+	placeTestUser(lm, "alice", inside)
+	lm.checkGeofences("alice")
+	entered := time.Now()
+	msg := receiveMessage(t, events)
+	assert.GreaterOrEqual(t, time.Since(entered), 100*time.Millisecond)
+	assert.Equal(t, "user_dwelled", msg.Payload["type"])
+	assert.Equal(t, "shop", msg.Payload["fence_id"])
+	metadata, ok := msg.Payload["metadata"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Contains(t, metadata, "entered_at")
+
+	lm.checkGeofences("alice")
+	time.Sleep(150 * time.Millisecond)
+	assertNoMessage(t, events)
+
+	placeTestUser(lm, "alice", outside)
+	lm.checkGeofences("alice")
+	assert.NotContains(t, lm.dwells, dwellKey("alice", "shop"))
+	assert.Empty(t, lm.userFences["alice"])
+}
+
+func TestGeoFenceDwellTime(t *testing.T) {
+	assert.Equal(t, defaultGeoFenceDwellTime, geoFenceDwellTime(&GeoFence{}))
+	assert.Equal(t, defaultGeoFenceDwellTime, geoFenceDwellTime(&GeoFence{Metadata: map[string]interface{}{"dwell_seconds": "60"}}))
+	assert.Equal(t, 90*time.Second, geoFenceDwellTime(&GeoFence{Metadata: map[string]interface{}{"dwell_seconds": 90.0}}))
+}
+
+func TestCheckGeofencesConcurrent(t *testing.T) {
+	fence := &GeoFence{
+		ID:       "shop",
+		Name:     "shop",
+		Geometry: GeoJSONGeometry{Type: "Circle", Coordinates: []interface{}{2.3522, 48.8566}, Radius: 100},
+		IsActive: true,
+	}
+	lm := newTestGeoFences(t, fence)
+
+	// Checks of older positions finishing after the latest one
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		point := Point{Lat: 48.87, Lng: 2.3522}
+		if i%2 == 0 {
+			point = Point{Lat: 48.8566, Lng: 2.3522}
+		}
+		placeTestUser(lm, "alice", point)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lm.checkGeofences("alice")
+		}()
+	}
+	wg.Wait()
+
+	// Last position outside: the state follows it
+	assert.Empty(t, lm.userFences["alice"])
+	assert.Empty(t, lm.checks)
+
+	placeTestUser(lm, "alice", Point{Lat: 48.8566, Lng: 2.3522})
+	lm.checkGeofences("alice")
+	assert.Equal(t, []string{"shop"}, lm.userFences["alice"])
+}
+
+func TestGeoFenceCreatorVisibility(t *testing.T) {
+	app := newTestLocationPrivacy(t)
+	creator := createTestUser(t, app, "shop@example.com")
+	alice := createTestUser(t, app, "alice@example.com")
+
+	shop := Point{Lat: 48.8566, Lng: 2.3522}
+	fence := &GeoFence{
+		ID:        "shop",
+		Name:      "shop",
+		Geometry:  GeoJSONGeometry{Type: "Circle", Coordinates: []interface{}{shop.Lng, shop.Lat}, Radius: 50},
+		Actions:   []string{"ads"},
+		CreatedBy: creator,
+		IsActive:  true,
+	}
+	lm := newTestGeoFences(t, fence)
+	events := pubsub.Subscribe(UserTopic(alice, "geo"))
+	defer events.Unsubscribe()
+	ads := pubsub.Subscribe("ads")
+	defer ads.Unsubscribe()
+
+	// Hidden from the creator: neither the event nor the actions
+	placeTestUser(lm, alice, shop)
+	lm.checkGeofences(alice)
+	assertNoMessage(t, events)
+	assertNoMessage(t, ads)
+	assert.Empty(t, lm.userFences[alice])
+
+	setTestLocationPrivacy(t, app, alice, map[string]any{"visibility": "everyone"})
+	lm.checkGeofences(alice)
+	msg := receiveMessage(t, events)
+	assert.Equal(t, "user_entered", msg.Payload["type"])
+	assert.Equal(t, alice, receiveMessage(t, ads).Payload["user_id"])
+
+	// Gone ghost inside: still inside for the fence, no exit seen
+	setTestLocationPrivacy(t, app, alice, map[string]any{"ghost": true})
+	placeTestUser(lm, alice, Point{Lat: 48.87, Lng: 2.3522})
+	lm.checkGeofences(alice)
+	assert.Equal(t, []string{"shop"}, lm.userFences[alice])
+
+	// Tested on the fuzzed position, which falls outside the small fence
+	setTestLocationPrivacy(t, app, alice, map[string]any{"ghost": false, "precision": "city"})
+	placeTestUser(lm, alice, shop)
+	require.Greater(t, HaversineDistance(shop, fuzzLocation(Location{Point: shop}, "city").Point), 50.0)
+	lm.checkGeofences(alice)
+	assert.Empty(t, lm.userFences[alice])
+	assertNoMessage(t, events)
+}
@@ -0,0 +1,392 @@
+package app
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// ==================== LOCATION HISTORY ====================
+
+// Positions received by UpdateLocation are sampled into the locationHistory
+// collection: at most one point per Interval, and only once the user moved
+// Distance meters from the last recorded point (a user standing still adds
+// nothing). Points older than Retention are deleted every hour.
+
+type LocationHistoryOptions struct {
+	Interval  time.Duration
+	Distance  float64       // meters
+	Retention time.Duration // 0 keeps everything
+}
+
+// Defaults, overridden by the --locationHistoryInterval, --locationHistoryDistance
+// and --locationHistoryRetention flags
+var DefaultLocationHistoryOptions = LocationHistoryOptions{
+	Interval:  30 * time.Second,
+	Distance:  20,
+	Retention: 30 * 24 * time.Hour,
+}
+
+// Points loaded for one track, before simplification
+const maxTrackPoints = 50000
+
+type recordedPoint struct {
+	point Point
+	at    time.Time
+}
+
+// LocationHistory - Enregistrement échantillonné des positions
+type LocationHistory struct {
+	options LocationHistoryOptions
+	last    map[string]recordedPoint // userID -> last recorded point
+	app     core.App
+	mu      sync.Mutex
+}
+
+func NewLocationHistory(app core.App, options LocationHistoryOptions) *LocationHistory {
+	if options.Interval < 0 {
+		options.Interval = 0
+	}
+	if options.Distance < 0 {
+		options.Distance = 0
+	}
+
+	return &LocationHistory{
+		options: options,
+		last:    make(map[string]recordedPoint),
+		app:     app,
+	}
+}
+
+// Record a position if the sampling allows it
+func (lh *LocationHistory) Record(userID string, location Location, presence string) {
+	if lh == nil {
+		return
+	}
+
+	at := location.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	lh.mu.Lock()
+	last, exists := lh.last[userID]
+	if exists && (at.Sub(last.at) < lh.options.Interval || HaversineDistance(last.point, location.Point) < lh.options.Distance) {
+		lh.mu.Unlock()
+		return
+	}
+	lh.last[userID] = recordedPoint{point: location.Point, at: at}
+	lh.mu.Unlock()
+
+	collection, err := lh.app.FindCachedCollectionByNameOrId("locationHistory")
+	if err != nil {
+		log.Printf("Error recording location history: %v", err)
+		return
+	}
+
+	location.Timestamp = at
+	record := core.NewRecord(collection)
+	record.Set("user", userID)
+	record.Set("location", location)
+	record.Set("presence", presence)
+	record.Set("accuracy", location.Accuracy)
+
+	if err := lh.app.Save(record); err != nil {
+		log.Printf("Error recording location history for user %s: %v", userID, err)
+	}
+}
+
+// Recorded positions of a user in [from, to], oldest first. truncated when
+// the range has more than maxTrackPoints, only the oldest are returned.
+func (lh *LocationHistory) Track(userID string, from, to time.Time) (points []Location, truncated bool, err error) {
+	return lh.track(userID, from, to, maxTrackPoints)
+}
+
+func (lh *LocationHistory) track(userID string, from, to time.Time, limit int) ([]Location, bool, error) {
+	fromDT, _ := types.ParseDateTime(from)
+	toDT, _ := types.ParseDateTime(to)
+
+	records, err := lh.app.FindRecordsByFilter(
+		"locationHistory",
+		"user = {:user} && created >= {:from} && created <= {:to}",
+		"created",
+		limit+1,
+		0,
+		map[string]interface{}{"user": userID, "from": fromDT.String(), "to": toDT.String()},
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	truncated := len(records) > limit
+	if truncated {
+		records = records[:limit]
+	}
+
+	points := make([]Location, 0, len(records))
+	for _, record := range records {
+		var location Location
+		if err := record.UnmarshalJSONField("location", &location); err != nil {
+			continue
+		}
+		if location.Timestamp.IsZero() {
+			location.Timestamp = record.GetDateTime("created").Time()
+		}
+		points = append(points, location)
+	}
+
+	return points, truncated, nil
+}
+
+// Delete the points older than the retention
+func (lh *LocationHistory) Cleanup() {
+	if lh == nil || lh.options.Retention <= 0 {
+		return
+	}
+
+	cutoff, _ := types.ParseDateTime(time.Now().Add(-lh.options.Retention))
+
+	_, err := lh.app.DB().NewQuery("DELETE FROM locationHistory WHERE created < {:cutoff}").
+		Bind(map[string]interface{}{"cutoff": cutoff.String()}).
+		Execute()
+	if err != nil {
+		log.Printf("Error cleaning location history: %v", err)
+	}
+}
+
+// ==================== TRACK SIMPLIFICATION ====================
+
+// Douglas-Peucker on a local plane around the first point, tolerance in meters
+func SimplifyTrack(points []Location, tolerance float64) []Location {
+	if tolerance <= 0 || len(points) < 3 {
+		return points
+	}
+
+	origin := points[0].Point
+	cos := math.Cos(origin.Lat * math.Pi / 180)
+	xy := func(p Point) (float64, float64) {
+		return (p.Lng - origin.Lng) * metersPerDegreeLat * cos, (p.Lat - origin.Lat) * metersPerDegreeLat
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// Iterative, long tracks would go deep in recursion
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		ax, ay := xy(points[first].Point)
+		bx, by := xy(points[last].Point)
+
+		farthest, maxDistance := -1, 0.0
+		for i := first + 1; i < last; i++ {
+			px, py := xy(points[i].Point)
+			if d := segmentDistance(px, py, ax, ay, bx, by); d > maxDistance {
+				farthest, maxDistance = i, d
+			}
+		}
+
+		if farthest >= 0 && maxDistance > tolerance {
+			keep[farthest] = true
+			stack = append(stack, [2]int{first, farthest}, [2]int{farthest, last})
+		}
+	}
+
+	simplified := make([]Location, 0, len(points))
+	for i, point := range points {
+		if keep[i] {
+			simplified = append(simplified, point)
+		}
+	}
+	return simplified
+}
+
+// Distance from p to the segment [a, b]
+func segmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	if dx == 0 && dy == 0 {
+		return math.Hypot(px-ax, py-ay)
+	}
+
+	t := ((px-ax)*dx + (py-ay)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+// Length of a track in meters
+func trackDistance(points []Location) float64 {
+	distance := 0.0
+	for i := 1; i < len(points); i++ {
+		distance += HaversineDistance(points[i-1].Point, points[i].Point)
+	}
+	return distance
+}
+
+// ==================== EXPORT ====================
+
+type gpxDocument struct {
+	XMLName xml.Name `xml:"gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string     `xml:"name"`
+	Segment gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Ele  float64 `xml:"ele,omitempty"`
+	Time string  `xml:"time"`
+}
+
+func trackToGPX(name string, points []Location) ([]byte, error) {
+	doc := gpxDocument{
+		Version: "1.1",
+		Creator: "tania",
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Track:   gpxTrack{Name: name},
+	}
+
+	for _, p := range points {
+		doc.Track.Segment.Points = append(doc.Track.Segment.Points, gpxPoint{
+			Lat:  p.Point.Lat,
+			Lon:  p.Point.Lng,
+			Ele:  p.Altitude,
+			Time: p.Timestamp.UTC().Format(time.RFC3339),
+		})
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// GeoJSON Feature with a LineString, the times in properties.times
+func trackToGeoJSON(userID string, from, to time.Time, points []Location, truncated bool) map[string]interface{} {
+	coordinates := make([][]float64, len(points))
+	times := make([]string, len(points))
+	for i, p := range points {
+		coordinates[i] = []float64{p.Point.Lng, p.Point.Lat}
+		times[i] = p.Timestamp.UTC().Format(time.RFC3339)
+	}
+
+	return map[string]interface{}{
+		"type": "Feature",
+		"geometry": map[string]interface{}{
+			"type":        "LineString",
+			"coordinates": coordinates,
+		},
+		"properties": map[string]interface{}{
+			"user_id":   userID,
+			"from":      from,
+			"to":        to,
+			"times":     times,
+			"distance":  trackDistance(points),
+			"truncated": truncated,
+		},
+	}
+}
+
+// ==================== HTTP HANDLERS ====================
+
+// Track of a user (the user or a superuser), ?from=&to= (last 24 hours by
+// default), ?tolerance= in meters (10, 0 for every point), ?format=gpx|geojson
+func handleGetLocationHistory(c *core.RequestEvent) error {
+	targetUserID := c.Request.PathValue("userId")
+
+	if !c.HasSuperuserAuth() && c.Get("userID").(string) != targetUserID {
+		return c.JSON(403, map[string]string{"error": "only the user can view their location history"})
+	}
+
+	if locationManager == nil || locationManager.history == nil {
+		return c.JSON(503, map[string]string{"error": "location history disabled"})
+	}
+
+	query := c.Request.URL.Query()
+	from, to, err := parseReportRange(c)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	if query.Get("from") == "" {
+		from = to.Add(-24 * time.Hour)
+	}
+
+	tolerance := 10.0
+	if v := query.Get("tolerance"); v != "" {
+		if tolerance, err = strconv.ParseFloat(v, 64); err != nil || tolerance < 0 {
+			return c.JSON(400, map[string]string{"error": "invalid tolerance"})
+		}
+	}
+
+	points, truncated, err := locationManager.history.Track(targetUserID, from, to)
+	if err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	simplified := SimplifyTrack(points, tolerance)
+	if truncated {
+		// GPX has no place for it
+		c.Response.Header().Set("X-Track-Truncated", "true")
+	}
+
+	filename := fmt.Sprintf("track-%s-%s", targetUserID, from.UTC().Format("20060102T150405"))
+
+	switch query.Get("format") {
+	case "gpx":
+		body, err := trackToGPX(filename, simplified)
+		if err != nil {
+			return c.JSON(500, map[string]string{"error": err.Error()})
+		}
+		c.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".gpx"))
+		return c.Blob(200, "application/gpx+xml", body)
+
+	case "geojson":
+		body, err := json.Marshal(trackToGeoJSON(targetUserID, from, to, simplified, truncated))
+		if err != nil {
+			return c.JSON(500, map[string]string{"error": err.Error()})
+		}
+		c.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".geojson"))
+		return c.Blob(200, "application/geo+json", body)
+
+	case "", "json":
+	default:
+		return c.JSON(400, map[string]string{"error": "format must be json, gpx or geojson"})
+	}
+
+	duration := 0.0
+	if len(points) > 1 {
+		duration = points[len(points)-1].Timestamp.Sub(points[0].Timestamp).Seconds()
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"user_id":        targetUserID,
+		"from":           from,
+		"to":             to,
+		"points":         simplified,
+		"count":          len(simplified),
+		"original_count": len(points),
+		"truncated":      truncated,
+		"distance":       trackDistance(points),
+		"duration":       duration,
+	})
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Points going north, lat step in degrees (0.001° ≈ 111 m)
+func northTrack(start time.Time, count int, step float64) []Location {
+	points := make([]Location, count)
+	for i := range points {
+		points[i] = Location{
+			Point:     Point{Lat: 48.8 + float64(i)*step, Lng: 2.35},
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return points
+}
+
+func TestLocationHistoryRecord(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupLocationCollections(app))
+	alice := createTestUser(t, app, "alice@example.com")
+	lh := NewLocationHistory(app, LocationHistoryOptions{Interval: 30 * time.Second, Distance: 20})
+
+	start := time.Now().Add(-10 * time.Minute)
+	at := func(seconds int, lat float64) Location {
+		return Location{Point: Point{Lat: lat, Lng: 2.35}, Timestamp: start.Add(time.Duration(seconds) * time.Second)}
+	}
+	lh.Record(alice, at(0, 48.8), "online")
+	lh.Record(alice, at(10, 48.801), "online")   // too soon
+	lh.Record(alice, at(40, 48.80001), "online") // not moved
+	lh.Record(alice, at(50, 48.801), "online")
+	lh.Record(alice, at(90, 48.802), "away")
+
+	from, to := start.Add(-time.Hour), time.Now().Add(time.Hour)
+	points, truncated, err := lh.Track(alice, from, to)
+	require.NoError(t, err)
+	assert.False(t, truncated)
+	require.Len(t, points, 3)
+	assert.Equal(t, 48.8, points[0].Point.Lat)
+	assert.Equal(t, 48.801, points[1].Point.Lat)
+	assert.True(t, points[1].Timestamp.Equal(start.Add(50*time.Second)))
+
+	points, truncated, err = lh.track(alice, from, to, 2)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	require.Len(t, points, 2)
+	assert.Equal(t, 48.8, points[0].Point.Lat, "oldest kept")
+
+	points, _, err = lh.Track(createTestUser(t, app, "bob@example.com"), from, to)
+	require.NoError(t, err)
+	assert.Empty(t, points)
+}
+
+func TestSimplifyTrack(t *testing.T) {
+	start := time.Now()
+
+	// Straight line: the ends only
+	line := northTrack(start, 50, 0.001)
+	simplified := SimplifyTrack(line, 10)
+	require.Len(t, simplified, 2)
+	assert.Equal(t, line[0], simplified[0])
+	assert.Equal(t, line[49], simplified[1])
+
+	// A 200 m detour is kept, a 5 m one is not
+	metersLng := metersPerDegreeLat * math.Cos(48.8*math.Pi/180)
+	detour := northTrack(start, 3, 0.001)
+	detour[1].Point.Lng += 200 / metersLng
+	assert.Len(t, SimplifyTrack(detour, 10), 3)
+	detour[1].Point.Lng = 2.35 + 5/metersLng
+	assert.Equal(t, []Location{detour[0], detour[2]}, SimplifyTrack(detour, 10))
+	assert.Len(t, SimplifyTrack(detour, 1), 3)
+
+	// Tolerance 0 or too few points: unchanged
+	assert.Len(t, SimplifyTrack(line, 0), 50)
+	assert.Len(t, SimplifyTrack(line[:2], 10), 2)
+}
+
+func TestSegmentDistance(t *testing.T) {
+	assert.InDelta(t, 5, segmentDistance(5, 5, 0, 0, 10, 0), 1e-9)
+	assert.InDelta(t, 5, segmentDistance(-3, 4, 0, 0, 10, 0), 1e-9, "past the first end")
+	assert.InDelta(t, 5, segmentDistance(3, 4, 0, 0, 0, 0), 1e-9, "point segment")
+}
+
+func TestTrackExports(t *testing.T) {
+	start := time.Date(2026, 5, 1, 8, 0, 0, 0, time.UTC)
+	points := northTrack(start, 3, 0.001)
+	points[1].Point.Lat = 48.801
+	points[2].Point.Lat = 48.802
+	points[1].Altitude = 35
+
+	body, err := trackToGPX("track-alice", points)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), xml.Header)
+
+	var gpx gpxDocument
+	require.NoError(t, xml.Unmarshal(body, &gpx))
+	assert.Equal(t, "1.1", gpx.Version)
+	assert.Equal(t, "track-alice", gpx.Track.Name)
+	require.Len(t, gpx.Track.Segment.Points, 3)
+	assert.Equal(t, gpxPoint{Lat: 48.801, Lon: 2.35, Ele: 35, Time: "2026-05-01T08:01:00Z"}, gpx.Track.Segment.Points[1])
+
+	body, err = json.Marshal(trackToGeoJSON("alice", start, start.Add(time.Hour), points, true))
+	require.NoError(t, err)
+
+	var feature struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string      `json:"type"`
+			Coordinates [][]float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			UserID    string   `json:"user_id"`
+			Times     []string `json:"times"`
+			Distance  float64  `json:"distance"`
+			Truncated bool     `json:"truncated"`
+		} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(body, &feature))
+	assert.Equal(t, "Feature", feature.Type)
+	assert.Equal(t, "LineString", feature.Geometry.Type)
+	assert.Equal(t, [][]float64{{2.35, 48.8}, {2.35, 48.801}, {2.35, 48.802}}, feature.Geometry.Coordinates)
+	assert.Equal(t, []string{"2026-05-01T08:00:00Z", "2026-05-01T08:01:00Z", "2026-05-01T08:02:00Z"}, feature.Properties.Times)
+	assert.InDelta(t, 222, feature.Properties.Distance, 1)
+	assert.Equal(t, "alice", feature.Properties.UserID)
+	assert.True(t, feature.Properties.Truncated)
+}
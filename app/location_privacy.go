@@ -0,0 +1,342 @@
+package app
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// ==================== LOCATION PRIVACY ====================
+
+// Each user chooses who sees their position and how precisely:
+//   - visibility: everyone, followers (active follows), selected (AllowedUsers), nobody
+//   - precision: exact, 100m, 1km, city. The position is snapped to the center
+//     of a grid cell of that size, so repeated queries give the same value.
+//   - ghost: hidden from everyone, until disabled or GhostUntil
+//
+// The user and the superusers always see the exact position. The rules apply
+// to every location endpoint (the DataChannel and WebSocket requests go
+// through the same handlers) and to the location script API.
+
+type LocationPrivacy struct {
+	Visibility   string     `json:"visibility"`
+	Precision    string     `json:"precision"`
+	AllowedUsers []string   `json:"allowed_users"`
+	Ghost        bool       `json:"ghost"`
+	GhostUntil   *time.Time `json:"ghost_until,omitempty"`
+}
+
+// Settings of the users who never changed them
+var DefaultLocationPrivacy = LocationPrivacy{
+	Visibility: "followers",
+	Precision:  "exact",
+}
+
+var locationVisibilities = []string{"everyone", "followers", "selected", "nobody"}
+
+// Size of the grid cells of each precision
+var locationPrecisionMeters = map[string]float64{
+	"exact": 0,
+	"100m":  100,
+	"1km":   1000,
+	"city":  10000,
+}
+
+// Nearby searches look this far past the radius for the fuzzed positions
+var locationFuzzMargin = locationPrecisionMeters["city"] * math.Sqrt2 / 2
+
+func (p LocationPrivacy) ghostActive(now time.Time) bool {
+	return p.Ghost && (p.GhostUntil == nil || now.Before(*p.GhostUntil))
+}
+
+// Snap a location to the center of its cell, without the motion details
+func fuzzLocation(location Location, precision string) Location {
+	step := locationPrecisionMeters[precision]
+	if step <= 0 {
+		return location
+	}
+
+	latStep := step / metersPerDegreeLat
+	lat := (math.Floor(location.Point.Lat/latStep) + 0.5) * latStep
+	lngStep := latStep / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+	lng := (math.Floor(location.Point.Lng/lngStep) + 0.5) * lngStep
+
+	return Location{
+		Point:     Point{Lat: lat, Lng: lng},
+		Accuracy:  math.Max(location.Accuracy, step),
+		Timestamp: location.Timestamp,
+	}
+}
+
+// ==================== PRIVACY STORE ====================
+
+// LocationPrivacyStore - Réglages de confidentialité, en cache
+type LocationPrivacyStore struct {
+	settings map[string]LocationPrivacy // userID -> settings
+	app      core.App
+	mu       sync.RWMutex
+}
+
+func NewLocationPrivacyStore(app core.App) *LocationPrivacyStore {
+	return &LocationPrivacyStore{
+		settings: make(map[string]LocationPrivacy),
+		app:      app,
+	}
+}
+
+// Settings of a user, the defaults when unset
+func (ps *LocationPrivacyStore) Get(userID string) LocationPrivacy {
+	if ps == nil {
+		return DefaultLocationPrivacy
+	}
+
+	ps.mu.RLock()
+	settings, exists := ps.settings[userID]
+	ps.mu.RUnlock()
+	if exists {
+		return settings
+	}
+
+	settings = DefaultLocationPrivacy
+	record, err := ps.app.FindFirstRecordByFilter("locationPrivacy", "user = {:user}", dbx.Params{"user": userID})
+	if err == nil {
+		settings = locationPrivacyFromRecord(record)
+	}
+
+	ps.mu.Lock()
+	ps.settings[userID] = settings
+	ps.mu.Unlock()
+
+	return settings
+}
+
+// Forget the cached settings of a user (record hooks)
+func (ps *LocationPrivacyStore) Invalidate(userID string) {
+	if ps == nil {
+		return
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.settings, userID)
+}
+
+func locationPrivacyFromRecord(record *core.Record) LocationPrivacy {
+	settings := LocationPrivacy{
+		Visibility:   record.GetString("visibility"),
+		Precision:    record.GetString("precision"),
+		AllowedUsers: record.GetStringSlice("allowedUsers"),
+		Ghost:        record.GetBool("ghost"),
+	}
+	if settings.Visibility == "" {
+		settings.Visibility = DefaultLocationPrivacy.Visibility
+	}
+	if settings.Precision == "" {
+		settings.Precision = DefaultLocationPrivacy.Precision
+	}
+	if until := record.GetDateTime("ghostUntil"); !until.IsZero() {
+		t := until.Time()
+		settings.GhostUntil = &t
+	}
+	return settings
+}
+
+// ==================== VIEWER ====================
+
+// locationViewer - Utilisateur qui consulte les positions, le temps d'une requête
+type locationViewer struct {
+	userID    string // empty for the scripts acting for nobody
+	superuser bool
+	following map[string]bool // loaded on the first followers check
+	store     *LocationPrivacyStore
+}
+
+func (ps *LocationPrivacyStore) Viewer(userID string, superuser bool) *locationViewer {
+	return &locationViewer{userID: userID, superuser: superuser, store: ps}
+}
+
+// Viewer of an HTTP request (or of a request dispatched from a user channel)
+func locationViewerOf(c *core.RequestEvent) *locationViewer {
+	userID, _ := c.Get("userID").(string)
+	return locationPrivacy.Viewer(userID, c.HasSuperuserAuth())
+}
+
+func (v *locationViewer) follows(userID string) bool {
+	if v.userID == "" || v.store == nil {
+		return false
+	}
+
+	if v.following == nil {
+		v.following = make(map[string]bool)
+		follows, _ := v.store.app.FindAllRecords("follows", dbx.HashExp{"follower": v.userID, "status": "active"})
+		for _, follow := range follows {
+			v.following[follow.GetString("following")] = true
+		}
+	}
+	return v.following[userID]
+}
+
+// Position of userLoc as seen by the viewer, false when hidden
+func (v *locationViewer) View(userLoc *UserLocation) (*UserLocation, bool) {
+	if v.superuser || (v.userID != "" && v.userID == userLoc.UserID) {
+		return userLoc, true
+	}
+
+	settings := v.store.Get(userLoc.UserID)
+	if settings.ghostActive(time.Now()) {
+		return nil, false
+	}
+
+	switch settings.Visibility {
+	case "everyone":
+	case "followers":
+		if !v.follows(userLoc.UserID) {
+			return nil, false
+		}
+	case "selected":
+		if v.userID == "" || !contains(settings.AllowedUsers, v.userID) {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	if locationPrecisionMeters[settings.Precision] <= 0 {
+		return userLoc, true
+	}
+
+	fuzzed := *userLoc
+	fuzzed.Location = fuzzLocation(userLoc.Location, settings.Precision)
+	return &fuzzed, true
+}
+
+// Visible positions among users
+func (v *locationViewer) ViewAll(users []*UserLocation) []*UserLocation {
+	visible := make([]*UserLocation, 0, len(users))
+	for _, userLoc := range users {
+		if seen, ok := v.View(userLoc); ok {
+			visible = append(visible, seen)
+		}
+	}
+	return visible
+}
+
+// Visible users within radius, tested on the positions the viewer sees
+func (v *locationViewer) FindNearby(point Point, radiusMeters float64, excludeUserID string) []*UserLocation {
+	candidates := locationManager.FindNearby(point, radiusMeters+locationFuzzMargin, excludeUserID)
+
+	nearby := []*UserLocation{}
+	for _, userLoc := range v.ViewAll(candidates) {
+		if HaversineDistance(point, userLoc.Location.Point) <= radiusMeters {
+			nearby = append(nearby, userLoc)
+		}
+	}
+	return nearby
+}
+
+// Visible users in polygon, tested on the positions the viewer sees
+func (v *locationViewer) FindInPolygon(polygon []Point) []*UserLocation {
+	inside := []*UserLocation{}
+	for _, userLoc := range v.ViewAll(locationManager.FindInPolygon(polygon)) {
+		if IsPointInPolygon(userLoc.Location.Point, polygon) {
+			inside = append(inside, userLoc)
+		}
+	}
+	return inside
+}
+
+// ==================== HTTP HANDLERS ====================
+
+func handleGetLocationPrivacy(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+	return c.JSON(200, locationPrivacy.Get(userID))
+}
+
+// Partial update, {visibility, precision, allowed_users, ghost, ghost_until}
+func handleUpdateLocationPrivacy(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	var req struct {
+		Visibility   *string    `json:"visibility,omitempty"`
+		Precision    *string    `json:"precision,omitempty"`
+		AllowedUsers []string   `json:"allowed_users,omitempty"`
+		Ghost        *bool      `json:"ghost,omitempty"`
+		GhostUntil   *time.Time `json:"ghost_until,omitempty"`
+	}
+	if err := c.BindBody(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	if req.Visibility != nil && !contains(locationVisibilities, *req.Visibility) {
+		return c.JSON(400, map[string]string{"error": "visibility must be everyone, followers, selected or nobody"})
+	}
+	if req.Precision != nil {
+		if _, ok := locationPrecisionMeters[*req.Precision]; !ok {
+			return c.JSON(400, map[string]string{"error": "precision must be exact, 100m, 1km or city"})
+		}
+	}
+
+	settings, err := c.App.FindFirstRecordByFilter("locationPrivacy", "user = {:user}", dbx.Params{"user": userID})
+	if err != nil {
+		collection, err := c.App.FindCollectionByNameOrId("locationPrivacy")
+		if err != nil {
+			return c.JSON(500, map[string]string{"error": err.Error()})
+		}
+		settings = core.NewRecord(collection)
+		settings.Set("user", userID)
+		settings.Set("visibility", DefaultLocationPrivacy.Visibility)
+		settings.Set("precision", DefaultLocationPrivacy.Precision)
+	}
+
+	if req.Visibility != nil {
+		settings.Set("visibility", *req.Visibility)
+	}
+	if req.Precision != nil {
+		settings.Set("precision", *req.Precision)
+	}
+	if req.AllowedUsers != nil {
+		settings.Set("allowedUsers", req.AllowedUsers)
+	}
+	if req.Ghost != nil {
+		settings.Set("ghost", *req.Ghost)
+		// A new ghost mode has no end unless given
+		settings.Set("ghostUntil", "")
+	}
+	if req.GhostUntil != nil {
+		settings.Set("ghostUntil", *req.GhostUntil)
+	}
+
+	// The record hooks invalidate the cache
+	if err := c.App.Save(settings); err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(200, locationPrivacyFromRecord(settings))
+}
+
+// ==================== SETUP COLLECTIONS ====================
+
+func SetupLocationPrivacyCollection(app core.App) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		privacy := core.NewBaseCollection("locationPrivacy")
+		privacy.Fields.Add(
+			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1, CascadeDelete: true},
+			&core.SelectField{Name: "visibility", Values: locationVisibilities, MaxSelect: 1},
+			&core.SelectField{Name: "precision", Values: []string{"exact", "100m", "1km", "city"}, MaxSelect: 1},
+			&core.RelationField{Name: "allowedUsers", CollectionId: "_pb_users_auth_", MaxSelect: 1000},
+			&core.BoolField{Name: "ghost"},
+			&core.DateField{Name: "ghostUntil"},
+		)
+		privacy.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_location_privacy_user ON locationPrivacy (user)",
+		}
+		privacy.ListRule = types.Pointer("user = @request.auth.id")
+		privacy.ViewRule = types.Pointer("user = @request.auth.id")
+
+		return txApp.Save(privacy)
+	})
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLocationPrivacy(t *testing.T) core.App {
+	t.Helper()
+
+	app := newTestApp(t)
+	require.NoError(t, SetupLocationPrivacyCollection(app))
+	require.NoError(t, SetupFollowAndRoomCollections(app))
+
+	saved := locationPrivacy
+	locationPrivacy = NewLocationPrivacyStore(app)
+	t.Cleanup(func() { locationPrivacy = saved })
+
+	return app
+}
+
+func TestFuzzLocation(t *testing.T) {
+	location := Location{
+		Point:     Point{Lat: 48.85661, Lng: 2.35222},
+		Accuracy:  12,
+		Speed:     1.5,
+		Heading:   90,
+		Timestamp: time.Now(),
+	}
+	assert.Equal(t, location, fuzzLocation(location, "exact"))
+
+	for _, precision := range []string{"100m", "1km", "city"} {
+		step := locationPrecisionMeters[precision]
+		fuzzed := fuzzLocation(location, precision)
+
+		// Center of the cell: within half a diagonal, stable when fuzzed again
+		assert.LessOrEqual(t, HaversineDistance(location.Point, fuzzed.Point), step*math.Sqrt2/2, precision)
+		assert.Equal(t, fuzzed, fuzzLocation(fuzzed, precision), precision)
+		assert.Equal(t, step, fuzzed.Accuracy, precision)
+		assert.Zero(t, fuzzed.Speed, precision)
+		assert.Zero(t, fuzzed.Heading, precision)
+		assert.True(t, fuzzed.Timestamp.Equal(location.Timestamp), precision)
+	}
+
+	// Same cell, same position: repeated queries can't be averaged
+	moved := location
+	moved.Point.Lat += 0.00001
+	assert.Equal(t, fuzzLocation(location, "1km").Point, fuzzLocation(moved, "1km").Point)
+
+	// A worse accuracy is kept
+	location.Accuracy = 5000
+	assert.Equal(t, 5000.0, fuzzLocation(location, "1km").Accuracy)
+}
+
+func TestLocationViewerView(t *testing.T) {
+	app := newTestLocationPrivacy(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+	carol := createTestUser(t, app, "carol@example.com")
+	createFollow(t, app, bob, alice, "active")
+	createFollow(t, app, carol, alice, "pending")
+
+	aliceLoc := &UserLocation{
+		UserID:   alice,
+		Location: Location{Point: Point{Lat: 48.85661, Lng: 2.35222}, Speed: 1.5},
+		Presence: "online",
+	}
+	visible := func(viewerID string) bool {
+		_, ok := locationPrivacy.Viewer(viewerID, false).View(aliceLoc)
+		return ok
+	}
+
+	// Defaults: the active followers only
+	assert.True(t, visible(bob))
+	assert.False(t, visible(carol), "pending follow")
+	assert.False(t, visible(""), "script without viewer")
+
+	setTestLocationPrivacy(t, app, alice, map[string]any{"visibility": "everyone"})
+	assert.True(t, visible(carol))
+	assert.True(t, visible(""))
+
+	setTestLocationPrivacy(t, app, alice, map[string]any{"visibility": "selected", "allowedUsers": []string{carol}})
+	assert.True(t, visible(carol))
+	assert.False(t, visible(bob))
+	assert.False(t, visible(""))
+
+	setTestLocationPrivacy(t, app, alice, map[string]any{"visibility": "nobody"})
+	assert.False(t, visible(bob))
+	assert.False(t, visible(carol))
+
+	// The user and the superusers see the exact position
+	setTestLocationPrivacy(t, app, alice, map[string]any{"visibility": "everyone", "precision": "1km", "ghost": true})
+	seen, ok := locationPrivacy.Viewer(alice, false).View(aliceLoc)
+	require.True(t, ok)
+	assert.Equal(t, aliceLoc, seen)
+	seen, ok = locationPrivacy.Viewer("", true).View(aliceLoc)
+	require.True(t, ok)
+	assert.Equal(t, aliceLoc, seen)
+	assert.False(t, visible(bob), "ghost")
+
+	// Ghost until a date, visible again after it
+	setTestLocationPrivacy(t, app, alice, map[string]any{"ghostUntil": time.Now().Add(time.Hour)})
+	assert.False(t, visible(bob))
+	setTestLocationPrivacy(t, app, alice, map[string]any{"ghostUntil": time.Now().Add(-time.Minute)})
+	assert.True(t, visible(bob))
+
+	// Reduced precision, the stored position unchanged
+	seen, ok = locationPrivacy.Viewer(bob, false).View(aliceLoc)
+	require.True(t, ok)
+	assert.Equal(t, fuzzLocation(aliceLoc.Location, "1km"), seen.Location)
+	assert.Equal(t, alice, seen.UserID)
+	assert.Equal(t, "online", seen.Presence)
+	assert.Equal(t, 1.5, aliceLoc.Location.Speed)
+
+	users := locationPrivacy.Viewer(carol, false).ViewAll([]*UserLocation{aliceLoc, {UserID: bob}})
+	require.Len(t, users, 1, "bob's position follows the defaults")
+	assert.Equal(t, alice, users[0].UserID)
+}
@@ -0,0 +1,280 @@
+package app
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// ==================== NOTIFICATION INBOX ====================
+
+// Notifications are stored in the notifications collection, then sent to the
+// user's sessions. When the user is offline they are sent with Web Push, and
+// stay undelivered until a SSE, WebSocket or DataChannel session connects.
+
+const maxPendingNotifications = 100
+
+// Store a notification and send it to the user's sessions.
+// SSE/WebSocket get eventType, the user rooms a "notification" message.
+func (ucm *UserChannelManager) Notify(userID, eventType string, data map[string]interface{}) {
+	if userID == "" {
+		return
+	}
+
+	online := ucm.hasSessions(userID)
+
+	collection, err := ucm.app.FindCollectionByNameOrId("notifications")
+	if err != nil {
+		// Inbox not set up, live delivery only
+		if !online {
+			go webPush.Notify(userID, eventType, "", data)
+			return
+		}
+		ucm.SendToSSE(userID, eventType, data, "")
+		ucm.SendToUserRoom(userID, "notification", map[string]interface{}{"type": eventType, "data": data}, "")
+		return
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user", userID)
+	record.Set("type", eventType)
+	record.Set("data", data)
+	record.Set("read", false)
+	record.Set("delivered", online)
+
+	// Not sent without an id: it couldn't be marked read nor replayed
+	if err := ucm.app.Save(record); err != nil {
+		log.Printf("Error saving notification for %s, not sent: %v", userID, err)
+		return
+	}
+
+	// Offline: Web Push to the user's browsers, the inbox replays it later
+	if !online {
+		go webPush.Notify(userID, eventType, record.Id, data)
+		return
+	}
+
+	ucm.sendNotification(userID, "", record)
+	ucm.sendUnreadCount(userID, "")
+}
+
+func (ucm *UserChannelManager) sendNotification(userID, sessionID string, record *core.Record) {
+	eventType := record.GetString("type")
+
+	data := map[string]interface{}{}
+	record.UnmarshalJSONField("data", &data)
+	data["notification_id"] = record.Id
+
+	ucm.sendToSessions(userID, sessionID, eventType, data, "")
+	ucm.sendToUserRooms(userID, sessionID, "notification", map[string]interface{}{
+		"notification_id": record.Id,
+		"type":            eventType,
+		"data":            data,
+	}, "")
+}
+
+// Check if the user has at least one connected session
+func (ucm *UserChannelManager) hasSessions(userID string) bool {
+	ucm.mu.RLock()
+	defer ucm.mu.RUnlock()
+
+	for _, channel := range ucm.sseChannels[userID] {
+		if channel.active() {
+			return true
+		}
+	}
+	if len(ucm.wsClients[userID]) > 0 {
+		return true
+	}
+	for _, room := range ucm.userRooms[userID] {
+		room.mu.RLock()
+		connected := room.IsConnected
+		room.mu.RUnlock()
+		if connected {
+			return true
+		}
+	}
+	return false
+}
+
+// Send the notifications stored while the user was offline to a new session
+func (ucm *UserChannelManager) deliverPendingNotifications(userID, sessionID string) {
+	records, err := ucm.app.FindRecordsByFilter(
+		"notifications",
+		"user = {:user} && delivered = false",
+		"created",
+		maxPendingNotifications,
+		0,
+		dbx.Params{"user": userID},
+	)
+	if err != nil {
+		return // collection not set up
+	}
+
+	for _, record := range records {
+		ucm.sendNotification(userID, sessionID, record)
+
+		record.Set("delivered", true)
+		if err := ucm.app.Save(record); err != nil {
+			log.Printf("Error updating notification %s: %v", record.Id, err)
+		}
+	}
+
+	if len(records) > 0 {
+		log.Printf("📬 %d pending notifications delivered to %s (session %s)", len(records), userID, sessionID)
+	}
+
+	ucm.sendUnreadCount(userID, sessionID)
+}
+
+// Number of unread notifications
+func (ucm *UserChannelManager) UnreadCount(userID string) int {
+	count, err := ucm.app.CountRecords("notifications", dbx.HashExp{"user": userID, "read": false})
+	if err != nil {
+		return 0
+	}
+	return int(count)
+}
+
+// Badge event, to one session or to all of them
+func (ucm *UserChannelManager) sendUnreadCount(userID, sessionID string) {
+	data := map[string]interface{}{"count": ucm.UnreadCount(userID)}
+
+	ucm.sendToSessions(userID, sessionID, "unread_count", data, "")
+	ucm.sendToUserRooms(userID, sessionID, "unread_count", data, "")
+}
+
+func notificationToMap(record *core.Record) map[string]interface{} {
+	data := map[string]interface{}{}
+	record.UnmarshalJSONField("data", &data)
+
+	return map[string]interface{}{
+		"id":      record.Id,
+		"type":    record.GetString("type"),
+		"data":    data,
+		"read":    record.GetBool("read"),
+		"readAt":  record.GetDateTime("readAt"),
+		"created": record.GetDateTime("created"),
+	}
+}
+
+// ==================== HTTP HANDLERS ====================
+
+// List notifications, newest first (?unread=true, ?page=, ?perPage=)
+func handleListNotifications(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+	query := c.Request.URL.Query()
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(query.Get("perPage"))
+	if perPage < 1 || perPage > 100 {
+		perPage = 30
+	}
+
+	filter := "user = {:user}"
+	if query.Get("unread") == "true" {
+		filter += " && read = false"
+	}
+
+	records, err := c.App.FindRecordsByFilter("notifications", filter, "-created", perPage, (page-1)*perPage, dbx.Params{"user": userID})
+	if err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	items := make([]map[string]interface{}, len(records))
+	for i, record := range records {
+		items[i] = notificationToMap(record)
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"items":   items,
+		"page":    page,
+		"perPage": perPage,
+		"unread":  userChannelManager.UnreadCount(userID),
+	})
+}
+
+func handleUnreadCount(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	return c.JSON(200, map[string]interface{}{
+		"count": userChannelManager.UnreadCount(userID),
+	})
+}
+
+func handleMarkNotificationRead(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+	notificationID := c.Request.PathValue("notificationId")
+
+	record, err := c.App.FindRecordById("notifications", notificationID)
+	if err != nil || record.GetString("user") != userID {
+		return c.JSON(404, map[string]string{"error": "notification not found"})
+	}
+
+	if !record.GetBool("read") {
+		record.Set("read", true)
+		record.Set("readAt", time.Now())
+		if err := c.App.Save(record); err != nil {
+			return c.JSON(500, map[string]string{"error": err.Error()})
+		}
+		userChannelManager.sendUnreadCount(userID, "")
+	}
+
+	return c.JSON(200, notificationToMap(record))
+}
+
+func handleMarkAllNotificationsRead(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	now := types.NowDateTime()
+	result, err := c.App.DB().Update("notifications",
+		dbx.Params{"read": true, "readAt": now.String()},
+		dbx.HashExp{"user": userID, "read": false},
+	).Execute()
+	if err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	updated, _ := result.RowsAffected()
+	if updated > 0 {
+		userChannelManager.sendUnreadCount(userID, "")
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"success": true,
+		"updated": updated,
+	})
+}
+
+// ==================== SETUP COLLECTIONS ====================
+
+func SetupNotificationsCollection(app core.App) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		notifications := core.NewBaseCollection("notifications")
+		notifications.Fields.Add(
+			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1, CascadeDelete: true},
+			&core.TextField{Name: "type", Required: true},
+			&core.JSONField{Name: "data"},
+			&core.BoolField{Name: "read"},
+			&core.DateField{Name: "readAt"},
+			&core.BoolField{Name: "delivered"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+		)
+		notifications.Indexes = []string{
+			"CREATE INDEX idx_notifications_user ON notifications (user, read, created)",
+			"CREATE INDEX idx_notifications_pending ON notifications (user, delivered)",
+		}
+		notifications.ListRule = types.Pointer("user = @request.auth.id")
+		notifications.ViewRule = types.Pointer("user = @request.auth.id")
+		notifications.DeleteRule = types.Pointer("user = @request.auth.id")
+
+		return txApp.Save(notifications)
+	})
+}
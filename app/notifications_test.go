@@ -0,0 +1,201 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestNotifications(t *testing.T) core.App {
+	t.Helper()
+
+	app := newTestApp(t)
+	require.NoError(t, SetupNotificationsCollection(app))
+
+	saved := userChannelManager
+	userChannelManager = NewUserChannelManager(app)
+	t.Cleanup(func() { userChannelManager = saved })
+
+	return app
+}
+
+func receiveSSE(t *testing.T, channel *SSEChannel) SSEMessage {
+	t.Helper()
+
+	select {
+	case msg, ok := <-channel.Channel:
+		require.True(t, ok, "channel closed")
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("no message for session %s", channel.SessionID)
+	}
+	return SSEMessage{}
+}
+
+func assertNoSSE(t *testing.T, channel *SSEChannel) {
+	t.Helper()
+
+	select {
+	case msg := <-channel.Channel:
+		t.Fatalf("unexpected %q message for session %s", msg.Type, channel.SessionID)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestNotifyOfflineThenReplay(t *testing.T) {
+	app := newTestNotifications(t)
+	alice := createTestUser(t, app, "alice@example.com")
+
+	userChannelManager.Notify(alice, "comment", map[string]interface{}{"post_id": "p1"})
+
+	records, err := app.FindAllRecords("notifications")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.False(t, records[0].GetBool("delivered"))
+	assert.Equal(t, 1, userChannelManager.UnreadCount(alice))
+
+	channel := userChannelManager.OpenSSESession(alice, "s1", "")
+	userChannelManager.deliverPendingNotifications(alice, "s1")
+
+	msg := receiveSSE(t, channel)
+	assert.Equal(t, "comment", msg.Type)
+	assert.Equal(t, "p1", msg.Data["post_id"])
+	assert.Equal(t, records[0].Id, msg.Data["notification_id"])
+
+	unread := receiveSSE(t, channel)
+	assert.Equal(t, "unread_count", unread.Type)
+	assert.Equal(t, 1, unread.Data["count"])
+
+	record, err := app.FindRecordById("notifications", records[0].Id)
+	require.NoError(t, err)
+	assert.True(t, record.GetBool("delivered"))
+
+	// Delivered once
+	userChannelManager.deliverPendingNotifications(alice, "s1")
+	assert.Equal(t, "unread_count", receiveSSE(t, channel).Type)
+	assertNoSSE(t, channel)
+}
+
+func TestNotifyOnline(t *testing.T) {
+	app := newTestNotifications(t)
+	alice := createTestUser(t, app, "alice@example.com")
+
+	channel := userChannelManager.OpenSSESession(alice, "s1", "")
+	userChannelManager.Notify(alice, "follow", map[string]interface{}{"from": "bob"})
+
+	msg := receiveSSE(t, channel)
+	assert.Equal(t, "follow", msg.Type)
+	assert.Equal(t, "bob", msg.Data["from"])
+	assert.Equal(t, 1, receiveSSE(t, channel).Data["count"])
+
+	record, err := app.FindRecordById("notifications", msg.Data["notification_id"].(string))
+	require.NoError(t, err)
+	assert.True(t, record.GetBool("delivered"))
+	assert.False(t, record.GetBool("read"))
+}
+
+func TestNotifySaveError(t *testing.T) {
+	app := newTestNotifications(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	app.OnRecordCreate("notifications").BindFunc(func(e *core.RecordEvent) error {
+		return errors.New("disk full")
+	})
+
+	channel := userChannelManager.OpenSSESession(alice, "s1", "")
+	userChannelManager.Notify(alice, "follow", map[string]interface{}{"from": "bob"})
+	assertNoSSE(t, channel)
+}
+
+func TestNotificationHandlers(t *testing.T) {
+	app := newTestNotifications(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	for _, kind := range []string{"a", "b", "c"} {
+		userChannelManager.Notify(alice, kind, nil)
+	}
+	userChannelManager.Notify(bob, "x", nil)
+
+	r, err := apis.NewRouter(app)
+	require.NoError(t, err)
+	as := func(handler func(*core.RequestEvent) error) func(*core.RequestEvent) error {
+		return func(e *core.RequestEvent) error {
+			e.Set("userID", e.Request.Header.Get("X-User"))
+			return handler(e)
+		}
+	}
+	r.GET("/api/notifications", as(handleListNotifications))
+	r.GET("/api/notifications/unread-count", as(handleUnreadCount))
+	r.POST("/api/notifications/read-all", as(handleMarkAllNotificationsRead))
+	r.POST("/api/notifications/{notificationId}/read", as(handleMarkNotificationRead))
+	mux, err := r.BuildMux()
+	require.NoError(t, err)
+
+	call := func(userID, method, path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-User", userID)
+		mux.ServeHTTP(rec, req)
+
+		body := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body), rec.Body.String())
+		return rec.Code, body
+	}
+
+	status, list := call(alice, http.MethodGet, "/api/notifications")
+	require.Equal(t, 200, status)
+	items := list["items"].([]interface{})
+	require.Len(t, items, 3)
+	assert.Equal(t, 3.0, list["unread"])
+	first := items[0].(map[string]interface{})["id"].(string)
+
+	// Someone else's notification
+	status, _ = call(bob, http.MethodPost, "/api/notifications/"+first+"/read")
+	assert.Equal(t, 404, status)
+
+	status, read := call(alice, http.MethodPost, "/api/notifications/"+first+"/read")
+	require.Equal(t, 200, status)
+	assert.Equal(t, true, read["read"])
+
+	_, count := call(alice, http.MethodGet, "/api/notifications/unread-count")
+	assert.Equal(t, 2.0, count["count"])
+	_, unread := call(alice, http.MethodGet, "/api/notifications?unread=true")
+	assert.Len(t, unread["items"], 2)
+
+	_, all := call(alice, http.MethodPost, "/api/notifications/read-all")
+	assert.Equal(t, 2.0, all["updated"])
+	assert.Equal(t, 0, userChannelManager.UnreadCount(alice))
+	assert.Equal(t, 1, userChannelManager.UnreadCount(bob))
+}
+
+func TestHasSessionsWhileClosing(t *testing.T) {
+	app := newTestApp(t)
+	ucm := NewUserChannelManager(app)
+
+	slow := ucm.OpenSSESession("alice", "s1", "", SubscribeOptions{BufferSize: 1, Policy: PolicyDisconnect})
+	assert.True(t, ucm.hasSessions("alice"))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			slow.send(SSEMessage{Type: "ping"})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		ucm.hasSessions("alice")
+	}
+	wg.Wait()
+
+	assert.False(t, ucm.hasSessions("alice"))
+}
@@ -0,0 +1,386 @@
+package app
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ==================== PRESENCE ====================
+
+// Presence follows the user's connections (SSE, WebSocket, user rooms):
+//   - online when a session connects or sends a heartbeat
+//   - away after AwayAfter without heartbeat, connections still open
+//   - offline OfflineGrace after the last connection dropped
+//
+// Heartbeats are the frames received from the client (WebSocket and
+// DataChannel messages, "ping" included) and POST /api/presence/heartbeat
+// for SSE and HTTP-only clients. "busy", "away" and "offline" (invisible)
+// set by the user stay until changed or until the user goes offline.
+
+type PresenceOptions struct {
+	AwayAfter    time.Duration
+	OfflineGrace time.Duration // reconnections within this delay don't flap
+}
+
+// Defaults, overridden by the --presenceAwayAfter and --presenceOfflineGrace flags
+var DefaultPresenceOptions = PresenceOptions{
+	AwayAfter:    5 * time.Minute,
+	OfflineGrace: 15 * time.Second,
+}
+
+type userPresence struct {
+	presence     string // online, away, busy, offline
+	manual       string // busy, away or offline chosen by the user
+	lastActivity time.Time
+	offlineTimer *time.Timer
+}
+
+// PresenceTracker - Présence calculée à partir des connexions et heartbeats
+type PresenceTracker struct {
+	users   map[string]*userPresence
+	options PresenceOptions
+	pending sync.WaitGroup // offline timers and follower notifications
+	app     core.App
+	mu      sync.Mutex
+}
+
+func NewPresenceTracker(app core.App, options PresenceOptions) *PresenceTracker {
+	if options.AwayAfter <= 0 {
+		options.AwayAfter = DefaultPresenceOptions.AwayAfter
+	}
+	if options.OfflineGrace < 0 {
+		options.OfflineGrace = 0
+	}
+
+	return &PresenceTracker{
+		users:   make(map[string]*userPresence),
+		options: options,
+		app:     app,
+	}
+}
+
+func validPresence(presence string) bool {
+	switch presence {
+	case "online", "away", "busy", "offline":
+		return true
+	}
+	return false
+}
+
+// Nobody is connected to a starting server: the presences saved before a
+// crash or a restart would stay online forever
+func (pt *PresenceTracker) ResetPersisted() error {
+	result, err := pt.app.DB().Update("users",
+		dbx.Params{"presence": "offline"},
+		dbx.Not(dbx.HashExp{"presence": "offline"}),
+	).Execute()
+	if err != nil {
+		return err
+	}
+
+	if reset, _ := result.RowsAffected(); reset > 0 {
+		log.Printf("⚪ %d users marked offline", reset)
+	}
+	return nil
+}
+
+func (pt *PresenceTracker) userLocked(userID string) *userPresence {
+	user, exists := pt.users[userID]
+	if !exists {
+		user = &userPresence{presence: "offline"}
+		pt.users[userID] = user
+	}
+	return user
+}
+
+// Activity of the user, returns the resulting presence
+func (pt *PresenceTracker) Heartbeat(userID string) string {
+	if pt == nil || userID == "" {
+		return "online"
+	}
+
+	pt.mu.Lock()
+	user := pt.userLocked(userID)
+	user.lastActivity = time.Now()
+	pt.stopOfflineTimerLocked(user)
+
+	presence := "online"
+	if user.manual != "" {
+		presence = user.manual
+	}
+	previous := pt.setLocked(user, presence)
+	pt.mu.Unlock()
+
+	if previous != presence {
+		pt.changed(userID, previous, presence)
+	}
+	return presence
+}
+
+// A session connected
+func (pt *PresenceTracker) Connected(userID string) {
+	pt.Heartbeat(userID)
+}
+
+// A session closed: offline after the grace delay if it was the last one
+func (pt *PresenceTracker) Disconnected(userID string) {
+	if pt == nil || userID == "" || userChannelManager.hasSessions(userID) {
+		return
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	user := pt.userLocked(userID)
+	pt.stopOfflineTimerLocked(user)
+	pt.pending.Add(1)
+	user.offlineTimer = time.AfterFunc(pt.options.OfflineGrace, func() {
+		defer pt.pending.Done()
+		pt.goOffline(userID)
+	})
+}
+
+func (pt *PresenceTracker) stopOfflineTimerLocked(user *userPresence) {
+	if user.offlineTimer != nil && user.offlineTimer.Stop() {
+		pt.pending.Done()
+	}
+	user.offlineTimer = nil
+}
+
+func (pt *PresenceTracker) goOffline(userID string) {
+	if userChannelManager.hasSessions(userID) {
+		return // reconnected
+	}
+
+	pt.mu.Lock()
+	user := pt.userLocked(userID)
+	user.offlineTimer = nil
+	user.manual = ""
+	previous := pt.setLocked(user, "offline")
+	pt.mu.Unlock()
+
+	if previous != "offline" {
+		pt.changed(userID, previous, "offline")
+	}
+}
+
+// Wait for the offline timers and the follower notifications in progress
+func (pt *PresenceTracker) Wait() {
+	pt.pending.Wait()
+}
+
+// Presence chosen by the user, "online" goes back to automatic
+func (pt *PresenceTracker) SetManual(userID, presence string) {
+	if pt == nil {
+		return
+	}
+
+	pt.mu.Lock()
+	user := pt.userLocked(userID)
+	user.lastActivity = time.Now()
+	user.manual = ""
+	if presence != "online" {
+		user.manual = presence
+	}
+	previous := pt.setLocked(user, presence)
+	pt.mu.Unlock()
+
+	if previous != presence {
+		pt.changed(userID, previous, presence)
+	}
+}
+
+// Current presence of a user, offline when unknown
+func (pt *PresenceTracker) Get(userID string) string {
+	if pt == nil {
+		return "offline"
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if user, exists := pt.users[userID]; exists {
+		return user.presence
+	}
+	return "offline"
+}
+
+func (pt *PresenceTracker) setLocked(user *userPresence, presence string) string {
+	previous := user.presence
+	user.presence = presence
+	return previous
+}
+
+// Move inactive users to away, users without connection nor heartbeat
+// to offline, and forget the offline ones
+func (pt *PresenceTracker) Sweep() {
+	now := time.Now()
+	changes := map[string][2]string{}
+
+	pt.mu.Lock()
+	for userID, user := range pt.users {
+		inactive := now.Sub(user.lastActivity) > pt.options.AwayAfter
+
+		switch {
+		case user.presence == "offline" && user.manual == "" && user.offlineTimer == nil:
+			delete(pt.users, userID)
+		case user.presence != "offline" && inactive && user.offlineTimer == nil && !userChannelManager.hasSessions(userID):
+			changes[userID] = [2]string{user.presence, "offline"}
+			user.presence = "offline"
+			user.manual = ""
+		case inactive && user.presence == "online":
+			changes[userID] = [2]string{user.presence, "away"}
+			user.presence = "away"
+		}
+	}
+	pt.mu.Unlock()
+
+	for userID, change := range changes {
+		pt.changed(userID, change[0], change[1])
+	}
+}
+
+// Save the presence on the user and send presence_changed
+func (pt *PresenceTracker) changed(userID, previous, presence string) {
+	now := time.Now()
+
+	user, err := pt.app.FindRecordById("users", userID)
+	if err == nil {
+		user.Set("presence", presence)
+		user.Set("lastSeen", now)
+		if err := pt.app.Save(user); err != nil {
+			log.Printf("Error saving presence of %s: %v", userID, err)
+		}
+	}
+
+	if locationManager != nil {
+		locationManager.SetPresence(userID, presence)
+	}
+
+	data := map[string]interface{}{
+		"user_id":   userID,
+		"presence":  presence,
+		"previous":  previous,
+		"last_seen": now.Format(time.RFC3339),
+	}
+
+	// The user's other devices, and the followers
+	if userChannelManager != nil {
+		userChannelManager.SendToSSE(userID, "presence_changed", data, "")
+		userChannelManager.SendToUserRoom(userID, "presence_changed", data, "")
+		pt.pending.Add(1)
+		go func() {
+			defer pt.pending.Done()
+			pt.notifyFollowers(userID, data)
+		}()
+	}
+
+	pubsub.Publish(UserTopic(userID, "presence"), PubSubMessage{
+		Topic:   UserTopic(userID, "presence"),
+		Payload: data,
+	})
+
+	log.Printf("🟢 Presence of %s: %s -> %s", userID, previous, presence)
+}
+
+// Follows read per query, and at most for one presence change or listing
+const (
+	presenceFollowsPageSize = 500
+	maxPresenceFollows      = 5000
+)
+
+// Active follows where column ("follower" or "following") is userID, page by
+// page. Returns false when stopped at maxPresenceFollows.
+func forEachActiveFollow(app core.App, column, userID string, fn func(follow *core.Record)) (bool, error) {
+	filter := column + " = {:user} && status = 'active'"
+	for offset := 0; offset < maxPresenceFollows; offset += presenceFollowsPageSize {
+		page, err := app.FindRecordsByFilter("follows", filter, "id", presenceFollowsPageSize, offset, dbx.Params{"user": userID})
+		if err != nil {
+			return false, err
+		}
+		for _, follow := range page {
+			fn(follow)
+		}
+
+		if len(page) < presenceFollowsPageSize {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Send presence_changed to the connected users following userID (active follows)
+func (pt *PresenceTracker) notifyFollowers(userID string, data map[string]interface{}) {
+	complete, err := forEachActiveFollow(pt.app, "following", userID, func(follow *core.Record) {
+		followerID := follow.GetString("follower")
+		if !userChannelManager.hasSessions(followerID) {
+			return
+		}
+
+		userChannelManager.SendToSSE(followerID, "presence_changed", data, "")
+		userChannelManager.SendToUserRoom(followerID, "presence_changed", data, "")
+	})
+	if err != nil {
+		log.Printf("⚠️ Presence of %s not sent to the followers: %v", userID, err)
+	} else if !complete {
+		log.Printf("⚠️ Presence of %s sent to the first %d followers only", userID, maxPresenceFollows)
+	}
+}
+
+// ==================== HTTP HANDLERS ====================
+
+// Heartbeat of the clients that only listen (SSE)
+func handlePresenceHeartbeat(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	return c.JSON(200, map[string]interface{}{
+		"presence": presenceTracker.Heartbeat(userID),
+	})
+}
+
+// Users followed by the current user that are online, away or busy
+func handleGetOnlineFollowing(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	// Live presence from the tracker, users.presence is only the copy saved
+	// at each change
+	presences := map[string]string{}
+	followingIDs := []string{}
+	_, err := forEachActiveFollow(c.App, "follower", userID, func(follow *core.Record) {
+		followingID := follow.GetString("following")
+		if presence := presenceTracker.Get(followingID); presence != "offline" {
+			presences[followingID] = presence
+			followingIDs = append(followingIDs, followingID)
+		}
+	})
+	if err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	online := []map[string]interface{}{}
+	if len(followingIDs) > 0 {
+		users, err := c.App.FindRecordsByIds("users", followingIDs)
+		if err != nil {
+			return c.JSON(500, map[string]string{"error": err.Error()})
+		}
+
+		for _, user := range users {
+			online = append(online, map[string]interface{}{
+				"user_id":   user.Id,
+				"name":      user.GetString("name"),
+				"avatar":    user.GetString("avatar"),
+				"presence":  presences[user.Id],
+				"last_seen": user.GetDateTime("lastSeen"),
+			})
+		}
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"users": online,
+		"count": len(online),
+	})
+}
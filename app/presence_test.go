@@ -0,0 +1,259 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPresence(t *testing.T, options PresenceOptions) (core.App, *PresenceTracker) {
+	t.Helper()
+
+	app := newTestApp(t)
+	require.NoError(t, SetupLocationCollections(app))
+
+	savedPubSub, savedChannels, savedPresence := pubsub, userChannelManager, presenceTracker
+	pubsub = NewMemoryPubSub()
+	userChannelManager = NewUserChannelManager(app)
+	presenceTracker = NewPresenceTracker(app, options)
+	t.Cleanup(func() {
+		presenceTracker.Wait()
+		pubsub, userChannelManager, presenceTracker = savedPubSub, savedChannels, savedPresence
+	})
+
+	return app, presenceTracker
+}
+
+func persistedPresence(t *testing.T, app core.App, userID string) string {
+	t.Helper()
+
+	user, err := app.FindRecordById("users", userID)
+	require.NoError(t, err)
+	return user.GetString("presence")
+}
+
+func TestPresenceResetPersisted(t *testing.T) {
+	app, pt := newTestPresence(t, PresenceOptions{})
+
+	users := map[string]string{}
+	for _, presence := range []string{"online", "away", "busy", "offline"} {
+		userID := createTestUser(t, app, presence+"@example.com")
+		user, err := app.FindRecordById("users", userID)
+		require.NoError(t, err)
+		user.Set("presence", presence)
+		require.NoError(t, app.Save(user))
+		users[userID] = presence
+	}
+
+	require.NoError(t, pt.ResetPersisted())
+	for userID := range users {
+		assert.Equal(t, "offline", persistedPresence(t, app, userID))
+	}
+}
+
+func TestPresenceAway(t *testing.T) {
+	app, pt := newTestPresence(t, PresenceOptions{AwayAfter: 300 * time.Millisecond, OfflineGrace: time.Hour})
+	alice := createTestUser(t, app, "alice@example.com")
+	userChannelManager.OpenSSESession(alice, "s1", "")
+
+	assert.Equal(t, "online", pt.Heartbeat(alice))
+	assert.Equal(t, "online", persistedPresence(t, app, alice))
+
+	pt.Sweep()
+	assert.Equal(t, "online", pt.Get(alice), "still active")
+
+	time.Sleep(350 * time.Millisecond)
+	pt.Sweep()
+	assert.Equal(t, "away", pt.Get(alice))
+	assert.Equal(t, "away", persistedPresence(t, app, alice))
+
+	// Back with the next heartbeat
+	assert.Equal(t, "online", pt.Heartbeat(alice))
+}
+
+func TestPresenceOfflineGrace(t *testing.T) {
+	app, pt := newTestPresence(t, PresenceOptions{OfflineGrace: 200 * time.Millisecond})
+	alice := createTestUser(t, app, "alice@example.com")
+
+	channel := userChannelManager.OpenSSESession(alice, "s1", "")
+	pt.Connected(alice)
+	require.Equal(t, "online", pt.Get(alice))
+
+	// Reconnected within the grace delay: no flap
+	userChannelManager.closeSSEChannel(channel)
+	pt.Disconnected(alice)
+	channel = userChannelManager.OpenSSESession(alice, "s2", "")
+	pt.Connected(alice)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, "online", pt.Get(alice))
+
+	// Still connected elsewhere
+	other := userChannelManager.OpenSSESession(alice, "s3", "")
+	userChannelManager.closeSSEChannel(channel)
+	pt.Disconnected(alice)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, "online", pt.Get(alice))
+
+	userChannelManager.closeSSEChannel(other)
+	pt.Disconnected(alice)
+	assert.Equal(t, "online", pt.Get(alice), "within the grace delay")
+	assert.Eventually(t, func() bool {
+		return pt.Get(alice) == "offline" && persistedPresence(t, app, alice) == "offline"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestPresenceManual(t *testing.T) {
+	app, pt := newTestPresence(t, PresenceOptions{AwayAfter: 300 * time.Millisecond, OfflineGrace: time.Hour})
+	alice := createTestUser(t, app, "alice@example.com")
+	userChannelManager.OpenSSESession(alice, "s1", "")
+
+	pt.SetManual(alice, "busy")
+	assert.Equal(t, "busy", pt.Heartbeat(alice), "kept by heartbeats")
+
+	time.Sleep(350 * time.Millisecond)
+	pt.Sweep()
+	assert.Equal(t, "busy", pt.Get(alice), "not moved to away")
+
+	pt.SetManual(alice, "online")
+	assert.Equal(t, "online", pt.Heartbeat(alice))
+}
+
+func TestPresenceSweepOffline(t *testing.T) {
+	app, pt := newTestPresence(t, PresenceOptions{AwayAfter: 300 * time.Millisecond, OfflineGrace: time.Hour})
+	alice := createTestUser(t, app, "alice@example.com")
+
+	// HTTP-only client: heartbeats without connection
+	pt.Heartbeat(alice)
+	time.Sleep(350 * time.Millisecond)
+	pt.Sweep()
+	assert.Equal(t, "offline", pt.Get(alice))
+	assert.Equal(t, "offline", persistedPresence(t, app, alice))
+
+	// Forgotten at the next sweep
+	pt.Sweep()
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	assert.NotContains(t, pt.users, alice)
+}
+
+func TestUpdateLocationPresence(t *testing.T) {
+	app, pt := newTestPresence(t, PresenceOptions{OfflineGrace: time.Hour})
+	alice := createTestUser(t, app, "alice@example.com")
+
+	saved := locationManager
+	locationManager = NewLocationManager(app)
+	t.Cleanup(func() {
+		locationManager.Wait()
+		locationManager = saved
+	})
+
+	r, err := apis.NewRouter(app)
+	require.NoError(t, err)
+	r.POST("/api/location/update", func(e *core.RequestEvent) error {
+		e.Set("userID", alice)
+		return handleUpdateLocation(e)
+	})
+	mux, err := r.BuildMux()
+	require.NoError(t, err)
+
+	update := func(body string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/location/update", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	location := `"location":{"latitude":48.85,"longitude":2.35}`
+	assert.Equal(t, 400, update(`{`+location+`,"presence":"sleeping"}`))
+	assert.Equal(t, "offline", pt.Get(alice), "rejected before any change")
+
+	assert.Equal(t, 200, update(`{`+location+`,"presence":"busy"}`))
+	assert.Equal(t, "busy", pt.Get(alice))
+
+	assert.Equal(t, 200, update(`{`+location+`}`))
+	assert.Equal(t, "busy", pt.Get(alice), "a heartbeat keeps the manual presence")
+}
+
+func createFollow(t *testing.T, app core.App, followerID, followingID, status string) {
+	t.Helper()
+
+	follow := core.NewRecord(mustCollection(t, app, "follows"))
+	follow.Set("follower", followerID)
+	follow.Set("following", followingID)
+	follow.Set("status", status)
+	follow.Set("role", "follower")
+	require.NoError(t, app.Save(follow))
+}
+
+func TestPresenceNotifiesFollowers(t *testing.T) {
+	app, pt := newTestPresence(t, PresenceOptions{OfflineGrace: time.Hour})
+	require.NoError(t, SetupFollowAndRoomCollections(app))
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+	carol := createTestUser(t, app, "carol@example.com")
+	createFollow(t, app, bob, alice, "active")
+	createFollow(t, app, carol, alice, "pending")
+
+	bobChannel := userChannelManager.OpenSSESession(bob, "s1", "")
+	carolChannel := userChannelManager.OpenSSESession(carol, "s1", "")
+
+	pt.SetManual(alice, "busy")
+	pt.Wait()
+
+	msg := receiveSSE(t, bobChannel)
+	assert.Equal(t, "presence_changed", msg.Type)
+	assert.Equal(t, alice, msg.Data["user_id"])
+	assert.Equal(t, "busy", msg.Data["presence"])
+	assertNoSSE(t, carolChannel)
+}
+
+func TestOnlineFollowing(t *testing.T) {
+	app, pt := newTestPresence(t, PresenceOptions{OfflineGrace: time.Hour})
+	require.NoError(t, SetupFollowAndRoomCollections(app))
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+	carol := createTestUser(t, app, "carol@example.com")
+	dave := createTestUser(t, app, "dave@example.com")
+	createFollow(t, app, alice, bob, "active")
+	createFollow(t, app, alice, carol, "active")
+	createFollow(t, app, alice, dave, "pending")
+
+	pt.SetManual(bob, "away")
+	pt.Heartbeat(dave)
+	// Saved online, not connected here
+	user, err := app.FindRecordById("users", carol)
+	require.NoError(t, err)
+	user.Set("presence", "online")
+	require.NoError(t, app.Save(user))
+
+	r, err := apis.NewRouter(app)
+	require.NoError(t, err)
+	r.GET("/api/user/online-following", func(e *core.RequestEvent) error {
+		e.Set("userID", alice)
+		return handleGetOnlineFollowing(e)
+	})
+	mux, err := r.BuildMux()
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/user/online-following", nil))
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+
+	var body struct {
+		Users []map[string]interface{} `json:"users"`
+		Count int                      `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Equal(t, 1, body.Count)
+	assert.Equal(t, bob, body.Users[0]["user_id"])
+	assert.Equal(t, "away", body.Users[0]["presence"])
+}
@@ -0,0 +1,575 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ==================== PUB/SUB TYPES ====================
+
+type PubSubMessage struct {
+	ID      uint64                 `msgpack:"id"`
+	Topic   string                 `msgpack:"topic"`
+	Payload map[string]interface{} `msgpack:"payload"`
+}
+
+// Subscription - Abonnement à un topic, à libérer avec Unsubscribe()
+type Subscription struct {
+	ID      string
+	Topic   string // exact topic or wildcard pattern
+	C       <-chan PubSubMessage
+	Options SubscribeOptions
+
+	ch      chan PubSubMessage
+	ps      *MemoryPubSub
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+	reason  string // why the subscription was closed by the server
+	closed  bool
+	mu      sync.Mutex
+}
+
+// Unsubscribe removes the subscription and closes its channel.
+// Safe to call several times.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.ps.unsubscribe(s)
+		close(s.done)
+	})
+}
+
+// Done is closed once the subscription has been removed
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Messages lost because the subscriber was too slow
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Non-empty when the server closed the subscription (ex: slow_consumer)
+func (s *Subscription) Reason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
+
+func (s *Subscription) deliver(msg PubSubMessage) {
+	if !s.Options.Filter.Match(msg) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	dropped, disconnect := offer(s.ch, msg, s.Options)
+	if dropped > 0 {
+		atomic.AddUint64(&s.dropped, uint64(dropped))
+		atomic.AddUint64(&s.ps.dropped, uint64(dropped))
+	}
+
+	if disconnect {
+		s.reason = reasonSlowConsumer
+		s.closeLocked()
+		go s.Unsubscribe()
+	}
+}
+
+func (s *Subscription) closeLocked() {
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// ==================== TOPICS ====================
+
+// Topics are dot separated (room.<id>.chat, user.<id>.notifications).
+// In a subscription pattern "*" matches exactly one token and ">" matches
+// one or more trailing tokens (ex: room.*.chat, user.abc.>).
+
+func RoomTopic(roomID, event string) string {
+	return "room." + roomID + "." + event
+}
+
+func UserTopic(userID, event string) string {
+	return "user." + userID + "." + event
+}
+
+func IsWildcardTopic(pattern string) bool {
+	for _, token := range strings.Split(pattern, ".") {
+		if token == "*" || token == ">" {
+			return true
+		}
+	}
+	return false
+}
+
+// Check a subscription pattern
+func ValidateTopicPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty topic")
+	}
+
+	tokens := strings.Split(pattern, ".")
+	for i, token := range tokens {
+		if token == "" {
+			return fmt.Errorf("invalid topic %q: empty token", pattern)
+		}
+		if token == ">" && i != len(tokens)-1 {
+			return fmt.Errorf("invalid topic %q: '>' must be the last token", pattern)
+		}
+		if token != "*" && token != ">" && strings.ContainsAny(token, "*>") {
+			return fmt.Errorf("invalid topic %q: wildcards must be whole tokens", pattern)
+		}
+	}
+	return nil
+}
+
+// Check if a topic matches a subscription pattern
+func TopicMatches(pattern, topic string) bool {
+	if pattern == topic {
+		return true
+	}
+
+	patternTokens := strings.Split(pattern, ".")
+	topicTokens := strings.Split(topic, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(topicTokens) > i
+		}
+		if i >= len(topicTokens) {
+			return false
+		}
+		if token != "*" && token != topicTokens[i] {
+			return false
+		}
+	}
+
+	return len(patternTokens) == len(topicTokens)
+}
+
+// ==================== PUB/SUB ====================
+
+// PubSub - Backend de publication/abonnement. MemoryPubSub ne livre que
+// dans le processus, BrokerPubSub relaie aussi vers les autres instances.
+type PubSub interface {
+	Subscribe(topic string, opts ...SubscribeOptions) *Subscription
+	SubscribeContext(ctx context.Context, topic string, opts ...SubscribeOptions) *Subscription
+	Publish(topic string, msg PubSubMessage)
+	Replay(pattern string, afterID uint64) []PubSubMessage
+	SubscriberCount(topic string) int
+	Topics() map[string]int
+	Subscriptions() []SubscriptionStats
+	Dropped() uint64
+	SetEventStore(store EventStore)
+}
+
+type SubscriptionStats struct {
+	ID         string             `json:"id"`
+	Topic      string             `json:"topic"`
+	Policy     BackpressurePolicy `json:"policy"`
+	BufferSize int                `json:"buffer_size"`
+	Pending    int                `json:"pending"`
+	Dropped    uint64             `json:"dropped"`
+}
+
+// MemoryPubSub - PubSub en mémoire, local au processus
+type MemoryPubSub struct {
+	subscribers map[string]map[*Subscription]struct{} // exact topic -> subs
+	wildcards   map[string]map[*Subscription]struct{} // pattern -> subs
+	history     *EventLog                             // recent messages for replay
+	store       EventStore                            // optional persistence
+	dropped     uint64                                // total dropped messages
+	mu          sync.RWMutex
+}
+
+var subscriptionCounter uint64
+
+func NewPubSub() PubSub {
+	return NewMemoryPubSub()
+}
+
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{
+		subscribers: make(map[string]map[*Subscription]struct{}),
+		wildcards:   make(map[string]map[*Subscription]struct{}),
+		history:     NewEventLog(200, time.Hour),
+	}
+}
+
+// Persist published messages so that replay survives restarts
+func (ps *MemoryPubSub) SetEventStore(store EventStore) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.store = store
+}
+
+// Subscribe to a topic or a wildcard pattern
+func (ps *MemoryPubSub) Subscribe(topic string, opts ...SubscribeOptions) *Subscription {
+	options := resolveSubscribeOptions(opts)
+
+	ch := make(chan PubSubMessage, options.BufferSize)
+	sub := &Subscription{
+		ID:      strconv.FormatUint(atomic.AddUint64(&subscriptionCounter, 1), 10),
+		Topic:   topic,
+		C:       ch,
+		Options: options,
+		ch:      ch,
+		ps:      ps,
+		done:    make(chan struct{}),
+	}
+
+	index := ps.subscribers
+	if IsWildcardTopic(topic) {
+		index = ps.wildcards
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if index[topic] == nil {
+		index[topic] = make(map[*Subscription]struct{})
+	}
+	index[topic][sub] = struct{}{}
+
+	return sub
+}
+
+// SubscribeContext subscribes until ctx is cancelled (e.g. the HTTP request ends)
+func (ps *MemoryPubSub) SubscribeContext(ctx context.Context, topic string, opts ...SubscribeOptions) *Subscription {
+	sub := ps.Subscribe(topic, opts...)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Unsubscribe()
+		case <-sub.done:
+		}
+	}()
+
+	return sub
+}
+
+func (ps *MemoryPubSub) unsubscribe(sub *Subscription) {
+	index := ps.subscribers
+	if IsWildcardTopic(sub.Topic) {
+		index = ps.wildcards
+	}
+
+	ps.mu.Lock()
+	if subs, exists := index[sub.Topic]; exists {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(index, sub.Topic)
+		}
+	}
+	ps.mu.Unlock()
+
+	// deliver holds the subscription lock while sending, so closing here is safe
+	sub.mu.Lock()
+	sub.closeLocked()
+	sub.mu.Unlock()
+}
+
+func (ps *MemoryPubSub) Publish(topic string, msg PubSubMessage) {
+	if msg.Topic == "" {
+		msg.Topic = topic
+	}
+	if msg.ID == 0 {
+		msg.ID = nextEventID()
+	}
+
+	ps.publish(topic, msg, true)
+}
+
+// Local delivery, persisted only on the node where the message was published
+func (ps *MemoryPubSub) publish(topic string, msg PubSubMessage, persist bool) {
+	ps.history.Append(msg)
+
+	ps.mu.RLock()
+	if persist && ps.store != nil {
+		ps.store.Append(msg)
+	}
+
+	targets := make([]*Subscription, 0, len(ps.subscribers[topic]))
+	for sub := range ps.subscribers[topic] {
+		targets = append(targets, sub)
+	}
+	for pattern, subs := range ps.wildcards {
+		if !TopicMatches(pattern, topic) {
+			continue
+		}
+		for sub := range subs {
+			targets = append(targets, sub)
+		}
+	}
+	ps.mu.RUnlock()
+
+	// Delivered outside the lock, the block policy may wait on a slow subscriber
+	for _, sub := range targets {
+		sub.deliver(msg)
+	}
+}
+
+// Messages published on topics matching pattern after afterID (Last-Event-ID)
+func (ps *MemoryPubSub) Replay(pattern string, afterID uint64) []PubSubMessage {
+	ps.mu.RLock()
+	store := ps.store
+	ps.mu.RUnlock()
+
+	if store != nil {
+		messages, err := store.Since(pattern, afterID, 500)
+		if err == nil {
+			return messages
+		}
+		log.Printf("Error replaying events from store: %v", err)
+	}
+
+	return ps.history.Since(pattern, afterID)
+}
+
+// Number of subscriptions receiving a topic (exact + matching patterns)
+func (ps *MemoryPubSub) SubscriberCount(topic string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	count := len(ps.subscribers[topic])
+	for pattern, subs := range ps.wildcards {
+		if TopicMatches(pattern, topic) {
+			count += len(subs)
+		}
+	}
+	return count
+}
+
+// Buffer state of every subscription
+func (ps *MemoryPubSub) Subscriptions() []SubscriptionStats {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	stats := []SubscriptionStats{}
+	for _, index := range []map[string]map[*Subscription]struct{}{ps.subscribers, ps.wildcards} {
+		for _, subs := range index {
+			for sub := range subs {
+				stats = append(stats, SubscriptionStats{
+					ID:         sub.ID,
+					Topic:      sub.Topic,
+					Policy:     sub.Options.Policy,
+					BufferSize: sub.Options.BufferSize,
+					Pending:    len(sub.ch),
+					Dropped:    sub.Dropped(),
+				})
+			}
+		}
+	}
+	return stats
+}
+
+// Total messages dropped by the backpressure policies
+func (ps *MemoryPubSub) Dropped() uint64 {
+	return atomic.LoadUint64(&ps.dropped)
+}
+
+// Active subscriptions per topic or pattern
+func (ps *MemoryPubSub) Topics() map[string]int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	topics := make(map[string]int, len(ps.subscribers)+len(ps.wildcards))
+	for topic, subs := range ps.subscribers {
+		topics[topic] = len(subs)
+	}
+	for pattern, subs := range ps.wildcards {
+		topics[pattern] = len(subs)
+	}
+	return topics
+}
+
+// ==================== HTTP HANDLERS ====================
+
+// SSE stream of a topic or pattern (ex: /api/events/room.*.chat)
+func handleTopicEvents(c *core.RequestEvent) error {
+	topic := c.Request.PathValue("topic")
+	if err := ValidateTopicPattern(topic); err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	// EventSource can't set headers, accept the auth token as query param
+	if err := loadQueryTokenAuth(c); err != nil {
+		return c.JSON(401, map[string]string{"error": "invalid token"})
+	}
+
+	info, err := c.RequestInfo()
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	access := topicAuthorizer.NewAccess(info)
+
+	// Exact topics are checked upfront, patterns message by message
+	if !IsWildcardTopic(topic) && !access.AllowsTopic(topic) {
+		if c.Auth == nil {
+			return c.JSON(401, map[string]string{"error": "authentication required"})
+		}
+		return c.JSON(403, map[string]string{"error": "not allowed to subscribe to this topic"})
+	}
+
+	opts, err := subscribeOptionsFromRequest(c)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	c.Response.Header().Set("Content-Type", "text/event-stream")
+	c.Response.Header().Set("Cache-Control", "no-cache")
+	c.Response.Header().Set("Connection", "keep-alive")
+
+	// Unsubscribed automatically when the client goes away
+	sub := pubsub.SubscribeContext(c.Request.Context(), topic, opts)
+	defer sub.Unsubscribe()
+
+	wildcard := IsWildcardTopic(topic)
+
+	send := func(msg PubSubMessage) {
+		if !access.Allows(msg) || !opts.Filter.Match(msg) {
+			return
+		}
+
+		var data []byte
+		if wildcard {
+			// Pattern subscribers need to know the concrete topic
+			data, _ = json.Marshal(map[string]interface{}{
+				"topic":   msg.Topic,
+				"payload": msg.Payload,
+			})
+		} else {
+			data, _ = json.Marshal(msg.Payload)
+		}
+		writeSSEEvent(c, msg.ID, data)
+	}
+
+	// Subscribed first, so nothing is lost between the replay and the live stream
+	var lastSent uint64
+	if lastID := lastEventIDFromRequest(c); lastID > 0 {
+		for _, msg := range pubsub.Replay(topic, lastID) {
+			send(msg)
+			lastSent = msg.ID
+		}
+	}
+
+	for msg := range sub.C {
+		if msg.ID <= lastSent {
+			continue // already replayed
+		}
+		send(msg)
+	}
+
+	// Tell the client why the stream ends
+	if reason := sub.Reason(); reason != "" {
+		writeSSEDisconnect(c, reason, sub.Dropped())
+	}
+
+	return nil
+}
+
+// Write one SSE event with its id
+func writeSSEEvent(c *core.RequestEvent, id uint64, data []byte) {
+	if id > 0 {
+		fmt.Fprintf(c.Response, "id: %d\n", id)
+	}
+	fmt.Fprintf(c.Response, "data: %s\n\n", data)
+	if f, ok := c.Response.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Final event sent to a consumer disconnected by the server
+func writeSSEDisconnect(c *core.RequestEvent, reason string, dropped uint64) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"reason":  reason,
+		"dropped": dropped,
+	})
+	fmt.Fprintf(c.Response, "event: disconnect\ndata: %s\n\n", data)
+	if f, ok := c.Response.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Authenticate with ?token= when there is no Authorization header
+func loadQueryTokenAuth(c *core.RequestEvent) error {
+	if c.Auth != nil {
+		return nil
+	}
+
+	token := c.Request.URL.Query().Get("token")
+	if token == "" {
+		return nil
+	}
+
+	record, err := c.App.FindAuthRecordByToken(token, core.TokenTypeAuth)
+	if err != nil {
+		return err
+	}
+	c.Auth = record
+	return nil
+}
+
+// Last-Event-ID header, or lastEventId query param for clients that can't set headers
+func lastEventIDFromRequest(c *core.RequestEvent) uint64 {
+	value := c.Request.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = c.Request.URL.Query().Get("lastEventId")
+	}
+	id, _ := strconv.ParseUint(value, 10, 64)
+	return id
+}
+
+// Subscribers per topic
+func handleTopicStats(c *core.RequestEvent) error {
+	topics := pubsub.Topics()
+
+	total := 0
+	for _, count := range topics {
+		total += count
+	}
+
+	// Subscriptions falling behind
+	lagging := []SubscriptionStats{}
+	for _, sub := range pubsub.Subscriptions() {
+		if sub.Dropped > 0 || sub.Pending*2 >= sub.BufferSize {
+			lagging = append(lagging, sub)
+		}
+	}
+
+	stats := map[string]interface{}{
+		"topics":      topics,
+		"subscribers": total,
+		"backend":     "memory",
+		"dropped":     pubsub.Dropped(),
+		"lagging":     lagging,
+		"sse":         userChannelManager.SSEStats(),
+	}
+
+	if bp, ok := pubsub.(*BrokerPubSub); ok {
+		stats["backend"] = "broker"
+		stats["node"] = bp.NodeID()
+		stats["connected"] = bp.IsConnected()
+	}
+
+	return c.JSON(200, stats)
+}
@@ -0,0 +1,497 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	msgpack "github.com/vmihailenco/msgpack/v5"
+)
+
+// ==================== BROKER PROTOCOL ====================
+
+// Frames: uint32 big-endian length + msgpack encoded brokerFrame.
+// The first frame of a connection is a "hello" with the node ID and the
+// shared secret, then only "publish" frames are exchanged.
+type brokerFrame struct {
+	Type   string         `msgpack:"type"`
+	Node   string         `msgpack:"node"`
+	Secret string         `msgpack:"secret,omitempty"`
+	Msg    *PubSubMessage `msgpack:"msg,omitempty"`
+}
+
+const maxBrokerFrameSize = 16 << 20
+
+// The secret travels in the hello frame, use TLS outside a private network
+var errBrokerSecretRequired = errors.New("the PubSub broker requires a secret (--pubsubBrokerSecret)")
+
+func encodeBrokerFrame(frame brokerFrame) ([]byte, error) {
+	body, err := msgpack.Marshal(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(data, uint32(len(body)))
+	copy(data[4:], body)
+	return data, nil
+}
+
+func readBrokerFrame(r io.Reader) ([]byte, brokerFrame, error) {
+	var frame brokerFrame
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, frame, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > maxBrokerFrameSize {
+		return nil, frame, fmt.Errorf("frame too large: %d bytes", size)
+	}
+
+	data := make([]byte, 4+size)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		return nil, frame, err
+	}
+
+	err := msgpack.Unmarshal(data[4:], &frame)
+	return data, frame, err
+}
+
+// ==================== BROKER TLS ====================
+
+// TLS of the broker listener, nil without certificate
+func brokerServerTLS(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("broker certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// Address and TLS of a broker URL: host:port in clear, tls://host:port
+// verified with the system roots or the caFile bundle
+func brokerClientTLS(url, caFile string) (string, *tls.Config, error) {
+	addr, secure := strings.CutPrefix(url, "tls://")
+	if !secure {
+		if caFile != "" {
+			return "", nil, fmt.Errorf("--pubsubBrokerCA needs a tls:// broker URL")
+		}
+		return addr, nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return "", nil, fmt.Errorf("broker CA: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return "", nil, fmt.Errorf("broker CA: no certificate in %s", caFile)
+		}
+	}
+	return addr, config, nil
+}
+
+// Client TLS of the node running the broker, pinned to the broker certificate
+// (it connects to its own listener, whatever the name in the certificate)
+func pinnedBrokerTLS(server *tls.Config) *tls.Config {
+	leaf := server.Certificates[0].Certificate[0]
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, // replaced by the pinning below
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], leaf) {
+				return fmt.Errorf("unexpected broker certificate")
+			}
+			return nil
+		},
+	}
+}
+
+// ==================== EMBEDDED BROKER ====================
+
+// PubSubBroker - Petit broker TCP qui relaie les messages entre instances
+type PubSubBroker struct {
+	listener net.Listener
+	secret   string
+	conns    map[*brokerConn]struct{}
+	mu       sync.RWMutex
+}
+
+type brokerConn struct {
+	conn net.Conn
+	node string
+	out  chan []byte
+}
+
+// TLS when tlsConfig is set, the nodes must present the secret
+func StartPubSubBroker(addr, secret string, tlsConfig *tls.Config) (*PubSubBroker, error) {
+	if secret == "" {
+		return nil, errBrokerSecretRequired
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	broker := &PubSubBroker{
+		listener: listener,
+		secret:   secret,
+		conns:    make(map[*brokerConn]struct{}),
+	}
+
+	go broker.acceptLoop()
+
+	log.Printf("📡 PubSub broker listening on %s", listener.Addr())
+	return broker, nil
+}
+
+func (b *PubSubBroker) acceptLoop() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return // listener closed
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *PubSubBroker) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	// Handshake
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, hello, err := readBrokerFrame(reader)
+	if err != nil || hello.Type != "hello" || hello.Node == "" {
+		log.Printf("PubSub broker: invalid handshake from %s", conn.RemoteAddr())
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hello.Secret), []byte(b.secret)) != 1 {
+		log.Printf("PubSub broker: bad secret from %s", conn.RemoteAddr())
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	bc := &brokerConn{
+		conn: conn,
+		node: hello.Node,
+		out:  make(chan []byte, 1000),
+	}
+
+	b.mu.Lock()
+	b.conns[bc] = struct{}{}
+	b.mu.Unlock()
+
+	log.Printf("🔗 PubSub node connected: %s (%s)", bc.node, conn.RemoteAddr())
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, bc)
+		b.mu.Unlock()
+		close(bc.out)
+		log.Printf("🔌 PubSub node disconnected: %s", bc.node)
+	}()
+
+	// Writer
+	go func() {
+		for data := range bc.out {
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := conn.Write(data); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	// Relay every publish frame to the other nodes
+	for {
+		data, frame, err := readBrokerFrame(reader)
+		if err != nil {
+			return
+		}
+		if frame.Type != "publish" || frame.Msg == nil {
+			continue
+		}
+
+		b.mu.RLock()
+		for other := range b.conns {
+			if other == bc {
+				continue
+			}
+			select {
+			case other.out <- data:
+			default:
+				log.Printf("⚠️  PubSub broker: queue full for node %s", other.node)
+			}
+		}
+		b.mu.RUnlock()
+	}
+}
+
+func (b *PubSubBroker) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// Connected node IDs
+func (b *PubSubBroker) Nodes() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	nodes := make([]string, 0, len(b.conns))
+	for bc := range b.conns {
+		nodes = append(nodes, bc.node)
+	}
+	return nodes
+}
+
+func (b *PubSubBroker) Close() error {
+	return b.listener.Close()
+}
+
+// ==================== BROKER CLIENT ====================
+
+// BrokerPubSub - PubSub local relayé vers les autres instances via le broker
+type BrokerPubSub struct {
+	*MemoryPubSub
+	addr      string
+	secret    string
+	tls       *tls.Config // nil in clear
+	nodeID    string
+	out       chan PubSubMessage
+	connected bool
+	conn      net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+	mu        sync.RWMutex
+}
+
+func NewBrokerPubSub(local *MemoryPubSub, addr, secret string, tlsConfig *tls.Config) (*BrokerPubSub, error) {
+	if secret == "" {
+		return nil, errBrokerSecretRequired
+	}
+
+	bp := &BrokerPubSub{
+		MemoryPubSub: local,
+		addr:         addr,
+		secret:       secret,
+		tls:          tlsConfig,
+		nodeID:       newNodeID(),
+		out:          make(chan PubSubMessage, 1000),
+		closed:       make(chan struct{}),
+	}
+
+	go bp.run()
+
+	return bp, nil
+}
+
+func newNodeID() string {
+	hostname, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hostname + "-" + hex.EncodeToString(b)
+}
+
+// Publish locally, then forward to the other nodes
+func (bp *BrokerPubSub) Publish(topic string, msg PubSubMessage) {
+	if msg.Topic == "" {
+		msg.Topic = topic
+	}
+	if msg.ID == 0 {
+		msg.ID = nextEventID()
+	}
+
+	bp.MemoryPubSub.publish(topic, msg, true)
+	bp.Forward(msg)
+}
+
+// Send to the other nodes only, msg is already delivered on this one
+func (bp *BrokerPubSub) Forward(msg PubSubMessage) {
+	select {
+	case bp.out <- msg:
+	default:
+		log.Printf("⚠️  PubSub broker queue full, message on %s not forwarded", msg.Topic)
+	}
+}
+
+// Stop relaying and reconnecting
+func (bp *BrokerPubSub) Close() {
+	bp.closeOnce.Do(func() {
+		close(bp.closed)
+
+		bp.mu.Lock()
+		if bp.conn != nil {
+			bp.conn.Close()
+		}
+		bp.mu.Unlock()
+	})
+}
+
+func (bp *BrokerPubSub) NodeID() string {
+	return bp.nodeID
+}
+
+func (bp *BrokerPubSub) IsConnected() bool {
+	bp.mu.RLock()
+	defer bp.mu.RUnlock()
+	return bp.connected
+}
+
+// Track the connection so that Close can interrupt it, false once closed
+func (bp *BrokerPubSub) setConn(conn net.Conn) bool {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	select {
+	case <-bp.closed:
+		return false
+	default:
+	}
+
+	bp.conn = conn
+	bp.connected = conn != nil
+	return true
+}
+
+func (bp *BrokerPubSub) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if bp.tls != nil {
+		return tls.DialWithDialer(dialer, "tcp", bp.addr, bp.tls)
+	}
+	return dialer.Dial("tcp", bp.addr)
+}
+
+// Sleep, false when closed meanwhile
+func (bp *BrokerPubSub) wait(d time.Duration) bool {
+	select {
+	case <-bp.closed:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// Connect to the broker, reconnecting with backoff
+func (bp *BrokerPubSub) run() {
+	backoff := time.Second
+
+	for {
+		conn, err := bp.dial()
+		if err != nil {
+			log.Printf("PubSub broker %s unreachable: %v (retry in %s)", bp.addr, err, backoff)
+			if !bp.wait(backoff) {
+				return
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+
+		if !bp.setConn(conn) {
+			conn.Close()
+			return
+		}
+
+		connectedAt := time.Now()
+		bp.serve(conn)
+		bp.setConn(nil)
+
+		// Short lived connection (ex: rejected secret): keep backing off
+		if time.Since(connectedAt) > 30*time.Second {
+			backoff = time.Second
+		} else {
+			if !bp.wait(backoff) {
+				return
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}
+}
+
+func (bp *BrokerPubSub) serve(conn net.Conn) {
+	defer conn.Close()
+
+	hello, _ := encodeBrokerFrame(brokerFrame{Type: "hello", Node: bp.nodeID, Secret: bp.secret})
+	if _, err := conn.Write(hello); err != nil {
+		return
+	}
+
+	log.Printf("🔗 Connected to PubSub broker %s as %s", bp.addr, bp.nodeID)
+
+	// Reader: deliver messages from the other nodes locally
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reader := bufio.NewReader(conn)
+		for {
+			_, frame, err := readBrokerFrame(reader)
+			if err != nil {
+				log.Printf("PubSub broker connection lost: %v", err)
+				return
+			}
+			if frame.Type != "publish" || frame.Msg == nil || frame.Node == bp.nodeID {
+				continue
+			}
+
+			msg := *frame.Msg
+			observeEventID(msg.ID)
+			bp.MemoryPubSub.publish(msg.Topic, msg, false)
+		}
+	}()
+
+	// Writer
+	for {
+		select {
+		case <-done:
+			return
+		case <-bp.closed:
+			return
+		case msg := <-bp.out:
+			data, err := encodeBrokerFrame(brokerFrame{Type: "publish", Node: bp.nodeID, Msg: &msg})
+			if err != nil {
+				log.Printf("Error encoding PubSub message: %v", err)
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := conn.Write(data); err != nil {
+				log.Printf("PubSub broker write failed: %v", err)
+				return
+			}
+		}
+	}
+}
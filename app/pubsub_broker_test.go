@@ -0,0 +1,214 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBrokerSecret = "s3cret"
+
+func startTestBroker(t *testing.T, tlsConfig *tls.Config) *PubSubBroker {
+	t.Helper()
+
+	broker, err := StartPubSubBroker("127.0.0.1:0", testBrokerSecret, tlsConfig)
+	require.NoError(t, err)
+	t.Cleanup(func() { broker.Close() })
+	return broker
+}
+
+func newTestBrokerNode(t *testing.T, addr, secret string, tlsConfig *tls.Config) *BrokerPubSub {
+	t.Helper()
+
+	node, err := NewBrokerPubSub(NewMemoryPubSub(), addr, secret, tlsConfig)
+	require.NoError(t, err)
+	t.Cleanup(node.Close)
+	return node
+}
+
+func waitBrokerNodes(t *testing.T, broker *PubSubBroker, count int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		return len(broker.Nodes()) == count
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// Self-signed certificate for 127.0.0.1, written as PEM files
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tania broker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "broker.crt")
+	keyFile = filepath.Join(dir, "broker.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestBrokerRequiresSecret(t *testing.T) {
+	_, err := StartPubSubBroker("127.0.0.1:0", "", nil)
+	assert.ErrorIs(t, err, errBrokerSecretRequired)
+
+	_, err = NewBrokerPubSub(NewMemoryPubSub(), "127.0.0.1:7070", "", nil)
+	assert.ErrorIs(t, err, errBrokerSecretRequired)
+}
+
+func TestBrokerRelaysBetweenNodes(t *testing.T) {
+	broker := startTestBroker(t, nil)
+	addr := broker.Addr().String()
+
+	nodeA := newTestBrokerNode(t, addr, testBrokerSecret, nil)
+	nodeB := newTestBrokerNode(t, addr, testBrokerSecret, nil)
+	intruder := newTestBrokerNode(t, addr, "wrong", nil)
+	waitBrokerNodes(t, broker, 2)
+
+	subA := nodeA.Subscribe("room.*.chat")
+	subB := nodeB.Subscribe("room.*.chat")
+	subIntruder := intruder.Subscribe("room.*.chat")
+
+	nodeA.Publish("room.abc.chat", PubSubMessage{Payload: map[string]interface{}{"text": "hi"}})
+
+	msg := receiveMessage(t, subB)
+	assert.Equal(t, "room.abc.chat", msg.Topic)
+	assert.Equal(t, "hi", msg.Payload["text"])
+
+	// Once on the publishing node, never to a node with a bad secret
+	assert.Equal(t, msg.ID, receiveMessage(t, subA).ID)
+	assertNoMessage(t, subA)
+	assertNoMessage(t, subIntruder)
+
+	// Forward skips the local subscribers
+	nodeB.Forward(PubSubMessage{ID: nextEventID(), Topic: "room.xyz.chat"})
+	assert.Equal(t, "room.xyz.chat", receiveMessage(t, subA).Topic)
+	assertNoMessage(t, subB)
+}
+
+func TestBrokerTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+
+	serverTLS, err := brokerServerTLS(certFile, keyFile)
+	require.NoError(t, err)
+	broker := startTestBroker(t, serverTLS)
+	addr := broker.Addr().String()
+
+	clientAddr, clientTLS, err := brokerClientTLS("tls://"+addr, certFile)
+	require.NoError(t, err)
+	assert.Equal(t, addr, clientAddr)
+
+	nodeA := newTestBrokerNode(t, clientAddr, testBrokerSecret, clientTLS)
+	nodeB := newTestBrokerNode(t, addr, testBrokerSecret, pinnedBrokerTLS(serverTLS))
+	// In clear against a TLS broker: the handshake fails
+	plain := newTestBrokerNode(t, addr, testBrokerSecret, nil)
+	waitBrokerNodes(t, broker, 2)
+
+	subB := nodeB.Subscribe("room.abc.chat")
+	subPlain := plain.Subscribe("room.abc.chat")
+
+	nodeA.Publish("room.abc.chat", PubSubMessage{})
+	assert.Equal(t, "room.abc.chat", receiveMessage(t, subB).Topic)
+	assertNoMessage(t, subPlain)
+
+	// Clear URLs can't take a CA
+	_, _, err = brokerClientTLS(addr, certFile)
+	assert.Error(t, err)
+}
+
+func TestBrokerUntrustedCertificate(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	otherCert, _ := writeTestCertificate(t)
+
+	serverTLS, err := brokerServerTLS(certFile, keyFile)
+	require.NoError(t, err)
+	broker := startTestBroker(t, serverTLS)
+	addr := broker.Addr().String()
+
+	_, untrusted, err := brokerClientTLS("tls://"+addr, otherCert)
+	require.NoError(t, err)
+	newTestBrokerNode(t, addr, testBrokerSecret, untrusted)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, broker.Nodes())
+}
+
+func TestUserChannelRelay(t *testing.T) {
+	broker := startTestBroker(t, nil)
+	addr := broker.Addr().String()
+
+	nodeA := newTestBrokerNode(t, addr, testBrokerSecret, nil)
+	nodeB := newTestBrokerNode(t, addr, testBrokerSecret, nil)
+	waitBrokerNodes(t, broker, 2)
+
+	ucmA := NewUserChannelManager(nil)
+	ucmB := NewUserChannelManager(nil)
+	ucmA.EnableRelay(nodeA)
+	ucmB.EnableRelay(nodeB)
+
+	// The user is connected to node B only
+	channel := ucmB.OpenSSESession("alice", "s1", "")
+	other := ucmB.OpenSSESession("alice", "s2", "")
+
+	receive := func(channel *SSEChannel) SSEMessage {
+		t.Helper()
+		select {
+		case msg := <-channel.Channel:
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("no relayed message")
+		}
+		return SSEMessage{}
+	}
+
+	ucmA.SendToSSE("alice", "notification", map[string]interface{}{"text": "hi"}, "")
+	msg := receive(channel)
+	assert.Equal(t, "notification", msg.Type)
+	assert.Equal(t, "hi", msg.Data["text"])
+	assert.Equal(t, "notification", receive(other).Type)
+
+	// Kept on node B for a reconnection there
+	replayed := ucmB.ReplaySSE("alice", "s1", msg.ID-1)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, msg.ID, replayed[0].ID)
+
+	// Responses reach only their session
+	ucmA.SendToSession("alice", "s2", "response", map[string]interface{}{}, "req-1")
+	response := receive(other)
+	assert.Equal(t, "req-1", response.RequestID)
+	select {
+	case msg := <-channel.Channel:
+		t.Fatalf("response delivered to another session: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
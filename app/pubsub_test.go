@@ -0,0 +1,119 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receiveMessage(t *testing.T, sub *Subscription) PubSubMessage {
+	t.Helper()
+
+	select {
+	case msg, ok := <-sub.C:
+		require.True(t, ok, "subscription closed")
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("no message on %s", sub.Topic)
+	}
+	return PubSubMessage{}
+}
+
+func assertNoMessage(t *testing.T, sub *Subscription) {
+	t.Helper()
+
+	select {
+	case msg := <-sub.C:
+		t.Fatalf("unexpected message on %s: %s", sub.Topic, msg.Topic)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"room.abc.chat", "room.abc.chat", true},
+		{"room.abc.chat", "room.abd.chat", false},
+		{"room.*.chat", "room.abc.chat", true},
+		{"room.*.chat", "room.abc.video", false},
+		{"room.*.chat", "room.abc.chat.extra", false},
+		{"room.*", "room.abc.chat", false},
+		{"room.>", "room.abc", true},
+		{"room.>", "room.abc.chat", true},
+		{"room.>", "room", false},
+		{"user.abc.>", "user.abc.notifications", true},
+		{"user.abc.>", "user.abd.notifications", false},
+		{"*.*.chat", "room.abc.chat", true},
+		{">", "anything.at.all", true},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.match, TopicMatches(tc.pattern, tc.topic), "%s ~ %s", tc.pattern, tc.topic)
+	}
+}
+
+func TestValidateTopicPattern(t *testing.T) {
+	for _, valid := range []string{"room.abc.chat", "room.*.chat", "user.abc.>", ">", "*"} {
+		assert.NoError(t, ValidateTopicPattern(valid), valid)
+	}
+	for _, invalid := range []string{"", "room..chat", "room.>.chat", "room.a*.chat", "room.>x", "room."} {
+		assert.Error(t, ValidateTopicPattern(invalid), invalid)
+	}
+
+	assert.True(t, IsWildcardTopic("room.*.chat"))
+	assert.True(t, IsWildcardTopic("user.abc.>"))
+	assert.False(t, IsWildcardTopic("room.abc.chat"))
+}
+
+func TestPubSubWildcardDelivery(t *testing.T) {
+	ps := NewMemoryPubSub()
+
+	exact := ps.Subscribe("room.abc.chat")
+	single := ps.Subscribe("room.*.chat")
+	tail := ps.Subscribe("room.abc.>")
+	defer exact.Unsubscribe()
+	defer single.Unsubscribe()
+	defer tail.Unsubscribe()
+
+	assert.Equal(t, 3, ps.SubscriberCount("room.abc.chat"))
+	assert.Equal(t, 1, ps.SubscriberCount("room.xyz.chat"))
+	assert.Equal(t, 1, ps.SubscriberCount("room.abc.video"))
+
+	ps.Publish("room.abc.chat", PubSubMessage{Payload: map[string]interface{}{"text": "hi"}})
+	for _, sub := range []*Subscription{exact, single, tail} {
+		msg := receiveMessage(t, sub)
+		assert.Equal(t, "room.abc.chat", msg.Topic)
+		assert.Equal(t, "hi", msg.Payload["text"])
+		assert.NotZero(t, msg.ID)
+	}
+
+	// Concrete topic kept for the pattern subscribers
+	ps.Publish("room.xyz.chat", PubSubMessage{})
+	assert.Equal(t, "room.xyz.chat", receiveMessage(t, single).Topic)
+	assertNoMessage(t, exact)
+	assertNoMessage(t, tail)
+
+	ps.Publish("room.abc.video", PubSubMessage{})
+	assert.Equal(t, "room.abc.video", receiveMessage(t, tail).Topic)
+	assertNoMessage(t, single)
+}
+
+func TestPubSubWildcardUnsubscribe(t *testing.T) {
+	ps := NewMemoryPubSub()
+
+	sub := ps.Subscribe("room.*.chat")
+	assert.Equal(t, map[string]int{"room.*.chat": 1}, ps.Topics())
+
+	sub.Unsubscribe()
+	assert.Empty(t, ps.Topics())
+	assert.Equal(t, 0, ps.SubscriberCount("room.abc.chat"))
+
+	// Publishing after the unsubscribe must not reach nor panic
+	ps.Publish("room.abc.chat", PubSubMessage{})
+	_, open := <-sub.C
+	assert.False(t, open)
+}
@@ -0,0 +1,395 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ==================== RATE LIMIT RULES ====================
+
+// RateLimitRule - Token bucket d'un groupe de routes.
+// Routes are router patterns ("POST /api/posts/{postId}/like"), a trailing
+// "*" matches a prefix ("* /api/location/*" = any method). The "RPC" route
+// is every request frame received on the DataChannel or the WebSocket.
+// Requests are counted per user when authenticated, per IP otherwise
+// (Key "ip" forces the IP).
+type RateLimitRule struct {
+	Group     string
+	Routes    []string
+	PerMinute int // refill rate
+	Burst     int // bucket size
+	Key       string
+}
+
+// Règles créées avec la collection rateLimitRules
+var defaultRateLimitRules = []RateLimitRule{
+	{Group: "reactions", Routes: []string{"POST /api/posts/{postId}/like", "POST /api/posts/{postId}/comment"}, PerMinute: 60, Burst: 10},
+	{Group: "location", Routes: []string{"POST /api/location/update", "POST /api/rooms/{roomId}/broadcast-location"}, PerMinute: 120, Burst: 10},
+	{Group: "connections", Routes: []string{"GET /api/user/sse", "GET /api/events/{topic}", "GET /api/ws", "POST /api/user/room/connect"}, PerMinute: 20, Burst: 10},
+	{Group: "auth", Routes: []string{"POST /api/collections/{collection}/auth-*"}, PerMinute: 10, Burst: 5, Key: "ip"},
+	{Group: "rpc", Routes: []string{"RPC"}, PerMinute: 600, Burst: 50},
+	{Group: "default", Routes: []string{"* /api/*"}, PerMinute: 1200, Burst: 100},
+}
+
+// Check if a route pattern matches the pattern of a rule
+func rateLimitRouteMatches(rulePattern, route string) bool {
+	if rulePattern == route {
+		return true
+	}
+
+	ruleMethod, rulePath, hasMethod := strings.Cut(rulePattern, " ")
+	method, path, _ := strings.Cut(route, " ")
+	if !hasMethod {
+		rulePath, ruleMethod = ruleMethod, "*"
+	}
+	if ruleMethod != "*" && ruleMethod != method {
+		return false
+	}
+
+	if prefix, ok := strings.CutSuffix(rulePath, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return rulePath == path
+}
+
+// Longest literal part first, so that "default" comes last
+func rateLimitRouteSpecificity(pattern string) int {
+	score := len(strings.TrimSuffix(pattern, "*")) * 2
+	if !strings.HasPrefix(pattern, "* ") {
+		score++
+	}
+	return score
+}
+
+// ==================== TOKEN BUCKETS ====================
+
+type tokenBucket struct {
+	tokens   float64
+	updated  time.Time
+	capacity float64
+}
+
+// Take a token, or return how long until the next one
+func (b *tokenBucket) take(now time.Time, perSecond float64) (bool, time.Duration) {
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	return false, wait
+}
+
+// RateLimitStats - Compteurs d'un groupe
+type RateLimitStats struct {
+	Group     string `json:"group"`
+	PerMinute int    `json:"perMinute"`
+	Burst     int    `json:"burst"`
+	Allowed   uint64 `json:"allowed"`
+	Limited   uint64 `json:"limited"`
+	Buckets   int    `json:"buckets"`
+}
+
+// RateLimitError - Requête refusée, RetryAfter avant le prochain token
+type RateLimitError struct {
+	Group      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("too many requests (%s), retry in %ds", e.Group, e.RetrySeconds())
+}
+
+func (e *RateLimitError) RetrySeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// ==================== RATE LIMITER ====================
+
+type RateLimiter struct {
+	rules     []RateLimitRule
+	routes    []rateLimitRoute        // sorted by specificity
+	buckets   map[string]*tokenBucket // group/subject
+	stats     map[string]*RateLimitStats
+	lastSweep time.Time
+	app       core.App
+	mu        sync.Mutex
+}
+
+type rateLimitRoute struct {
+	pattern string
+	rule    int
+}
+
+func NewRateLimiter(app core.App) *RateLimiter {
+	rl := &RateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		stats:     make(map[string]*RateLimitStats),
+		lastSweep: time.Now(),
+		app:       app,
+	}
+	rl.Reload()
+	return rl
+}
+
+// Load the rules from the rateLimitRules collection
+func (rl *RateLimiter) Reload() {
+	rules := []RateLimitRule{}
+
+	records, err := rl.app.FindAllRecords("rateLimitRules")
+	if err != nil {
+		log.Printf("Error loading rate limit rules, using defaults: %v", err)
+		rules = append(rules, defaultRateLimitRules...)
+	} else {
+		for _, record := range records {
+			routes := []string{}
+			record.UnmarshalJSONField("routes", &routes)
+
+			rules = append(rules, RateLimitRule{
+				Group:     record.GetString("name"),
+				Routes:    routes,
+				PerMinute: record.GetInt("perMinute"),
+				Burst:     record.GetInt("burst"),
+				Key:       record.GetString("key"),
+			})
+		}
+	}
+
+	routes := []rateLimitRoute{}
+	for i, rule := range rules {
+		for _, pattern := range rule.Routes {
+			routes = append(routes, rateLimitRoute{pattern: pattern, rule: i})
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return rateLimitRouteSpecificity(routes[i].pattern) > rateLimitRouteSpecificity(routes[j].pattern)
+	})
+
+	rl.mu.Lock()
+	// The buckets of unchanged rules keep their tokens, the others are
+	// recreated with the new limits
+	unchanged := make(map[string]bool)
+	for _, previous := range rl.rules {
+		for _, rule := range rules {
+			if rule.Group == previous.Group && rule.PerMinute == previous.PerMinute && rule.Burst == previous.Burst && rule.Key == previous.Key {
+				unchanged[rule.Group] = true
+			}
+		}
+	}
+	for key := range rl.buckets {
+		group, _, _ := strings.Cut(key, "/")
+		if !unchanged[group] {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.rules = rules
+	rl.routes = routes
+	rl.mu.Unlock()
+
+	log.Printf("🚦 %d rate limit rules loaded", len(rules))
+}
+
+// Rule of a route ("POST /api/location/update", "RPC")
+func (rl *RateLimiter) RuleFor(route string) (RateLimitRule, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.ruleForLocked(route)
+}
+
+func (rl *RateLimiter) ruleForLocked(route string) (RateLimitRule, bool) {
+	for _, r := range rl.routes {
+		if rateLimitRouteMatches(r.pattern, route) {
+			return rl.rules[r.rule], true
+		}
+	}
+	return RateLimitRule{}, false
+}
+
+// Take a token for a request of userID (or ip when anonymous) on a route.
+// Routes without a rule, and rules with PerMinute <= 0, are not limited.
+func (rl *RateLimiter) Allow(route, userID, ip string) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Same lock as the bucket, a Reload can't mix old and new limits
+	rule, ok := rl.ruleForLocked(route)
+	if !ok || rule.PerMinute <= 0 {
+		return nil
+	}
+
+	subject := "ip:" + ip
+	if userID != "" && rule.Key != "ip" {
+		subject = "user:" + userID
+	}
+
+	burst := rule.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+
+	now := time.Now()
+	key := rule.Group + "/" + subject
+
+	rl.sweep(now)
+
+	bucket, exists := rl.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(burst), updated: now, capacity: float64(burst)}
+		rl.buckets[key] = bucket
+	}
+
+	stats, exists := rl.stats[rule.Group]
+	if !exists {
+		stats = &RateLimitStats{Group: rule.Group}
+		rl.stats[rule.Group] = stats
+	}
+
+	allowed, wait := bucket.take(now, float64(rule.PerMinute)/60)
+	if !allowed {
+		stats.Limited++
+		return &RateLimitError{Group: rule.Group, RetryAfter: wait}
+	}
+
+	stats.Allowed++
+	return nil
+}
+
+// Check a request frame received on a user channel
+func (rl *RateLimiter) AllowRPC(userID string) error {
+	if rl == nil {
+		return nil
+	}
+	return rl.Allow("RPC", userID, "")
+}
+
+// Forget the buckets unused for 10 minutes, checked every minute
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.updated) > 10*time.Minute {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// Counters per group since the start
+func (rl *RateLimiter) Stats() []RateLimitStats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	buckets := make(map[string]int)
+	for key := range rl.buckets {
+		group, _, _ := strings.Cut(key, "/")
+		buckets[group]++
+	}
+
+	stats := make([]RateLimitStats, 0, len(rl.rules))
+	for _, rule := range rl.rules {
+		entry := RateLimitStats{Group: rule.Group}
+		if counters, exists := rl.stats[rule.Group]; exists {
+			entry = *counters
+		}
+		entry.PerMinute = rule.PerMinute
+		entry.Burst = rule.Burst
+		entry.Buckets = buckets[rule.Group]
+		stats = append(stats, entry)
+	}
+	return stats
+}
+
+// ==================== MIDDLEWARE ====================
+
+// Router middleware: the HTTP routes and the APIRequests of the user
+// channels (dispatched through the router) share the same buckets.
+// Superusers are not limited.
+func rateLimitMiddleware(c *core.RequestEvent) error {
+	// Deferred requests were counted when received
+	if rateLimiter == nil || c.HasSuperuserAuth() || isDeferredRequest(c) {
+		return c.Next()
+	}
+
+	userID := ""
+	if c.Auth != nil {
+		userID = c.Auth.Id
+	}
+
+	if err := rateLimiter.Allow(c.Request.Pattern, userID, c.RealIP()); err != nil {
+		return rateLimitResponse(c, err)
+	}
+
+	return c.Next()
+}
+
+func rateLimitResponse(c *core.RequestEvent, err error) error {
+	limitErr, ok := err.(*RateLimitError)
+	if !ok {
+		return c.JSON(429, map[string]string{"error": err.Error()})
+	}
+
+	c.Response.Header().Set("Retry-After", strconv.Itoa(limitErr.RetrySeconds()))
+	return c.JSON(429, map[string]interface{}{
+		"error":       "too many requests",
+		"group":       limitErr.Group,
+		"retry_after": limitErr.RetrySeconds(),
+	})
+}
+
+// ==================== HTTP HANDLERS ====================
+
+func handleRateLimitStats(c *core.RequestEvent) error {
+	return c.JSON(200, map[string]interface{}{
+		"groups": rateLimiter.Stats(),
+	})
+}
+
+// ==================== SETUP COLLECTIONS ====================
+
+func SetupRateLimitRulesCollection(app core.App) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		rateLimitRules := core.NewBaseCollection("rateLimitRules")
+		rateLimitRules.Fields.Add(
+			&core.TextField{Name: "name", Required: true},
+			&core.JSONField{Name: "routes"},
+			&core.NumberField{Name: "perMinute", OnlyInt: true},
+			&core.NumberField{Name: "burst", OnlyInt: true},
+			&core.SelectField{Name: "key", Values: []string{"user", "ip"}, MaxSelect: 1},
+		)
+		rateLimitRules.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_rate_limit_rules_name ON rateLimitRules (name)",
+		}
+
+		if err := txApp.Save(rateLimitRules); err != nil {
+			return err
+		}
+
+		for _, rule := range defaultRateLimitRules {
+			record := core.NewRecord(rateLimitRules)
+			record.Set("name", rule.Group)
+			record.Set("routes", rule.Routes)
+			record.Set("perMinute", rule.PerMinute)
+			record.Set("burst", rule.Burst)
+			record.Set("key", rule.Key)
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRateLimiter(t *testing.T) (core.App, *RateLimiter) {
+	t.Helper()
+
+	app := newTestApp(t)
+	require.NoError(t, SetupRateLimitRulesCollection(app))
+	return app, NewRateLimiter(app)
+}
+
+func setRateLimitRule(t *testing.T, app core.App, group string, perMinute, burst int) {
+	t.Helper()
+
+	record, err := app.FindFirstRecordByFilter("rateLimitRules", "name = {:name}", dbx.Params{"name": group})
+	require.NoError(t, err)
+	record.Set("perMinute", perMinute)
+	record.Set("burst", burst)
+	require.NoError(t, app.Save(record))
+}
+
+// Take tokens until the bucket is empty, returns how many were allowed
+func drainRateLimit(rl *RateLimiter, route, userID, ip string) int {
+	allowed := 0
+	for ; allowed < 1000; allowed++ {
+		if rl.Allow(route, userID, ip) != nil {
+			break
+		}
+	}
+	return allowed
+}
+
+func TestRateLimitRuleFor(t *testing.T) {
+	_, rl := newTestRateLimiter(t)
+
+	cases := map[string]string{
+		"POST /api/posts/{postId}/like":                         "reactions",
+		"POST /api/location/update":                             "location",
+		"GET /api/ws":                                           "connections",
+		"POST /api/collections/{collection}/auth-with-password": "auth",
+		"RPC":                       "rpc",
+		"GET /api/posts/{postId}":   "default",
+		"DELETE /api/anything/else": "default",
+	}
+	for route, group := range cases {
+		rule, ok := rl.RuleFor(route)
+		require.True(t, ok, route)
+		assert.Equal(t, group, rule.Group, route)
+	}
+
+	_, ok := rl.RuleFor("GET /")
+	assert.False(t, ok)
+}
+
+func TestRateLimitAllow(t *testing.T) {
+	_, rl := newTestRateLimiter(t)
+
+	// Burst, then limited with a retry delay
+	assert.Equal(t, 10, drainRateLimit(rl, "POST /api/posts/{postId}/like", "alice", "10.0.0.1"))
+	err := rl.Allow("POST /api/posts/{postId}/comment", "alice", "10.0.0.1")
+	require.Error(t, err)
+	limitErr, ok := err.(*RateLimitError)
+	require.True(t, ok)
+	assert.Equal(t, "reactions", limitErr.Group)
+	assert.Equal(t, 1, limitErr.RetrySeconds())
+
+	// Per user, and per IP when anonymous
+	assert.NoError(t, rl.Allow("POST /api/posts/{postId}/like", "bob", "10.0.0.1"))
+	assert.NoError(t, rl.Allow("POST /api/posts/{postId}/like", "", "10.0.0.1"))
+
+	// The "ip" key ignores the user
+	route := "POST /api/collections/{collection}/auth-with-password"
+	assert.Equal(t, 5, drainRateLimit(rl, route, "alice", "10.0.0.2"))
+	assert.Error(t, rl.Allow(route, "bob", "10.0.0.2"))
+	assert.NoError(t, rl.Allow(route, "bob", "10.0.0.3"))
+
+	// No rule, not limited
+	assert.Equal(t, 1000, drainRateLimit(rl, "GET /", "alice", ""))
+
+	var reactions RateLimitStats
+	for _, stats := range rl.Stats() {
+		if stats.Group == "reactions" {
+			reactions = stats
+		}
+	}
+	assert.Equal(t, uint64(12), reactions.Allowed)
+	assert.Equal(t, uint64(2), reactions.Limited)
+	assert.Equal(t, 3, reactions.Buckets)
+}
+
+func TestRateLimitReloadKeepsUnchangedBuckets(t *testing.T) {
+	app, rl := newTestRateLimiter(t)
+
+	like := "POST /api/posts/{postId}/like"
+	location := "POST /api/location/update"
+	assert.Equal(t, 10, drainRateLimit(rl, like, "alice", ""))
+	assert.Equal(t, 10, drainRateLimit(rl, location, "alice", ""))
+
+	// Reloading doesn't refill the buckets of unchanged rules
+	setRateLimitRule(t, app, "location", 120, 3)
+	rl.Reload()
+	assert.Error(t, rl.Allow(like, "alice", ""))
+	assert.Equal(t, 3, drainRateLimit(rl, location, "alice", ""), "new limits")
+
+	setRateLimitRule(t, app, "reactions", 60, 2)
+	rl.Reload()
+	assert.Equal(t, 2, drainRateLimit(rl, like, "alice", ""))
+	assert.Error(t, rl.Allow(location, "alice", ""))
+}
+
+func TestRateLimitReloadWhileAllowing(t *testing.T) {
+	app, rl := newTestRateLimiter(t)
+	setRateLimitRule(t, app, "rpc", 60, 500)
+	rl.Reload()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				rl.AllowRPC("alice")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		rl.Reload()
+	}
+	wg.Wait()
+
+	// One bucket for the whole run, never refilled by the reloads
+	for _, stats := range rl.Stats() {
+		if stats.Group == "rpc" {
+			assert.LessOrEqual(t, stats.Allowed, uint64(505))
+			assert.Equal(t, uint64(800), stats.Allowed+stats.Limited)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// ==================== RESPOND VIA CHANNEL ====================
+
+// Any route called with ?respond_to=sse or ?respond_to=room answers 202 at
+// once, the request then runs in the background and its response is sent on
+// the user channel as a "response" message with the request ID:
+//
+//	{"type":"response","request_id":"...","data":{"status_code":200,"data":{...},"error":""}}
+//
+// respond_to=sse goes to the SSE and WebSocket sessions, respond_to=room to
+// the user rooms (DataChannel). X-Request-ID (or ?request_id=) sets the
+// request ID, X-Session-ID (or ?session=) limits delivery to one session.
+//
+// Deferred requests share the user's RPC queue with the DataChannel and
+// WebSocket requests: same concurrency, queue size and timeout, and a
+// "cancel" frame from the session given in X-Session-ID stops them.
+
+type deferredRequestKey struct{}
+
+// Check if the request is the background run of a deferred request
+func isDeferredRequest(c *core.RequestEvent) bool {
+	deferred, _ := c.Request.Context().Value(deferredRequestKey{}).(bool)
+	return deferred
+}
+
+// Router middleware, after auth and rate limiting
+func respondViaChannelMiddleware(c *core.RequestEvent) error {
+	query := c.Request.URL.Query()
+	respondTo := query.Get("respond_to")
+	if respondTo == "" || isDeferredRequest(c) {
+		return c.Next()
+	}
+
+	if respondTo != "sse" && respondTo != "room" {
+		return c.JSON(400, map[string]string{"error": "respond_to must be sse or room"})
+	}
+	if c.Auth == nil {
+		return c.JSON(401, map[string]string{"error": "respond_to requires authentication"})
+	}
+	if isStreamingEndpoint(c.Request.URL.Path) {
+		return c.JSON(400, map[string]string{"error": "streaming endpoints can't respond via the channel"})
+	}
+
+	// The body is gone once the 202 is sent. This runs before the body limit
+	// middleware, the replay goes through it again with the route's own limit.
+	body, err := io.ReadAll(http.MaxBytesReader(c.Response, c.Request.Body, apis.DefaultMaxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return c.JSON(413, map[string]string{"error": "request body too large"})
+		}
+		return c.JSON(400, map[string]string{"error": "invalid body"})
+	}
+
+	requestID := c.Request.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = query.Get("request_id")
+	}
+	if requestID == "" {
+		requestID = security.RandomString(15)
+	}
+
+	sessionID := c.Request.Header.Get("X-Session-ID")
+	if sessionID == "" {
+		sessionID = query.Get("session")
+	}
+
+	query.Del("respond_to")
+	target := *c.Request.URL
+	target.RawQuery = query.Encode()
+
+	deferred := c.Request.Clone(context.Background())
+	deferred.URL = &target
+	deferred.RequestURI = ""
+	deferred.Body = io.NopCloser(bytes.NewReader(body))
+	deferred.ContentLength = int64(len(body))
+
+	userID := c.Auth.Id
+	err = userChannelManager.rpc.Submit(userID, sessionID, requestID, 0, func(ctx context.Context) {
+		ctx = context.WithValue(ctx, deferredRequestKey{}, true)
+		deliverDeferredResponse(ctx, userID, sessionID, requestID, respondTo, deferred)
+	})
+	if err != nil {
+		return c.JSON(rpcErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(202, map[string]string{
+		"status":     "accepted",
+		"request_id": requestID,
+		"respond_to": respondTo,
+	})
+}
+
+// Run the request and send its response on the user channel
+func deliverDeferredResponse(ctx context.Context, userID, sessionID, requestID, respondTo string, req *http.Request) {
+	started := time.Now()
+	data, status, err := routerBridge.Replay(ctx, req)
+
+	response := map[string]interface{}{
+		"status_code": status,
+		"data":        data,
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+
+	if respondTo == "room" {
+		userChannelManager.sendToUserRooms(userID, sessionID, "response", response, requestID)
+	} else {
+		userChannelManager.sendToSessions(userID, sessionID, "response", response, requestID)
+	}
+
+	log.Printf("📨 Deferred %s %s for %s answered via %s (%d) in %s",
+		req.Method, req.URL.Path, userID, respondTo, status, time.Since(started).Round(time.Millisecond))
+}
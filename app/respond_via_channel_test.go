@@ -0,0 +1,145 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/hook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRespondVia(t *testing.T) (core.App, http.Handler) {
+	t.Helper()
+
+	app := newTestApp(t)
+
+	r, err := apis.NewRouter(app)
+	require.NoError(t, err)
+	r.Bind(&hook.Handler[*core.RequestEvent]{
+		Func:     respondViaChannelMiddleware,
+		Priority: apis.DefaultLoadAuthTokenMiddlewarePriority + 3,
+	})
+	r.POST("/api/echo", func(c *core.RequestEvent) error {
+		body, _ := io.ReadAll(c.Request.Body)
+		return c.JSON(200, map[string]interface{}{
+			"body":     string(body),
+			"query":    c.Request.URL.RawQuery,
+			"deferred": isDeferredRequest(c),
+		})
+	})
+	r.GET("/api/slow", func(c *core.RequestEvent) error {
+		<-c.Request.Context().Done()
+		return nil
+	})
+	mux, err := r.BuildMux()
+	require.NoError(t, err)
+
+	savedChannels, savedBridge := userChannelManager, routerBridge
+	userChannelManager = NewUserChannelManager(app)
+	routerBridge = NewRouterBridge(app)
+	routerBridge.SetHandler(mux)
+	t.Cleanup(func() {
+		userChannelManager, routerBridge = savedChannels, savedBridge
+	})
+
+	return app, mux
+}
+
+func respondVia(t *testing.T, mux http.Handler, token, method, target string, body []byte, headers map[string]string) (int, map[string]string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	mux.ServeHTTP(rec, req)
+
+	var response map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	return rec.Code, response
+}
+
+func TestRespondViaChannel(t *testing.T) {
+	app, mux := newTestRespondVia(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	token := authToken(t, app, alice)
+
+	s1 := userChannelManager.OpenSSESession(alice, "s1", "")
+	s2 := userChannelManager.OpenSSESession(alice, "s2", "")
+
+	status, response := respondVia(t, mux, token, http.MethodPost, "/api/echo?respond_to=sse&x=1", []byte(`{"a":1}`), map[string]string{
+		"X-Request-ID": "r1",
+		"X-Session-ID": "s1",
+	})
+	require.Equal(t, 202, status)
+	assert.Equal(t, "r1", response["request_id"])
+	assert.Equal(t, "sse", response["respond_to"])
+
+	msg := receiveSSE(t, s1)
+	assert.Equal(t, "response", msg.Type)
+	assert.Equal(t, "r1", msg.RequestID)
+	assert.Equal(t, 200, msg.Data["status_code"])
+	data, ok := msg.Data["data"].(map[string]interface{})
+	require.True(t, ok, "%+v", msg.Data)
+	assert.Equal(t, `{"a":1}`, data["body"])
+	assert.Equal(t, "x=1", data["query"], "respond_to removed")
+	assert.Equal(t, true, data["deferred"])
+	assertNoSSE(t, s2)
+
+	// Without X-Request-ID one is generated
+	status, response = respondVia(t, mux, token, http.MethodPost, "/api/echo?respond_to=sse", nil, nil)
+	require.Equal(t, 202, status)
+	assert.NotEmpty(t, response["request_id"])
+	assert.Equal(t, response["request_id"], receiveSSE(t, s1).RequestID)
+	assert.Equal(t, response["request_id"], receiveSSE(t, s2).RequestID)
+}
+
+func TestRespondViaChannelRejected(t *testing.T) {
+	app, mux := newTestRespondVia(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	token := authToken(t, app, alice)
+
+	status, _ := respondVia(t, mux, token, http.MethodPost, "/api/echo?respond_to=mail", nil, nil)
+	assert.Equal(t, 400, status)
+	status, _ = respondVia(t, mux, "", http.MethodPost, "/api/echo?respond_to=sse", nil, nil)
+	assert.Equal(t, 401, status)
+
+	// Read before the body limit middleware, still bounded
+	body := make([]byte, apis.DefaultMaxBodySize+1)
+	status, _ = respondVia(t, mux, token, http.MethodPost, "/api/echo?respond_to=sse", body, nil)
+	assert.Equal(t, 413, status)
+}
+
+func TestRespondViaChannelScheduled(t *testing.T) {
+	app, mux := newTestRespondVia(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	token := authToken(t, app, alice)
+	channel := userChannelManager.OpenSSESession(alice, "s1", "")
+
+	headers := map[string]string{"X-Request-ID": "r1", "X-Session-ID": "s1"}
+	status, _ := respondVia(t, mux, token, http.MethodGet, "/api/slow?respond_to=sse", nil, headers)
+	require.Equal(t, 202, status)
+
+	// Same request id still running
+	status, response := respondVia(t, mux, token, http.MethodGet, "/api/slow?respond_to=sse", nil, headers)
+	assert.Equal(t, 409, status)
+	assert.Equal(t, errRPCDuplicate.Error(), response["error"])
+
+	// Cancelled like the channel requests
+	require.True(t, userChannelManager.rpc.Cancel(alice, "s1", "r1"))
+	msg := receiveSSE(t, channel)
+	assert.Equal(t, "r1", msg.RequestID)
+	assert.Equal(t, statusClientClosedRequest, msg.Data["status_code"])
+	assert.Equal(t, "request cancelled", msg.Data["error"])
+}
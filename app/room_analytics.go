@@ -0,0 +1,526 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// ==================== ROOM ANALYTICS TYPES ====================
+
+// roomSession - Session d'un participant dans une room (join -> leave)
+type roomSession struct {
+	RoomID        string
+	UserID        string
+	ParticipantID string
+	JoinedAt      time.Time
+	recordID      string
+	mu            sync.Mutex
+}
+
+// RoomUsageReport - Rapport agrégé d'utilisation d'une room
+type RoomUsageReport struct {
+	RoomID             string                   `json:"room_id"`
+	RoomName           string                   `json:"room_name,omitempty"`
+	OwnerID            string                   `json:"owner_id,omitempty"`
+	From               time.Time                `json:"from"`
+	To                 time.Time                `json:"to"`
+	Sessions           int                      `json:"sessions"`
+	UniqueParticipants int                      `json:"unique_participants"`
+	TotalMinutes       float64                  `json:"total_minutes"`
+	AvgSessionMinutes  float64                  `json:"avg_session_minutes"`
+	PeakConcurrency    int                      `json:"peak_concurrency"`
+	Participants       []ParticipantUsageReport `json:"participants,omitempty"`
+}
+
+type ParticipantUsageReport struct {
+	UserID       string    `json:"user_id"`
+	Sessions     int       `json:"sessions"`
+	TotalMinutes float64   `json:"total_minutes"`
+	FirstJoin    time.Time `json:"first_join"`
+	LastLeave    time.Time `json:"last_leave"`
+}
+
+// ==================== ROOM ANALYTICS ====================
+
+type RoomAnalytics struct {
+	sessions map[string]*roomSession // participantID -> open session
+	app      core.App
+	mu       sync.Mutex
+}
+
+func NewRoomAnalytics(app core.App) *RoomAnalytics {
+	return &RoomAnalytics{
+		sessions: make(map[string]*roomSession),
+		app:      app,
+	}
+}
+
+// Record participant join
+func (ra *RoomAnalytics) StartSession(roomID string, p *Participant) {
+	session := &roomSession{
+		RoomID:        roomID,
+		UserID:        p.UserID,
+		ParticipantID: p.ID,
+		JoinedAt:      time.Now(),
+	}
+
+	ra.mu.Lock()
+	ra.sessions[p.ID] = session
+	ra.mu.Unlock()
+
+	session.mu.Lock()
+	go func() {
+		defer session.mu.Unlock()
+
+		collection, err := ra.app.FindCollectionByNameOrId("roomSessions")
+		if err != nil {
+			log.Printf("Error recording room session: %v", err)
+			return
+		}
+
+		record := core.NewRecord(collection)
+		record.Set("roomId", roomID)
+		record.Set("user", session.UserID)
+		record.Set("participantId", session.ParticipantID)
+		record.Set("joinedAt", session.JoinedAt)
+
+		if err := ra.app.Save(record); err != nil {
+			log.Printf("Error recording room session: %v", err)
+			return
+		}
+		session.recordID = record.Id
+	}()
+}
+
+// Record participant leave
+func (ra *RoomAnalytics) EndSession(participantID string) {
+	ra.mu.Lock()
+	session, exists := ra.sessions[participantID]
+	delete(ra.sessions, participantID)
+	ra.mu.Unlock()
+
+	if !exists {
+		return
+	}
+
+	leftAt := time.Now()
+
+	go func() {
+		// Wait for the join record to be written
+		session.mu.Lock()
+		defer session.mu.Unlock()
+
+		if session.recordID == "" {
+			return
+		}
+
+		record, err := ra.app.FindRecordById("roomSessions", session.recordID)
+		if err != nil {
+			log.Printf("Error closing room session: %v", err)
+			return
+		}
+
+		record.Set("leftAt", leftAt)
+		record.Set("duration", int(leftAt.Sub(session.JoinedAt).Seconds()))
+
+		if err := ra.app.Save(record); err != nil {
+			log.Printf("Error closing room session: %v", err)
+		}
+	}()
+}
+
+// Sessions loaded per query, and in total for one report
+const (
+	roomSessionsPageSize  = 1000
+	maxRoomReportSessions = 500000
+)
+
+var errRoomReportTooLarge = errors.New("too many sessions in range, narrow the report period")
+
+// Load sessions overlapping [from, to], page by page
+func (ra *RoomAnalytics) findSessions(roomID string, from, to time.Time) ([]*core.Record, error) {
+	fromDT, _ := types.ParseDateTime(from)
+	toDT, _ := types.ParseDateTime(to)
+
+	filter := "joinedAt <= {:to} && (leftAt = '' || leftAt >= {:from})"
+	params := dbx.Params{"from": fromDT.String(), "to": toDT.String()}
+	if roomID != "" {
+		filter = "roomId = {:room} && " + filter
+		params["room"] = roomID
+	}
+
+	sessions := []*core.Record{}
+	for {
+		page, err := ra.app.FindRecordsByFilter("roomSessions", filter, "joinedAt,id", roomSessionsPageSize, len(sessions), params)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, page...)
+
+		if len(page) < roomSessionsPageSize {
+			return sessions, nil
+		}
+		if len(sessions) >= maxRoomReportSessions {
+			return nil, errRoomReportTooLarge
+		}
+	}
+}
+
+// Close the sessions left open by a crash or a restart (no leftAt). The
+// server stopped after the last recorded join or leave, they end there.
+func (ra *RoomAnalytics) CloseOrphanedSessions() error {
+	var lastSeen struct {
+		Joined string `db:"joined"`
+		Left   string `db:"left"`
+	}
+	err := ra.app.DB().
+		NewQuery("SELECT COALESCE(MAX(joinedAt), '') AS joined, COALESCE(MAX(leftAt), '') AS left FROM roomSessions").
+		One(&lastSeen)
+	if err != nil {
+		return err
+	}
+
+	end, _ := types.ParseDateTime(lastSeen.Joined)
+	if left, _ := types.ParseDateTime(lastSeen.Left); left.Time().After(end.Time()) {
+		end = left
+	}
+
+	closed := 0
+	for {
+		orphans, err := ra.app.FindRecordsByFilter("roomSessions", "leftAt = ''", "joinedAt", roomSessionsPageSize, 0)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range orphans {
+			joinedAt := record.GetDateTime("joinedAt").Time()
+			leftAt := end.Time()
+			if leftAt.Before(joinedAt) {
+				leftAt = joinedAt
+			}
+
+			record.Set("leftAt", leftAt)
+			record.Set("duration", int(leftAt.Sub(joinedAt).Seconds()))
+			if err := ra.app.Save(record); err != nil {
+				return err
+			}
+			closed++
+		}
+
+		if len(orphans) < roomSessionsPageSize {
+			if closed > 0 {
+				log.Printf("Closed %d orphaned room sessions", closed)
+			}
+			return nil
+		}
+	}
+}
+
+// Build usage report of one room
+func (ra *RoomAnalytics) RoomReport(roomID string, from, to time.Time) (*RoomUsageReport, error) {
+	sessions, err := ra.findSessions(roomID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := buildRoomUsageReport(roomID, sessions, from, to, true)
+	ra.attachRoomInfo(report)
+
+	return report, nil
+}
+
+// Build usage reports of all rooms
+func (ra *RoomAnalytics) GlobalReport(from, to time.Time) ([]*RoomUsageReport, error) {
+	sessions, err := ra.findSessions("", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	byRoom := make(map[string][]*core.Record)
+	for _, s := range sessions {
+		roomID := s.GetString("roomId")
+		byRoom[roomID] = append(byRoom[roomID], s)
+	}
+
+	reports := make([]*RoomUsageReport, 0, len(byRoom))
+	for roomID, roomSessions := range byRoom {
+		report := buildRoomUsageReport(roomID, roomSessions, from, to, false)
+		ra.attachRoomInfo(report)
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].TotalMinutes > reports[j].TotalMinutes
+	})
+
+	return reports, nil
+}
+
+func (ra *RoomAnalytics) attachRoomInfo(report *RoomUsageReport) {
+	room, err := ra.app.FindRecordById("rooms", report.RoomID)
+	if err != nil {
+		return
+	}
+	report.RoomName = room.GetString("name")
+	report.OwnerID = room.GetString("owner")
+}
+
+func buildRoomUsageReport(roomID string, sessions []*core.Record, from, to time.Time, withParticipants bool) *RoomUsageReport {
+	report := &RoomUsageReport{
+		RoomID: roomID,
+		From:   from,
+		To:     to,
+	}
+
+	type edge struct {
+		at    time.Time
+		delta int
+	}
+	edges := make([]edge, 0, len(sessions)*2)
+	participants := make(map[string]*ParticipantUsageReport)
+	now := time.Now()
+
+	for _, s := range sessions {
+		joinedAt := s.GetDateTime("joinedAt").Time()
+		leftAt := s.GetDateTime("leftAt").Time()
+		if leftAt.IsZero() {
+			leftAt = now // session still open
+		}
+
+		// Clip to the report window
+		start, end := joinedAt, leftAt
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.Before(start) {
+			continue
+		}
+
+		minutes := end.Sub(start).Minutes()
+		report.Sessions++
+		report.TotalMinutes += minutes
+		edges = append(edges, edge{start, 1}, edge{end, -1})
+
+		userID := s.GetString("user")
+		pr, exists := participants[userID]
+		if !exists {
+			pr = &ParticipantUsageReport{UserID: userID, FirstJoin: joinedAt}
+			participants[userID] = pr
+		}
+		pr.Sessions++
+		pr.TotalMinutes += minutes
+		if joinedAt.Before(pr.FirstJoin) {
+			pr.FirstJoin = joinedAt
+		}
+		if leftAt.After(pr.LastLeave) {
+			pr.LastLeave = leftAt
+		}
+	}
+
+	// Peak concurrency (sweep line, leaves before joins at the same instant)
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at.Equal(edges[j].at) {
+			return edges[i].delta < edges[j].delta
+		}
+		return edges[i].at.Before(edges[j].at)
+	})
+	current := 0
+	for _, e := range edges {
+		current += e.delta
+		if current > report.PeakConcurrency {
+			report.PeakConcurrency = current
+		}
+	}
+
+	report.UniqueParticipants = len(participants)
+	if report.Sessions > 0 {
+		report.AvgSessionMinutes = report.TotalMinutes / float64(report.Sessions)
+	}
+
+	if withParticipants {
+		report.Participants = make([]ParticipantUsageReport, 0, len(participants))
+		for _, pr := range participants {
+			report.Participants = append(report.Participants, *pr)
+		}
+		sort.Slice(report.Participants, func(i, j int) bool {
+			return report.Participants[i].TotalMinutes > report.Participants[j].TotalMinutes
+		})
+	}
+
+	return report
+}
+
+// ==================== HTTP HANDLERS ====================
+
+// Usage report of one room (owner or superuser)
+func handleGetRoomAnalytics(c *core.RequestEvent) error {
+	roomID := c.Request.PathValue("roomId")
+
+	if !c.HasSuperuserAuth() {
+		userID := c.Get("userID").(string)
+		room, err := c.App.FindRecordById("rooms", roomID)
+		if err != nil {
+			return c.JSON(404, map[string]string{"error": "room not found"})
+		}
+		if room.GetString("owner") != userID {
+			return c.JSON(403, map[string]string{"error": "only owner can view analytics"})
+		}
+	}
+
+	from, to, err := parseReportRange(c)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	report, err := roomAnalytics.RoomReport(roomID, from, to)
+	if errors.Is(err, errRoomReportTooLarge) {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	if c.Request.URL.Query().Get("format") == "csv" {
+		rows := [][]string{{"room_id", "user_id", "sessions", "total_minutes", "first_join", "last_leave"}}
+		for _, p := range report.Participants {
+			rows = append(rows, []string{
+				report.RoomID,
+				p.UserID,
+				strconv.Itoa(p.Sessions),
+				strconv.FormatFloat(p.TotalMinutes, 'f', 2, 64),
+				p.FirstJoin.UTC().Format(time.RFC3339),
+				p.LastLeave.UTC().Format(time.RFC3339),
+			})
+		}
+		return sendCSV(c, fmt.Sprintf("room-%s-usage.csv", roomID), rows)
+	}
+
+	return c.JSON(200, report)
+}
+
+// Usage report of all rooms (superuser)
+func handleGetGlobalRoomAnalytics(c *core.RequestEvent) error {
+	from, to, err := parseReportRange(c)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	reports, err := roomAnalytics.GlobalReport(from, to)
+	if errors.Is(err, errRoomReportTooLarge) {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	if c.Request.URL.Query().Get("format") == "csv" {
+		rows := [][]string{{"room_id", "room_name", "owner_id", "sessions", "unique_participants", "total_minutes", "avg_session_minutes", "peak_concurrency"}}
+		for _, r := range reports {
+			rows = append(rows, []string{
+				r.RoomID,
+				r.RoomName,
+				r.OwnerID,
+				strconv.Itoa(r.Sessions),
+				strconv.Itoa(r.UniqueParticipants),
+				strconv.FormatFloat(r.TotalMinutes, 'f', 2, 64),
+				strconv.FormatFloat(r.AvgSessionMinutes, 'f', 2, 64),
+				strconv.Itoa(r.PeakConcurrency),
+			})
+		}
+		return sendCSV(c, "rooms-usage.csv", rows)
+	}
+
+	totalMinutes := 0.0
+	for _, r := range reports {
+		totalMinutes += r.TotalMinutes
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"from":          from,
+		"to":            to,
+		"rooms":         reports,
+		"count":         len(reports),
+		"total_minutes": totalMinutes,
+	})
+}
+
+// Parse ?from=&to= (RFC3339 or YYYY-MM-DD), defaults to the last 30 days
+func parseReportRange(c *core.RequestEvent) (time.Time, time.Time, error) {
+	query := c.Request.URL.Query()
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+
+	parse := func(value string) (time.Time, error) {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02", value)
+	}
+
+	if v := query.Get("from"); v != "" {
+		t, err := parse(v)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from date")
+		}
+		from = t
+	}
+	if v := query.Get("to"); v != "" {
+		t, err := parse(v)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to date")
+		}
+		to = t
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to must be after from")
+	}
+
+	return from, to, nil
+}
+
+func sendCSV(c *core.RequestEvent, filename string, rows [][]string) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	c.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Blob(200, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// ==================== SETUP COLLECTIONS ====================
+
+func SetupRoomAnalyticsCollections(app core.App) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		roomSessions := core.NewBaseCollection("roomSessions")
+		roomSessions.Fields.Add(
+			&core.TextField{Name: "roomId", Required: true},
+			&core.RelationField{Name: "user", CollectionId: "_pb_users_auth_", MaxSelect: 1},
+			&core.TextField{Name: "participantId"},
+			&core.DateField{Name: "joinedAt", Required: true},
+			&core.DateField{Name: "leftAt"},
+			&core.NumberField{Name: "duration"},
+		)
+		roomSessions.Indexes = []string{
+			"CREATE INDEX idx_room_sessions_room ON roomSessions (roomId, joinedAt)",
+			"CREATE INDEX idx_room_sessions_user ON roomSessions (user)",
+		}
+
+		return txApp.Save(roomSessions)
+	})
+}
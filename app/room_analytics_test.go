@@ -0,0 +1,139 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRoomSession(t *testing.T, app core.App, roomID, userID string, joinedAt, leftAt time.Time) *core.Record {
+	t.Helper()
+
+	collection, err := app.FindCollectionByNameOrId("roomSessions")
+	require.NoError(t, err)
+
+	record := core.NewRecord(collection)
+	record.Set("roomId", roomID)
+	record.Set("user", userID)
+	record.Set("joinedAt", joinedAt)
+	if !leftAt.IsZero() {
+		record.Set("leftAt", leftAt)
+	}
+	require.NoError(t, app.Save(record))
+
+	return record
+}
+
+func TestRoomUsageReportPeakConcurrency(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupRoomAnalyticsCollections(app))
+
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+	carol := createTestUser(t, app, "carol@example.com")
+
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+	sessions := []*core.Record{
+		newTestRoomSession(t, app, "r1", alice, at(0), at(30)),
+		newTestRoomSession(t, app, "r1", bob, at(10), at(20)),
+		newTestRoomSession(t, app, "r1", carol, at(15), at(40)),
+		// Joins when bob leaves: leaves count first, the peak stays 3
+		newTestRoomSession(t, app, "r1", bob, at(20), at(25)),
+	}
+
+	report := buildRoomUsageReport("r1", sessions, at(0), at(60), true)
+
+	assert.Equal(t, 3, report.PeakConcurrency)
+	assert.Equal(t, 4, report.Sessions)
+	assert.Equal(t, 3, report.UniqueParticipants)
+	assert.InDelta(t, 30+10+25+5, report.TotalMinutes, 0.001)
+	require.Len(t, report.Participants, 3)
+	assert.Equal(t, alice, report.Participants[0].UserID)
+}
+
+func TestRoomUsageReportClipsToRange(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupRoomAnalyticsCollections(app))
+
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+	sessions := []*core.Record{
+		// Starts before the range, ends inside
+		newTestRoomSession(t, app, "r1", alice, at(-30), at(10)),
+		// Starts inside, ends after the range
+		newTestRoomSession(t, app, "r1", bob, at(50), at(90)),
+		// Entirely before the range
+		newTestRoomSession(t, app, "r1", bob, at(-60), at(-40)),
+	}
+
+	report := buildRoomUsageReport("r1", sessions, at(0), at(60), true)
+
+	assert.Equal(t, 2, report.Sessions)
+	assert.InDelta(t, 10+10, report.TotalMinutes, 0.001)
+	assert.Equal(t, 1, report.PeakConcurrency)
+	assert.InDelta(t, 10, report.AvgSessionMinutes, 0.001)
+}
+
+func TestRoomReportPagesThroughSessions(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupRoomAnalyticsCollections(app))
+
+	alice := createTestUser(t, app, "alice@example.com")
+	ra := NewRoomAnalytics(app)
+
+	base := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	count := roomSessionsPageSize + 5
+	for i := 0; i < count; i++ {
+		joinedAt := base.Add(time.Duration(i) * time.Second)
+		newTestRoomSession(t, app, "r1", alice, joinedAt, joinedAt.Add(time.Second))
+	}
+	newTestRoomSession(t, app, "r2", alice, base, base.Add(time.Minute))
+
+	report, err := ra.RoomReport("r1", base.Add(-time.Hour), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, count, report.Sessions)
+
+	// The room id is bound, not spliced in the filter
+	report, err = ra.RoomReport("r1' || roomId != '", base.Add(-time.Hour), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Sessions)
+}
+
+func TestCloseOrphanedSessions(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupRoomAnalyticsCollections(app))
+
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	orphan := newTestRoomSession(t, app, "r1", alice, base, time.Time{})
+	newTestRoomSession(t, app, "r1", bob, base.Add(5*time.Minute), base.Add(20*time.Minute))
+	// Open after the last recorded event, closed where it started
+	late := newTestRoomSession(t, app, "r2", bob, base.Add(30*time.Minute), time.Time{})
+
+	require.NoError(t, NewRoomAnalytics(app).CloseOrphanedSessions())
+
+	orphan, err := app.FindRecordById("roomSessions", orphan.Id)
+	require.NoError(t, err)
+	assert.True(t, orphan.GetDateTime("leftAt").Time().Equal(base.Add(30*time.Minute)))
+	assert.Equal(t, 30*60, orphan.GetInt("duration"))
+
+	late, err = app.FindRecordById("roomSessions", late.Id)
+	require.NoError(t, err)
+	assert.True(t, late.GetDateTime("leftAt").Time().Equal(base.Add(30*time.Minute)))
+	assert.Equal(t, 0, late.GetInt("duration"))
+
+	open, err := app.FindRecordsByFilter("roomSessions", "leftAt = ''", "", 0, 0)
+	require.NoError(t, err)
+	assert.Empty(t, open)
+}
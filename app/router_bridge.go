@@ -0,0 +1,463 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ==================== LEGACY ENDPOINTS ====================
+
+// legacyEndpoint - Ancien endpoint du DataChannel redirigé vers une route HTTP.
+// Les paramètres {name} de la route sont lus dans le body.
+type legacyEndpoint struct {
+	Method        string // "" matches any method, the request is sent as POST
+	Endpoint      string
+	Target        string
+	Query         map[string]string // default query params
+	QueryFromBody []string          // body fields moved to the query
+	Body          map[string]interface{}
+	Adapt         func(userID string, data map[string]interface{}) map[string]interface{}
+}
+
+var legacyEndpoints = []legacyEndpoint{
+	{
+		Method:   http.MethodGet,
+		Endpoint: "/posts",
+		Target:   "/api/collections/posts/records",
+		Query:    map[string]string{"filter": "isPublic = true", "perPage": "20"},
+		Adapt:    adaptLegacyList("posts"),
+	},
+	{
+		Method:   http.MethodPost,
+		Endpoint: "/posts",
+		Target:   "/api/collections/posts/records",
+		Body:     map[string]interface{}{"type": "html", "isPublic": true, "likesCount": 0, "commentsCount": 0},
+		Adapt: func(userID string, data map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"success": true, "post_id": data["id"], "post": data}
+		},
+	},
+	{Endpoint: "/posts/like", Target: "/api/posts/{post_id}/like", QueryFromBody: []string{"reaction"}},
+	{Endpoint: "/posts/comment", Target: "/api/posts/{post_id}/comment"},
+	{Endpoint: "/articles/buy", Target: "/api/articles/{article_id}/buy"},
+	{
+		Method:   http.MethodGet,
+		Endpoint: "/articles",
+		Target:   "/api/collections/articles/records",
+		Query:    map[string]string{"filter": "quantite > 0", "perPage": "20"},
+		Adapt:    adaptLegacyList("articles"),
+	},
+	{Endpoint: "/rooms/join", Target: "/api/rooms/{room_id}/join-request"},
+	{Endpoint: "/rooms/leave", Target: "/api/rooms/{room_id}/leave"},
+}
+
+// Old list responses were {<name>: [...], count}
+func adaptLegacyList(name string) func(string, map[string]interface{}) map[string]interface{} {
+	return func(userID string, data map[string]interface{}) map[string]interface{} {
+		items, _ := data["items"].([]interface{})
+		data[name] = items
+		data["count"] = len(items)
+		return data
+	}
+}
+
+func findLegacyEndpoint(method, endpoint string) (legacyEndpoint, bool) {
+	for _, legacy := range legacyEndpoints {
+		if legacy.Endpoint == endpoint && (legacy.Method == "" || legacy.Method == method) {
+			return legacy, true
+		}
+	}
+	return legacyEndpoint{}, false
+}
+
+// Endpoints that stream their response, they can't be answered as a single message
+var streamingEndpoints = []string{"/api/user/sse", "/api/events/", "/api/ws", "/api/realtime"}
+
+func isStreamingEndpoint(path string) bool {
+	for _, prefix := range streamingEndpoints {
+		if path == prefix || (strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ==================== ROUTER BRIDGE ====================
+
+// RouterBridge - Exécute les APIRequest du DataChannel et du WebSocket sur
+// le router HTTP de PocketBase, authentifiées comme l'utilisateur du canal.
+// Les règles des collections et les middlewares s'appliquent donc comme en HTTP.
+type RouterBridge struct {
+	app     core.App
+	handler http.Handler
+	mu      sync.RWMutex
+}
+
+func NewRouterBridge(app core.App) *RouterBridge {
+	return &RouterBridge{app: app}
+}
+
+// Set once the router is built (OnServe)
+func (rb *RouterBridge) SetHandler(handler http.Handler) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.handler = handler
+}
+
+// Run a request as the given user and decode the JSON response.
+// remoteAddr is the address of the client on its transport, the handlers
+// see it as the request's RemoteAddr (rate limits, logs)
+func (rb *RouterBridge) Dispatch(userID, remoteAddr string, req APIRequest) (map[string]interface{}, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRPCLimits.Timeout)
+	defer cancel()
+
+	data, status, _, err := rb.DispatchStream(ctx, userID, remoteAddr, req, nil)
+	return data, status, err
+}
+
+// Same as Dispatch, but each Flush of the handler is passed to onChunk.
+// When the handler streamed, streamed is true and data is nil.
+// The handler gets ctx as its request context, when ctx ends first the
+// response is a 504 (deadline) or a 499 (cancelled).
+func (rb *RouterBridge) DispatchStream(ctx context.Context, userID, remoteAddr string, req APIRequest, onChunk func([]byte)) (data map[string]interface{}, status int, streamed bool, err error) {
+	if rb == nil {
+		return nil, 503, false, fmt.Errorf("router not ready")
+	}
+
+	rb.mu.RLock()
+	handler := rb.handler
+	rb.mu.RUnlock()
+
+	if handler == nil {
+		return nil, 503, false, fmt.Errorf("router not ready")
+	}
+
+	if err := ctx.Err(); err != nil {
+		status, err := contextErrorStatus(err)
+		return nil, status, false, err
+	}
+
+	httpReq, adapt, err := rb.buildRequest(ctx, userID, remoteAddr, req)
+	if err != nil {
+		return nil, 400, false, err
+	}
+
+	return rb.serve(ctx, handler, httpReq, func(data map[string]interface{}) map[string]interface{} {
+		if adapt != nil {
+			return adapt(userID, data)
+		}
+		return data
+	}, onChunk)
+}
+
+// Run an HTTP request built elsewhere (deferred responses), ctx bounds it
+func (rb *RouterBridge) Replay(ctx context.Context, httpReq *http.Request) (map[string]interface{}, int, error) {
+	if rb == nil {
+		return nil, 503, fmt.Errorf("router not ready")
+	}
+
+	rb.mu.RLock()
+	handler := rb.handler
+	rb.mu.RUnlock()
+
+	if handler == nil {
+		return nil, 503, fmt.Errorf("router not ready")
+	}
+
+	data, status, _, err := rb.serve(ctx, handler, httpReq.WithContext(ctx), nil, nil)
+	return data, status, err
+}
+
+func (rb *RouterBridge) serve(ctx context.Context, handler http.Handler, httpReq *http.Request, adapt func(map[string]interface{}) map[string]interface{}, onChunk func([]byte)) (data map[string]interface{}, status int, streamed bool, err error) {
+	writer := newBridgeWriter(onChunk)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(writer, httpReq)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// The handler may keep running, its output is discarded. Its RPC
+		// slot stays taken until it returns.
+		holdRPCSlot(ctx, done)
+		streamed := writer.close()
+		status, err := contextErrorStatus(ctx.Err())
+		return nil, status, streamed, err
+	}
+
+	status = writer.status
+	if status == 0 {
+		status = 200
+	}
+
+	if writer.streamed {
+		// Whatever was written after the last flush
+		writer.Flush()
+		if status >= 400 {
+			return nil, status, true, fmt.Errorf("%s", http.StatusText(status))
+		}
+		return nil, status, true, nil
+	}
+
+	data = decodeBridgeResponse(writer.body.Bytes(), writer.header.Get("Content-Type"))
+
+	if status >= 400 {
+		message, _ := data["message"].(string)
+		if message == "" {
+			message, _ = data["error"].(string)
+		}
+		if message == "" {
+			message = http.StatusText(status)
+		}
+		return data, status, false, fmt.Errorf("%s", message)
+	}
+
+	if adapt != nil {
+		data = adapt(data)
+	}
+
+	return data, status, false, nil
+}
+
+// bridgeWriter - ResponseWriter en mémoire, les flushs deviennent des chunks
+type bridgeWriter struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	onChunk  func([]byte)
+	streamed bool
+	closed   bool
+	mu       sync.Mutex
+}
+
+func newBridgeWriter(onChunk func([]byte)) *bridgeWriter {
+	return &bridgeWriter{header: http.Header{}, onChunk: onChunk}
+}
+
+func (w *bridgeWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bridgeWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bridgeWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = 200
+	}
+	return w.body.Write(b)
+}
+
+func (w *bridgeWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.onChunk == nil || w.closed {
+		return
+	}
+	w.streamed = true
+	if w.body.Len() == 0 {
+		return
+	}
+
+	chunk := make([]byte, w.body.Len())
+	copy(chunk, w.body.Bytes())
+	w.body.Reset()
+	w.onChunk(chunk)
+}
+
+// Discard the later writes, returns whether chunks were already sent
+func (w *bridgeWriter) close() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return w.streamed
+}
+
+func (rb *RouterBridge) buildRequest(ctx context.Context, userID, remoteAddr string, req APIRequest) (*http.Request, func(string, map[string]interface{}) map[string]interface{}, error) {
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+		if len(req.Body) > 0 {
+			method = http.MethodPost
+		}
+	}
+
+	path := req.Endpoint
+	query := url.Values{}
+	body := make(map[string]interface{}, len(req.Body))
+	for key, value := range req.Body {
+		body[key] = value
+	}
+
+	// Endpoint with an inline query string
+	if i := strings.Index(path, "?"); i >= 0 {
+		inline, err := url.ParseQuery(path[i+1:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid query: %w", err)
+		}
+		query = inline
+		path = path[:i]
+	}
+	for key, value := range req.Query {
+		query.Set(key, value)
+	}
+
+	var adapt func(string, map[string]interface{}) map[string]interface{}
+
+	if legacy, ok := findLegacyEndpoint(method, path); ok {
+		if legacy.Method == "" {
+			method = http.MethodPost
+		}
+
+		target, err := fillRouteParams(legacy.Target, body)
+		if err != nil {
+			return nil, nil, err
+		}
+		path = target
+
+		for key, value := range legacy.Query {
+			if query.Get(key) == "" {
+				query.Set(key, value)
+			}
+		}
+		for _, key := range legacy.QueryFromBody {
+			if value := getString(body, key, ""); value != "" {
+				query.Set(key, value)
+			}
+			delete(body, key)
+		}
+		for key, value := range legacy.Body {
+			if _, exists := body[key]; !exists {
+				body[key] = value
+			}
+		}
+		if strings.HasPrefix(legacy.Target, "/api/collections/") && method == http.MethodPost {
+			body["user"] = userID
+		}
+
+		adapt = legacy.Adapt
+	}
+
+	if !strings.HasPrefix(path, "/api/") {
+		path = "/api" + path
+	}
+
+	if isStreamingEndpoint(path) {
+		return nil, nil, fmt.Errorf("streaming endpoint not supported over the channel: %s", path)
+	}
+
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader *bytes.Reader
+	if len(body) > 0 && method != http.MethodGet && method != http.MethodHead {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader = bytes.NewReader(payload)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	httpReq.RemoteAddr = remoteAddr
+	if httpReq.RemoteAddr == "" {
+		httpReq.RemoteAddr = "127.0.0.1:0"
+	}
+	if reader.Len() > 0 {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	token, err := rb.authToken(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpReq.Header.Set("Authorization", token)
+
+	return httpReq, adapt, nil
+}
+
+// RemoteAddr given to the bridged requests of a transport opened by c.
+// The client IP is resolved once here, the bridged requests have no proxy headers.
+func transportRemoteAddr(c *core.RequestEvent) string {
+	return net.JoinHostPort(c.RealIP(), "0")
+}
+
+// Auth token of the channel owner
+func (rb *RouterBridge) authToken(userID string) (string, error) {
+	user, err := rb.app.FindRecordById("users", userID)
+	if err != nil {
+		return "", fmt.Errorf("user not found")
+	}
+	return user.NewAuthToken()
+}
+
+// Replace the {name} segments of a route with the body fields
+func fillRouteParams(route string, body map[string]interface{}) (string, error) {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		name := strings.Trim(segment, "{}")
+		value := getString(body, name, "")
+		if value == "" {
+			return "", fmt.Errorf("%s required", name)
+		}
+		segments[i] = url.PathEscape(value)
+		delete(body, name)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// JSON objects as is, arrays as {"items": [...]}, anything else as {"body": "..."}
+func decodeBridgeResponse(body []byte, contentType string) map[string]interface{} {
+	raw := bytes.TrimSpace(body)
+	if len(raw) == 0 {
+		return map[string]interface{}{}
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return map[string]interface{}{
+			"body":         string(raw),
+			"content_type": contentType,
+		}
+	}
+
+	switch value := decoded.(type) {
+	case map[string]interface{}:
+		return value
+	case []interface{}:
+		return map[string]interface{}{"items": value}
+	}
+	return map[string]interface{}{"value": decoded}
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRouterBridge(t *testing.T) (core.App, *RouterBridge) {
+	t.Helper()
+
+	app := newTestApp(t)
+
+	r, err := apis.NewRouter(app)
+	require.NoError(t, err)
+	r.GET("/api/whoami", func(c *core.RequestEvent) error {
+		return c.JSON(200, map[string]interface{}{
+			"remote_addr": c.Request.RemoteAddr,
+			"ip":          c.RealIP(),
+			"auth":        c.Auth != nil,
+		})
+	})
+	mux, err := r.BuildMux()
+	require.NoError(t, err)
+
+	bridge := NewRouterBridge(app)
+	bridge.SetHandler(mux)
+	return app, bridge
+}
+
+func TestRouterBridgeRemoteAddr(t *testing.T) {
+	app, bridge := newTestRouterBridge(t)
+	alice := createTestUser(t, app, "alice@example.com")
+
+	data, status, err := bridge.Dispatch(alice, "203.0.113.7:0", APIRequest{Endpoint: "/whoami"})
+	require.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "203.0.113.7:0", data["remote_addr"])
+	assert.Equal(t, "203.0.113.7", data["ip"])
+	assert.Equal(t, true, data["auth"])
+
+	// Unknown transport address
+	data, _, err = bridge.Dispatch(alice, "", APIRequest{Endpoint: "/whoami"})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:0", data["remote_addr"])
+}
+
+func TestTransportRemoteAddr(t *testing.T) {
+	app := newTestApp(t)
+
+	for remote, expected := range map[string]string{
+		"198.51.100.4:5555": "198.51.100.4:0",
+		"[2001:db8::1]:443": "[2001:0db8:0000:0000:0000:0000:0000:0001]:0", // as RealIP expands it
+	} {
+		req := httptest.NewRequest("GET", "/api/ws", nil)
+		req.RemoteAddr = remote
+		c := &core.RequestEvent{App: app, Event: router.Event{Request: req}}
+		assert.Equal(t, expected, transportRemoteAddr(c))
+	}
+}
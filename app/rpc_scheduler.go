@@ -0,0 +1,210 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ==================== RPC SCHEDULER ====================
+
+// RPCLimits - Limites des requêtes API reçues sur les canaux utilisateur
+// (DataChannel, WebSocket)
+type RPCLimits struct {
+	Concurrency int           // requests run at the same time per user
+	QueueSize   int           // requests waiting per user, beyond that 429
+	Timeout     time.Duration // server deadline, 504 past it
+}
+
+// Defaults, overridden by the --rpcConcurrency, --rpcQueueSize and --rpcTimeout flags
+var DefaultRPCLimits = RPCLimits{
+	Concurrency: 4,
+	QueueSize:   32,
+	Timeout:     30 * time.Second,
+}
+
+// Status sent for a request cancelled by the client
+const statusClientClosedRequest = 499
+
+var (
+	errRPCQueueFull = errors.New("too many concurrent requests")
+	errRPCDuplicate = errors.New("request_id already in progress")
+)
+
+// Status of a request refused by Submit
+func rpcErrorStatus(err error) int {
+	if errors.Is(err, errRPCDuplicate) {
+		return 409
+	}
+	return 429
+}
+
+type rpcJob struct {
+	key    string
+	ctx    context.Context
+	cancel context.CancelFunc
+	run    func(ctx context.Context)
+	held   []<-chan struct{} // work still running after run returned
+}
+
+type rpcJobKey struct{}
+
+// Keep the worker slot of the job running ctx until done is closed, for a
+// handler that outlives its answer (504, 499). Called from the job's run.
+func holdRPCSlot(ctx context.Context, done <-chan struct{}) {
+	if job, ok := ctx.Value(rpcJobKey{}).(*rpcJob); ok {
+		job.held = append(job.held, done)
+	}
+}
+
+// userRPCPool - File d'attente et workers d'un utilisateur
+type userRPCPool struct {
+	queue    chan *rpcJob
+	workers  int
+	inflight map[string]context.CancelFunc // session/request_id -> cancel
+}
+
+// RPCScheduler - Pool de workers par utilisateur, avec annulation et deadlines
+type RPCScheduler struct {
+	limits RPCLimits
+	pools  map[string]*userRPCPool
+	mu     sync.Mutex
+}
+
+func NewRPCScheduler(limits RPCLimits) *RPCScheduler {
+	if limits.Concurrency <= 0 {
+		limits.Concurrency = DefaultRPCLimits.Concurrency
+	}
+	if limits.QueueSize <= 0 {
+		limits.QueueSize = DefaultRPCLimits.QueueSize
+	}
+	if limits.Timeout <= 0 {
+		limits.Timeout = DefaultRPCLimits.Timeout
+	}
+
+	return &RPCScheduler{
+		limits: limits,
+		pools:  make(map[string]*userRPCPool),
+	}
+}
+
+func rpcKey(sessionID, requestID string) string {
+	return sessionID + "/" + requestID
+}
+
+// Queue a request of a user session. The context given to run expires after
+// the server timeout, or timeoutMs when the client asks for less.
+func (s *RPCScheduler) Submit(userID, sessionID, requestID string, timeoutMs int64, run func(ctx context.Context)) error {
+	timeout := s.limits.Timeout
+	if requested := time.Duration(timeoutMs) * time.Millisecond; requested > 0 && requested < timeout {
+		timeout = requested
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	job := &rpcJob{
+		key:    rpcKey(sessionID, requestID),
+		cancel: cancel,
+		run:    run,
+	}
+	job.ctx = context.WithValue(ctx, rpcJobKey{}, job)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, exists := s.pools[userID]
+	if !exists {
+		pool = &userRPCPool{
+			// The workers may not have picked up the running jobs yet
+			queue:    make(chan *rpcJob, s.limits.QueueSize+s.limits.Concurrency),
+			inflight: make(map[string]context.CancelFunc),
+		}
+		s.pools[userID] = pool
+	}
+
+	// The first one could no longer be cancelled
+	if _, exists := pool.inflight[job.key]; exists && requestID != "" {
+		cancel()
+		return errRPCDuplicate
+	}
+
+	select {
+	case pool.queue <- job:
+	default:
+		cancel()
+		return errRPCQueueFull
+	}
+
+	if requestID != "" {
+		pool.inflight[job.key] = cancel
+	}
+
+	if pool.workers < s.limits.Concurrency {
+		pool.workers++
+		go s.work(userID, pool)
+	}
+
+	return nil
+}
+
+// Worker: runs the queued jobs, exits when the queue is empty
+func (s *RPCScheduler) work(userID string, pool *userRPCPool) {
+	for {
+		s.mu.Lock()
+		var job *rpcJob
+		select {
+		case job = <-pool.queue:
+		default:
+		}
+		if job == nil {
+			pool.workers--
+			if pool.workers == 0 && len(pool.inflight) == 0 {
+				delete(s.pools, userID)
+			}
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		// Cancelled or expired while queued: run sees the done context
+		job.run(job.ctx)
+		job.cancel()
+
+		s.mu.Lock()
+		delete(pool.inflight, job.key)
+		s.mu.Unlock()
+
+		// Answered, but the handler still counts against the concurrency
+		for _, done := range job.held {
+			<-done
+		}
+	}
+}
+
+// Cancel a queued or running request, returns false if it is unknown
+func (s *RPCScheduler) Cancel(userID, sessionID, requestID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, exists := s.pools[userID]
+	if !exists {
+		return false
+	}
+
+	cancel, exists := pool.inflight[rpcKey(sessionID, requestID)]
+	if !exists {
+		return false
+	}
+
+	cancel()
+	return true
+}
+
+// Status and error of a request stopped by its context
+func contextErrorStatus(err error) (int, error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return 504, fmt.Errorf("request timed out")
+	}
+	return statusClientClosedRequest, fmt.Errorf("request cancelled")
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func assertOpen(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+		t.Fatalf("unexpected %s", what)
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
+func TestRPCSchedulerConcurrency(t *testing.T) {
+	s := NewRPCScheduler(RPCLimits{Concurrency: 2, QueueSize: 4, Timeout: time.Second})
+
+	var running, peak int32
+	started := make(chan struct{}, 5)
+	release := make(chan struct{})
+	finished := make(chan struct{}, 5)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Submit("alice", "s1", "", 0, func(ctx context.Context) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			started <- struct{}{}
+			<-release
+			atomic.AddInt32(&running, -1)
+			finished <- struct{}{}
+		}))
+	}
+
+	waitClosed(t, started, "the first request")
+	waitClosed(t, started, "the second request")
+	select {
+	case <-started:
+		t.Fatal("more than 2 requests running")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	// Another user has its own pool
+	other := make(chan struct{})
+	require.NoError(t, s.Submit("bob", "s1", "", 0, func(ctx context.Context) { close(other) }))
+	waitClosed(t, other, "bob's request")
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		waitClosed(t, finished, "the queued requests")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&peak))
+
+	// Idle pools are removed
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.pools) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRPCSchedulerQueueFull(t *testing.T) {
+	limits := RPCLimits{Concurrency: 1, QueueSize: 2, Timeout: time.Second}
+	s := NewRPCScheduler(limits)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	started := make(chan struct{})
+	require.NoError(t, s.Submit("alice", "s1", "", 0, func(ctx context.Context) {
+		close(started)
+		<-release
+	}))
+	waitClosed(t, started, "the first request")
+
+	var err error
+	accepted := 1
+	for ; accepted < 20; accepted++ {
+		if err = s.Submit("alice", "s1", "", 0, func(ctx context.Context) { <-release }); err != nil {
+			break
+		}
+	}
+	assert.ErrorIs(t, err, errRPCQueueFull)
+	assert.Equal(t, 429, rpcErrorStatus(err))
+	assert.GreaterOrEqual(t, accepted, limits.Concurrency+limits.QueueSize)
+	assert.LessOrEqual(t, accepted, 2*limits.Concurrency+limits.QueueSize)
+}
+
+func TestRPCSchedulerCancel(t *testing.T) {
+	s := NewRPCScheduler(RPCLimits{Concurrency: 1, QueueSize: 2, Timeout: time.Second})
+
+	running := make(chan struct{})
+	runningErr := make(chan error, 1)
+	require.NoError(t, s.Submit("alice", "s1", "r1", 0, func(ctx context.Context) {
+		close(running)
+		<-ctx.Done()
+		runningErr <- ctx.Err()
+	}))
+	queuedErr := make(chan error, 1)
+	require.NoError(t, s.Submit("alice", "s1", "r2", 0, func(ctx context.Context) {
+		queuedErr <- ctx.Err()
+	}))
+	waitClosed(t, running, "the first request")
+
+	// Queued: run gets a done context
+	assert.True(t, s.Cancel("alice", "s1", "r2"))
+	assert.True(t, s.Cancel("alice", "s1", "r1"))
+	assert.ErrorIs(t, <-runningErr, context.Canceled)
+	assert.ErrorIs(t, <-queuedErr, context.Canceled)
+
+	assert.False(t, s.Cancel("alice", "s1", "unknown"))
+	assert.False(t, s.Cancel("alice", "s2", "r1"), "another session")
+	assert.False(t, s.Cancel("bob", "s1", "r1"))
+
+	status, err := contextErrorStatus(context.Canceled)
+	assert.Equal(t, statusClientClosedRequest, status)
+	assert.EqualError(t, err, "request cancelled")
+}
+
+func TestRPCSchedulerTimeout(t *testing.T) {
+	s := NewRPCScheduler(RPCLimits{Concurrency: 1, QueueSize: 2, Timeout: time.Second})
+
+	// timeout_ms shortens the server deadline, never extends it
+	deadlines := make(chan time.Duration, 2)
+	for _, timeoutMs := range []int64{20, 60000} {
+		require.NoError(t, s.Submit("alice", "s1", "", timeoutMs, func(ctx context.Context) {
+			deadline, _ := ctx.Deadline()
+			deadlines <- time.Until(deadline)
+			<-ctx.Done()
+			assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+		}))
+	}
+	assert.LessOrEqual(t, <-deadlines, 20*time.Millisecond)
+	second := <-deadlines
+	assert.Greater(t, second, 500*time.Millisecond)
+	assert.LessOrEqual(t, second, time.Second)
+
+	status, err := contextErrorStatus(context.DeadlineExceeded)
+	assert.Equal(t, 504, status)
+	assert.EqualError(t, err, "request timed out")
+}
+
+func TestRPCSchedulerDuplicateRequestID(t *testing.T) {
+	s := NewRPCScheduler(RPCLimits{Concurrency: 2, QueueSize: 2, Timeout: time.Second})
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	require.NoError(t, s.Submit("alice", "s1", "r1", 0, func(ctx context.Context) {
+		<-release
+		close(done)
+	}))
+
+	err := s.Submit("alice", "s1", "r1", 0, func(ctx context.Context) {})
+	assert.ErrorIs(t, err, errRPCDuplicate)
+	assert.Equal(t, 409, rpcErrorStatus(err))
+
+	// Same id from another session, or without id
+	second := make(chan struct{})
+	require.NoError(t, s.Submit("alice", "s2", "r1", 0, func(ctx context.Context) { close(second) }))
+	waitClosed(t, second, "the other session's request")
+	require.NoError(t, s.Submit("alice", "s1", "", 0, func(ctx context.Context) {}))
+
+	// The first one can still be cancelled
+	assert.True(t, s.Cancel("alice", "s1", "r1"))
+	close(release)
+	waitClosed(t, done, "the first request")
+
+	assert.Eventually(t, func() bool {
+		return !errors.Is(s.Submit("alice", "s1", "r1", 0, func(ctx context.Context) {}), errRPCDuplicate)
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRPCSchedulerHoldsSlotUntilHandlerReturns(t *testing.T) {
+	app := newTestApp(t)
+	alice := createTestUser(t, app, "alice@example.com")
+
+	bridge := NewRouterBridge(app)
+	unblock := make(chan struct{})
+	handlerDone := make(chan struct{})
+	bridge.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Ignores its context
+		<-unblock
+		close(handlerDone)
+	}))
+
+	s := NewRPCScheduler(RPCLimits{Concurrency: 1, QueueSize: 2, Timeout: time.Second})
+
+	answered := make(chan int, 1)
+	require.NoError(t, s.Submit(alice, "s1", "r1", 20, func(ctx context.Context) {
+		_, status, _, _ := bridge.DispatchStream(ctx, alice, "", APIRequest{Endpoint: "/slow"}, nil)
+		answered <- status
+	}))
+
+	// The 504 is sent right away
+	select {
+	case status := <-answered:
+		assert.Equal(t, 504, status)
+	case <-time.After(time.Second):
+		t.Fatal("no answer at the deadline")
+	}
+
+	next := make(chan struct{})
+	require.NoError(t, s.Submit(alice, "s1", "r2", 0, func(ctx context.Context) { close(next) }))
+	assertOpen(t, next, "request run while the handler still holds the slot")
+
+	close(unblock)
+	waitClosed(t, handlerDone, "the handler")
+	waitClosed(t, next, "the next request")
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"math"
+)
+
+// ==================== SPATIAL INDEX ====================
+
+// Grid of fixed cells in degrees (0.01° ≈ 1.1 km of latitude), used by the
+// LocationManager for user positions and geofence bounding boxes. Queries
+// return candidates, the callers check the exact distance or shape.
+
+const (
+	spatialCellSize    = 0.01
+	spatialMaxCells    = 1024 // bounds covering more cells are kept apart
+	metersPerDegreeLat = 111320
+)
+
+// GeoBounds - Bounding box, MinLng > MaxLng never happens: boxes crossing
+// the antimeridian go past -180 or 180
+type GeoBounds struct {
+	MinLat float64 `json:"min_lat"`
+	MinLng float64 `json:"min_lng"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLng float64 `json:"max_lng"`
+}
+
+func pointBounds(p Point) GeoBounds {
+	return GeoBounds{MinLat: p.Lat, MinLng: p.Lng, MaxLat: p.Lat, MaxLng: p.Lng}
+}
+
+// Box around a circle, the whole longitude range near the poles
+func circleBounds(center Point, radiusMeters float64) GeoBounds {
+	dLat := radiusMeters / metersPerDegreeLat
+	bounds := GeoBounds{
+		MinLat: math.Max(center.Lat-dLat, -90),
+		MaxLat: math.Min(center.Lat+dLat, 90),
+		MinLng: -180,
+		MaxLng: 180,
+	}
+
+	if bounds.MinLat > -90 && bounds.MaxLat < 90 {
+		cos := math.Cos(math.Max(math.Abs(bounds.MinLat), math.Abs(bounds.MaxLat)) * math.Pi / 180)
+		if dLng := dLat / cos; dLng < 180 {
+			bounds.MinLng = center.Lng - dLng
+			bounds.MaxLng = center.Lng + dLng
+		}
+	}
+	return bounds
+}
+
+func polygonBounds(polygon []Point) GeoBounds {
+	if len(polygon) == 0 {
+		return GeoBounds{}
+	}
+
+	bounds := pointBounds(polygon[0])
+	for _, p := range polygon[1:] {
+		bounds.MinLat = math.Min(bounds.MinLat, p.Lat)
+		bounds.MaxLat = math.Max(bounds.MaxLat, p.Lat)
+		bounds.MinLng = math.Min(bounds.MinLng, p.Lng)
+		bounds.MaxLng = math.Max(bounds.MaxLng, p.Lng)
+	}
+	return bounds
+}
+
+type spatialCell struct {
+	x, y int
+}
+
+// SpatialIndex - Grille d'identifiants (users, geofences) par cellule.
+// Not safe for concurrent use, the LocationManager guards it with its mutex.
+type SpatialIndex struct {
+	cellSize float64
+	columns  int
+	cells    map[spatialCell]map[string]struct{}
+	items    map[string][]spatialCell // id -> cells it is in
+	large    map[string]struct{}      // returned by every search
+}
+
+func NewSpatialIndex(cellSize float64) *SpatialIndex {
+	if cellSize <= 0 {
+		cellSize = spatialCellSize
+	}
+
+	return &SpatialIndex{
+		cellSize: cellSize,
+		columns:  int(math.Ceil(360 / cellSize)),
+		cells:    make(map[spatialCell]map[string]struct{}),
+		items:    make(map[string][]spatialCell),
+		large:    make(map[string]struct{}),
+	}
+}
+
+// Cells covered by bounds, nil when more than limit
+func (si *SpatialIndex) cellsOf(bounds GeoBounds, limit int) []spatialCell {
+	minX := int(math.Floor((bounds.MinLng + 180) / si.cellSize))
+	maxX := int(math.Floor((bounds.MaxLng + 180) / si.cellSize))
+	minY := int(math.Floor((math.Max(bounds.MinLat, -90) + 90) / si.cellSize))
+	maxY := int(math.Floor((math.Min(bounds.MaxLat, 90) + 90) / si.cellSize))
+
+	if maxX-minX+1 >= si.columns {
+		minX, maxX = 0, si.columns-1
+	}
+	if count := (maxX - minX + 1) * (maxY - minY + 1); count > limit || count <= 0 {
+		return nil
+	}
+
+	cells := make([]spatialCell, 0, (maxX-minX+1)*(maxY-minY+1))
+	for x := minX; x <= maxX; x++ {
+		// Wrap around the antimeridian
+		column := ((x % si.columns) + si.columns) % si.columns
+		for y := minY; y <= maxY; y++ {
+			cells = append(cells, spatialCell{x: column, y: y})
+		}
+	}
+	return cells
+}
+
+// Index id in the cells covered by bounds, replacing its previous bounds
+func (si *SpatialIndex) Insert(id string, bounds GeoBounds) {
+	cells := si.cellsOf(bounds, spatialMaxCells)
+
+	// A point moving inside its cell: nothing to do
+	if previous, exists := si.items[id]; exists && len(previous) == 1 && len(cells) == 1 && previous[0] == cells[0] {
+		return
+	}
+
+	si.Remove(id)
+
+	if cells == nil {
+		si.large[id] = struct{}{}
+		return
+	}
+
+	for _, cell := range cells {
+		ids, exists := si.cells[cell]
+		if !exists {
+			ids = make(map[string]struct{})
+			si.cells[cell] = ids
+		}
+		ids[id] = struct{}{}
+	}
+	si.items[id] = cells
+}
+
+func (si *SpatialIndex) InsertPoint(id string, p Point) {
+	si.Insert(id, pointBounds(p))
+}
+
+func (si *SpatialIndex) Remove(id string) {
+	delete(si.large, id)
+
+	for _, cell := range si.items[id] {
+		ids := si.cells[cell]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(si.cells, cell)
+		}
+	}
+	delete(si.items, id)
+}
+
+// Ids whose cells intersect bounds. Past as many cells as indexed ids, a
+// scan of every id is cheaper than the cells.
+func (si *SpatialIndex) Search(bounds GeoBounds) []string {
+	found := make(map[string]struct{}, len(si.large))
+	for id := range si.large {
+		found[id] = struct{}{}
+	}
+
+	cells := si.cellsOf(bounds, len(si.items))
+	if cells == nil {
+		for id := range si.items {
+			found[id] = struct{}{}
+		}
+	} else {
+		for _, cell := range cells {
+			for id := range si.cells[cell] {
+				found[id] = struct{}{}
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (si *SpatialIndex) Len() int {
+	return len(si.items) + len(si.large)
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func searchSorted(si *SpatialIndex, bounds GeoBounds) []string {
+	ids := si.Search(bounds)
+	sort.Strings(ids)
+	return ids
+}
+
+// Position known by the LocationManager, without the background writes
+func placeTestUser(lm *LocationManager, userID string, p Point) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.locations[userID] = &UserLocation{
+		UserID:    userID,
+		Location:  Location{Point: p},
+		Presence:  "online",
+		UpdatedAt: time.Now(),
+	}
+	lm.userIndex.InsertPoint(userID, p)
+}
+
+func TestSpatialIndexSearch(t *testing.T) {
+	si := NewSpatialIndex(spatialCellSize)
+	for i := 0; i < 10; i++ {
+		si.InsertPoint(string(rune('a'+i)), Point{Lat: 48.005 + float64(i)*0.1, Lng: 2.005})
+	}
+	for i := 0; i < 10; i++ {
+		si.InsertPoint(string(rune('k'+i)), Point{Lat: -33 - float64(i)*0.1, Lng: 151})
+	}
+	si.InsertPoint("paris", Point{Lat: 48.8566, Lng: 2.3522})
+
+	assert.Equal(t, []string{"paris"}, searchSorted(si, circleBounds(Point{Lat: 48.8566, Lng: 2.3522}, 500)))
+	assert.Equal(t, []string{"a", "b"}, searchSorted(si, GeoBounds{MinLat: 48.001, MinLng: 2.001, MaxLat: 48.109, MaxLng: 2.009}))
+	assert.Empty(t, si.Search(circleBounds(Point{Lat: -33.86, Lng: 151.2}, 1000)))
+
+	// Moved, then removed
+	si.InsertPoint("paris", Point{Lat: 45.764, Lng: 4.8357})
+	assert.Empty(t, si.Search(circleBounds(Point{Lat: 48.8566, Lng: 2.3522}, 500)))
+	assert.Equal(t, []string{"paris"}, searchSorted(si, circleBounds(Point{Lat: 45.764, Lng: 4.8357}, 500)))
+	si.Remove("paris")
+	assert.Empty(t, si.Search(circleBounds(Point{Lat: 45.764, Lng: 4.8357}, 500)))
+	assert.Equal(t, 20, si.Len())
+	assert.NotContains(t, si.items, "paris")
+
+	// More cells than indexed ids: every id is a candidate
+	assert.Len(t, si.Search(GeoBounds{MinLat: -10, MinLng: -10, MaxLat: 10, MaxLng: 10}), 20)
+}
+
+func TestSpatialIndexLargeBounds(t *testing.T) {
+	si := NewSpatialIndex(spatialCellSize)
+	si.Insert("country", GeoBounds{MinLat: 42, MinLng: -5, MaxLat: 51, MaxLng: 8})
+	si.Insert("shop", circleBounds(Point{Lat: 48.8566, Lng: 2.3522}, 50))
+
+	assert.Contains(t, si.large, "country")
+	assert.Equal(t, []string{"country"}, searchSorted(si, pointBounds(Point{Lat: -33.86, Lng: 151.2})))
+	assert.Equal(t, []string{"country", "shop"}, searchSorted(si, pointBounds(Point{Lat: 48.8566, Lng: 2.3522})))
+
+	// Shrunk back into the grid
+	si.Insert("country", GeoBounds{MinLat: 43.7, MinLng: 7.4, MaxLat: 43.75, MaxLng: 7.44})
+	assert.NotContains(t, si.large, "country")
+	assert.Empty(t, si.Search(pointBounds(Point{Lat: -33.86, Lng: 151.2})))
+}
+
+func TestSpatialIndexAntimeridian(t *testing.T) {
+	si := NewSpatialIndex(spatialCellSize)
+	si.InsertPoint("east", Point{Lat: -17.7, Lng: 179.995})
+	si.InsertPoint("west", Point{Lat: -17.7, Lng: -179.995})
+
+	// Boxes going past 180 or -180 wrap around
+	bounds := circleBounds(Point{Lat: -17.7, Lng: 179.995}, 2000)
+	assert.Greater(t, bounds.MaxLng, 180.0)
+	assert.Equal(t, []string{"east", "west"}, searchSorted(si, bounds))
+	assert.Equal(t, []string{"east", "west"}, searchSorted(si, circleBounds(Point{Lat: -17.7, Lng: -179.995}, 2000)))
+
+	// Near the poles the box covers every longitude
+	polar := circleBounds(Point{Lat: 89.99, Lng: 0}, 5000)
+	assert.Equal(t, -180.0, polar.MinLng)
+	assert.Equal(t, 180.0, polar.MaxLng)
+	assert.Equal(t, 90.0, polar.MaxLat)
+}
+
+func TestFindNearbyAntimeridian(t *testing.T) {
+	lm := NewLocationManager(nil)
+	placeTestUser(lm, "east", Point{Lat: -17.7, Lng: 179.995})
+	placeTestUser(lm, "west", Point{Lat: -17.7, Lng: -179.995})
+	placeTestUser(lm, "far", Point{Lat: -17.7, Lng: 179.9})
+
+	ids := []string{}
+	for _, userLoc := range lm.FindNearby(Point{Lat: -17.7, Lng: 179.999}, 2000, "") {
+		ids = append(ids, userLoc.UserID)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, []string{"east", "west"}, ids)
+
+	assert.Len(t, lm.FindNearby(Point{Lat: -17.7, Lng: -179.999}, 2000, "west"), 1)
+}
@@ -0,0 +1,326 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/search"
+)
+
+// ==================== TOPIC RULES ====================
+
+// TopicRule - Règle d'accès à un pattern de topic, même sémantique que les
+// règles de collection PocketBase:
+//   - SuperuserOnly: seuls les superusers peuvent s'abonner
+//   - Rule vide: public
+//   - sinon filtre évalué sur le record authentifié, avec @request.* et
+//     les tokens du topic en paramètres ({:token1}, {:token2}...)
+//
+// OwnerField (ex: "user_id") limite les messages reçus à ceux dont le
+// payload appartient à l'abonné. RoomMember réserve le topic aux membres de
+// la room nommée par le deuxième token (participant, utilisateur autorisé
+// d'une room privée, owner ou admin).
+//
+// Topics without a matching rule are reserved to the superusers.
+type TopicRule struct {
+	Pattern       string
+	Rule          string
+	SuperuserOnly bool
+	OwnerField    string
+	RoomMember    bool
+}
+
+// Règles créées avec la collection topicRules
+var defaultTopicRules = []TopicRule{
+	{Pattern: "room.*.>", Rule: `@request.auth.id != ""`, RoomMember: true},
+	{Pattern: "user.*.>", Rule: `@request.auth.id = {:token2}`},
+	{Pattern: "admin.>", SuperuserOnly: true},
+	{Pattern: "post_events", Rule: `@request.auth.id != ""`},
+	{Pattern: "reactions", Rule: `@request.auth.id != ""`},
+	{Pattern: "notifications", Rule: `@request.auth.id != ""`, OwnerField: "user_id"},
+	{Pattern: "location_updates", Rule: `@request.auth.id != ""`, OwnerField: "user_id"},
+	{Pattern: "geo_events", Rule: `@request.auth.id != ""`, OwnerField: "user_id"},
+	{Pattern: "chat_invites", Rule: `@request.auth.id != ""`, OwnerField: "user_id"},
+	{Pattern: "call_invites", Rule: `@request.auth.id != ""`, OwnerField: "user_id"},
+	{Pattern: "ads", Rule: `@request.auth.id != ""`, OwnerField: "user_id"},
+	{Pattern: "sales", SuperuserOnly: true},
+}
+
+// More literal tokens first, "*" before ">"
+func topicRuleSpecificity(pattern string) int {
+	score := 0
+	for _, token := range strings.Split(pattern, ".") {
+		switch token {
+		case ">":
+		case "*":
+			score += 1
+		default:
+			score += 3
+		}
+	}
+	return score
+}
+
+// ==================== TOPIC AUTHORIZER ====================
+
+type TopicAuthorizer struct {
+	rules []TopicRule // sorted by specificity
+	app   core.App
+	mu    sync.RWMutex
+}
+
+func NewTopicAuthorizer(app core.App) *TopicAuthorizer {
+	ta := &TopicAuthorizer{app: app}
+	ta.Reload()
+	return ta
+}
+
+// Load the rules from the topicRules collection
+func (ta *TopicAuthorizer) Reload() {
+	rules := []TopicRule{}
+
+	records, err := ta.app.FindAllRecords("topicRules")
+	if err != nil {
+		log.Printf("Error loading topic rules, using defaults: %v", err)
+		rules = append(rules, defaultTopicRules...)
+	} else {
+		for _, record := range records {
+			rules = append(rules, TopicRule{
+				Pattern:       record.GetString("pattern"),
+				Rule:          record.GetString("rule"),
+				SuperuserOnly: record.GetBool("superuserOnly"),
+				OwnerField:    record.GetString("ownerField"),
+				RoomMember:    record.GetBool("roomMember"),
+			})
+		}
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return topicRuleSpecificity(rules[i].Pattern) > topicRuleSpecificity(rules[j].Pattern)
+	})
+
+	ta.mu.Lock()
+	ta.rules = rules
+	ta.mu.Unlock()
+
+	log.Printf("🔐 %d topic rules loaded", len(rules))
+}
+
+// Most specific rule matching a topic or pattern
+func (ta *TopicAuthorizer) RuleFor(topic string) (TopicRule, bool) {
+	ta.mu.RLock()
+	defer ta.mu.RUnlock()
+
+	for _, rule := range ta.rules {
+		if TopicMatches(rule.Pattern, topic) {
+			return rule, true
+		}
+	}
+	return TopicRule{}, false
+}
+
+// Check if the request can receive the messages of a topic
+func (ta *TopicAuthorizer) CanAccess(info *core.RequestInfo, topic string) (bool, error) {
+	if info.HasSuperuserAuth() {
+		return true, nil
+	}
+
+	// Without a matching rule, only superusers
+	rule, ok := ta.RuleFor(topic)
+	if !ok || rule.SuperuserOnly {
+		return false, nil
+	}
+
+	tokens := strings.Split(topic, ".")
+
+	if rule.RoomMember {
+		if info.Auth == nil || len(tokens) < 2 || !isRoomMember(ta.app, tokens[1], info.Auth.Id) {
+			return false, nil
+		}
+	}
+
+	if rule.Rule == "" {
+		return true, nil
+	}
+
+	// Non-empty rules are evaluated against the authenticated record
+	if info.Auth == nil {
+		return false, nil
+	}
+
+	params := dbx.Params{}
+	for i, token := range tokens {
+		params[fmt.Sprintf("token%d", i+1)] = token
+	}
+
+	collection := info.Auth.Collection()
+	resolver := core.NewRecordFieldResolver(ta.app, collection, info, true)
+	expr, err := search.FilterData(rule.Rule).BuildExpr(resolver, params)
+	if err != nil {
+		return false, err
+	}
+
+	query := ta.app.RecordQuery(collection).
+		Select("(1)").
+		AndWhere(dbx.HashExp{collection.Name + ".id": info.Auth.Id})
+	if err := resolver.UpdateQuery(query); err != nil {
+		return false, err
+	}
+
+	var exists int
+	err = query.AndWhere(expr).Limit(1).Row(&exists)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	return exists > 0, nil
+}
+
+// Participant or allowed user of the live room, or owner/admin of the
+// persisted one
+func isRoomMember(app core.App, roomID, userID string) bool {
+	roomsMutex.RLock()
+	room, exists := rooms[roomID]
+	roomsMutex.RUnlock()
+
+	if exists {
+		room.mu.RLock()
+		member := room.AllowedUsers[userID]
+		for _, p := range room.Participants {
+			if p.UserID == userID {
+				member = true
+				break
+			}
+		}
+		room.mu.RUnlock()
+
+		if member {
+			return true
+		}
+	}
+
+	return IsRoomOwnerOrAdmin(app, roomID, userID)
+}
+
+// ==================== SUBSCRIBER ACCESS ====================
+
+// Room memberships change during a stream: their decisions are checked again
+// after this delay, so that a removed member stops receiving the room events
+var roomAccessTTL = 15 * time.Second
+
+type topicDecision struct {
+	allowed bool
+	expires time.Time // zero: for the whole stream
+}
+
+// TopicAccess - Décisions d'accès d'un abonné, mises en cache par topic
+type TopicAccess struct {
+	info       *core.RequestInfo
+	authorizer *TopicAuthorizer
+	cache      map[string]topicDecision
+	mu         sync.Mutex
+}
+
+func (ta *TopicAuthorizer) NewAccess(info *core.RequestInfo) *TopicAccess {
+	return &TopicAccess{
+		info:       info,
+		authorizer: ta,
+		cache:      make(map[string]topicDecision),
+	}
+}
+
+// Check the topic rule only (cached)
+func (a *TopicAccess) AllowsTopic(topic string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	decision, cached := a.cache[topic]
+	if cached && (decision.expires.IsZero() || now.Before(decision.expires)) {
+		return decision.allowed
+	}
+
+	allowed, err := a.authorizer.CanAccess(a.info, topic)
+	if err != nil {
+		log.Printf("Error evaluating topic rule for %s: %v", topic, err)
+		allowed = false
+	}
+
+	decision = topicDecision{allowed: allowed}
+	if rule, _ := a.authorizer.RuleFor(topic); rule.RoomMember {
+		decision.expires = now.Add(roomAccessTTL)
+	}
+	a.cache[topic] = decision
+
+	return allowed
+}
+
+// Check if a message can be delivered to the subscriber
+func (a *TopicAccess) Allows(msg PubSubMessage) bool {
+	if a.info.HasSuperuserAuth() {
+		return true
+	}
+
+	if !a.AllowsTopic(msg.Topic) {
+		return false
+	}
+
+	// Per user payload filtering
+	rule, _ := a.authorizer.RuleFor(msg.Topic)
+	if rule.OwnerField != "" {
+		if a.info.Auth == nil {
+			return false
+		}
+		owner, _ := msg.Payload[rule.OwnerField].(string)
+		return owner == a.info.Auth.Id
+	}
+
+	return true
+}
+
+// ==================== SETUP COLLECTIONS ====================
+
+func SetupTopicRulesCollection(app core.App) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		if _, err := txApp.FindCollectionByNameOrId("topicRules"); err == nil {
+			return nil // Already exists
+		}
+
+		topicRules := core.NewBaseCollection("topicRules")
+		topicRules.Fields.Add(
+			&core.TextField{Name: "pattern", Required: true},
+			&core.TextField{Name: "rule"},
+			&core.BoolField{Name: "superuserOnly"},
+			&core.TextField{Name: "ownerField"},
+			&core.BoolField{Name: "roomMember"},
+		)
+		topicRules.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_topic_rules_pattern ON topicRules (pattern)",
+		}
+
+		if err := txApp.Save(topicRules); err != nil {
+			return err
+		}
+
+		for _, rule := range defaultTopicRules {
+			record := core.NewRecord(topicRules)
+			record.Set("pattern", rule.Pattern)
+			record.Set("rule", rule.Rule)
+			record.Set("superuserOnly", rule.SuperuserOnly)
+			record.Set("ownerField", rule.OwnerField)
+			record.Set("roomMember", rule.RoomMember)
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTopicAuthorizer(t *testing.T) (*TopicAuthorizer, core.App) {
+	t.Helper()
+
+	app := newTestApp(t)
+	require.NoError(t, SetupCollections(app))
+	require.NoError(t, SetupFollowAndRoomCollections(app))
+	require.NoError(t, SetupTopicRulesCollection(app))
+
+	return NewTopicAuthorizer(app), app
+}
+
+func authInfo(t *testing.T, app core.App, collection, id string) *core.RequestInfo {
+	t.Helper()
+
+	record, err := app.FindRecordById(collection, id)
+	require.NoError(t, err)
+	return &core.RequestInfo{Auth: record}
+}
+
+func withTestRoom(t *testing.T, room *Room) {
+	t.Helper()
+
+	roomsMutex.Lock()
+	rooms[room.ID] = room
+	roomsMutex.Unlock()
+
+	t.Cleanup(func() {
+		roomsMutex.Lock()
+		delete(rooms, room.ID)
+		roomsMutex.Unlock()
+	})
+}
+
+func canAccess(t *testing.T, ta *TopicAuthorizer, info *core.RequestInfo, topic string) bool {
+	t.Helper()
+
+	allowed, err := ta.CanAccess(info, topic)
+	require.NoError(t, err)
+	return allowed
+}
+
+func TestTopicRulesDenyByDefault(t *testing.T) {
+	ta, app := newTestTopicAuthorizer(t)
+
+	alice := authInfo(t, app, "users", createTestUser(t, app, "alice@example.com"))
+
+	superuser := core.NewRecord(mustCollection(t, app, core.CollectionNameSuperusers))
+	superuser.SetEmail("admin@example.com")
+	superuser.SetPassword("1234567890")
+	require.NoError(t, app.Save(superuser))
+	admin := &core.RequestInfo{Auth: superuser}
+
+	assert.False(t, canAccess(t, ta, alice, "misc.topic"))
+	assert.False(t, canAccess(t, ta, alice, "admin.audit"))
+	assert.False(t, canAccess(t, ta, alice, "sales"))
+	assert.False(t, canAccess(t, ta, &core.RequestInfo{}, "post_events"))
+	assert.True(t, canAccess(t, ta, alice, "post_events"))
+
+	assert.True(t, canAccess(t, ta, admin, "misc.topic"))
+	assert.True(t, canAccess(t, ta, admin, "admin.audit"))
+}
+
+func TestTopicRulesUserTopics(t *testing.T) {
+	ta, app := newTestTopicAuthorizer(t)
+
+	aliceID := createTestUser(t, app, "alice@example.com")
+	bobID := createTestUser(t, app, "bob@example.com")
+	alice := authInfo(t, app, "users", aliceID)
+
+	assert.True(t, canAccess(t, ta, alice, UserTopic(aliceID, "notifications")))
+	assert.False(t, canAccess(t, ta, alice, UserTopic(bobID, "notifications")))
+}
+
+func TestTopicRulesRoomMembership(t *testing.T) {
+	ta, app := newTestTopicAuthorizer(t)
+
+	ownerID := createTestUser(t, app, "owner@example.com")
+	adminID := createTestUser(t, app, "admin@example.com")
+	participantID := createTestUser(t, app, "participant@example.com")
+	invitedID := createTestUser(t, app, "invited@example.com")
+	strangerID := createTestUser(t, app, "stranger@example.com")
+
+	// Live room with a participant and an invited user
+	withTestRoom(t, &Room{
+		ID:           "live",
+		Participants: map[string]*Participant{"p1": {ID: "p1", UserID: participantID}},
+		AllowedUsers: map[string]bool{invitedID: true},
+	})
+
+	// Persisted room with an admin
+	rooms := mustCollection(t, app, "rooms")
+	room := core.NewRecord(rooms)
+	room.Set("roomType", "audio")
+	room.Set("name", "Persisted")
+	room.Set("owner", ownerID)
+	room.Set("joinType", "free")
+	require.NoError(t, app.Save(room))
+
+	member := core.NewRecord(mustCollection(t, app, "roomMembers"))
+	member.Set("room", room.Id)
+	member.Set("user", adminID)
+	member.Set("role", "admin")
+	member.Set("status", "active")
+	require.NoError(t, app.Save(member))
+
+	info := func(id string) *core.RequestInfo { return authInfo(t, app, "users", id) }
+
+	assert.True(t, canAccess(t, ta, info(participantID), RoomTopic("live", "chat")))
+	assert.True(t, canAccess(t, ta, info(invitedID), RoomTopic("live", "chat")))
+	assert.False(t, canAccess(t, ta, info(strangerID), RoomTopic("live", "chat")))
+	assert.False(t, canAccess(t, ta, info(adminID), RoomTopic("live", "chat")))
+
+	assert.True(t, canAccess(t, ta, info(adminID), RoomTopic(room.Id, "chat")))
+	assert.False(t, canAccess(t, ta, info(strangerID), RoomTopic(room.Id, "chat")))
+	assert.False(t, canAccess(t, ta, info(strangerID), RoomTopic("missing", "chat")))
+	assert.False(t, canAccess(t, ta, &core.RequestInfo{}, RoomTopic("live", "chat")))
+
+	// The room token is bound, not spliced in the membership filter
+	assert.False(t, canAccess(t, ta, info(strangerID), "room.x' || role != '.chat"))
+}
+
+func TestTopicRulesSetup(t *testing.T) {
+	app := newTestApp(t)
+
+	require.NoError(t, SetupTopicRulesCollection(app))
+	// Edited rules are kept on the next starts
+	record, err := app.FindFirstRecordByData("topicRules", "pattern", "post_events")
+	require.NoError(t, err)
+	record.Set("superuserOnly", true)
+	require.NoError(t, app.Save(record))
+	require.NoError(t, SetupTopicRulesCollection(app))
+
+	records, err := app.FindAllRecords("topicRules")
+	require.NoError(t, err)
+	assert.Len(t, records, len(defaultTopicRules))
+
+	ta := NewTopicAuthorizer(app)
+	rule, ok := ta.RuleFor("room.abc.chat")
+	require.True(t, ok)
+	assert.True(t, rule.RoomMember)
+	rule, ok = ta.RuleFor("post_events")
+	require.True(t, ok)
+	assert.True(t, rule.SuperuserOnly)
+}
+
+func TestTopicAccessRoomExpiry(t *testing.T) {
+	ta, app := newTestTopicAuthorizer(t)
+
+	saved := roomAccessTTL
+	roomAccessTTL = 100 * time.Millisecond
+	t.Cleanup(func() { roomAccessTTL = saved })
+
+	aliceID := createTestUser(t, app, "alice@example.com")
+	room := &Room{
+		ID:           "live",
+		Participants: map[string]*Participant{"p1": {ID: "p1", UserID: aliceID}},
+		AllowedUsers: map[string]bool{},
+	}
+	withTestRoom(t, room)
+
+	access := ta.NewAccess(authInfo(t, app, "users", aliceID))
+	topic := RoomTopic("live", "chat")
+	require.True(t, access.AllowsTopic(topic))
+	require.True(t, access.AllowsTopic(UserTopic(aliceID, "notifications")))
+
+	// Left the room: denied once the decision expires
+	room.mu.Lock()
+	delete(room.Participants, "p1")
+	room.mu.Unlock()
+	assert.True(t, access.AllowsTopic(topic), "cached")
+	time.Sleep(150 * time.Millisecond)
+	assert.False(t, access.AllowsTopic(topic))
+
+	// The other decisions last for the whole stream
+	access.mu.Lock()
+	assert.True(t, access.cache[UserTopic(aliceID, "notifications")].expires.IsZero())
+	access.mu.Unlock()
+}
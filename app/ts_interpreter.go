@@ -62,7 +62,27 @@ type ScriptContext struct {
 	exports   *goja.Object
 	isRunning bool
 	stopChan  chan bool
-	mu        sync.RWMutex
+	stopOnce  sync.Once
+	// Abonnements pubsub ouverts par le script
+	subscriptions []*Subscription
+	mu            sync.RWMutex
+}
+
+// Stop background goroutines and release pubsub subscriptions
+func (ctx *ScriptContext) stop() {
+	ctx.stopOnce.Do(func() {
+		close(ctx.stopChan)
+	})
+
+	ctx.mu.Lock()
+	subs := ctx.subscriptions
+	ctx.subscriptions = nil
+	ctx.isRunning = false
+	ctx.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
 }
 
 // ==================== SCRIPT MANAGER ====================
@@ -325,12 +345,7 @@ func (sm *ScriptManager) StopScript(scriptID string) error {
 		return fmt.Errorf("script not found: %s", scriptID)
 	}
 
-	ctx.mu.Lock()
-	if ctx.isRunning {
-		close(ctx.stopChan)
-		ctx.isRunning = false
-	}
-	ctx.mu.Unlock()
+	ctx.stop()
 
 	log.Printf("⏹️  Stopped script: %s", ctx.Name)
 	return nil
@@ -605,30 +620,52 @@ func (sm *ScriptManager) exposeAPIs(ctx *ScriptContext) {
 			})
 		},
 
-		"subscribe": func(topic string, callback goja.Callable) {
-			ch := pubsub.Subscribe(topic)
+		"subscribe": func(topic string, callback goja.Callable, options map[string]interface{}) map[string]interface{} {
+			if err := ValidateTopicPattern(topic); err != nil {
+				return map[string]interface{}{"error": err.Error()}
+			}
+
+			// Optional { filter: "type = 'comment'" }
+			filterExpr, _ := options["filter"].(string)
+			filter, err := CompileEventFilter(filterExpr)
+			if err != nil {
+				return map[string]interface{}{"error": err.Error()}
+			}
+
+			sub := pubsub.Subscribe(topic, SubscribeOptions{Filter: filter})
+
+			ctx.mu.Lock()
+			ctx.subscriptions = append(ctx.subscriptions, sub)
+			ctx.mu.Unlock()
+
 			go func() {
 				for {
 					select {
-					case msg := <-ch:
-						ctx.mu.RLock()
-						if !ctx.isRunning {
-							ctx.mu.RUnlock()
+					case msg, ok := <-sub.C:
+						if !ok {
 							return
 						}
-						ctx.mu.RUnlock()
 
 						data, _ := json.Marshal(msg.Payload)
-						_, err := callback(goja.Undefined(), vm.ToValue(string(data)))
+						_, err := callback(goja.Undefined(), vm.ToValue(string(data)), vm.ToValue(msg.Topic))
 						if err != nil {
 							log.Printf("[%s] Callback error: %v", ctx.Name, err)
 						}
 
 					case <-ctx.stopChan:
+						sub.Unsubscribe()
 						return
 					}
 				}
 			}()
+
+			return map[string]interface{}{
+				"id":    sub.ID,
+				"topic": topic,
+				"unsubscribe": func() {
+					sub.Unsubscribe()
+				},
+			}
 		},
 	}
 	vm.Set("pubsub", pubsubAPI)
@@ -735,8 +772,13 @@ func (sm *ScriptManager) exposeAPIs(ctx *ScriptContext) {
 			return map[string]interface{}{"success": true, "user_id": userId}
 		},
 
-		"getLocation": func(userId string) map[string]interface{} {
+		// viewerId: the user the script acts for, the privacy settings of the
+		// other users apply (only their public positions when empty)
+		"getLocation": func(userId string, viewerId string) map[string]interface{} {
 			userLoc, exists := locationManager.GetLocation(userId)
+			if exists {
+				userLoc, exists = locationPrivacy.Viewer(viewerId, false).View(userLoc)
+			}
 			if !exists {
 				return map[string]interface{}{"error": "location not found"}
 			}
@@ -750,9 +792,9 @@ func (sm *ScriptManager) exposeAPIs(ctx *ScriptContext) {
 			}
 		},
 
-		"findNearby": func(lat, lng, radius float64) []map[string]interface{} {
+		"findNearby": func(lat, lng, radius float64, viewerId string) []map[string]interface{} {
 			point := Point{Lat: lat, Lng: lng}
-			nearby := locationManager.FindNearby(point, radius, "")
+			nearby := locationPrivacy.Viewer(viewerId, false).FindNearby(point, radius, "")
 
 			result := make([]map[string]interface{}, len(nearby))
 			for i, user := range nearby {
@@ -767,8 +809,8 @@ func (sm *ScriptManager) exposeAPIs(ctx *ScriptContext) {
 			return result
 		},
 
-		"getUsersByPresence": func(presence string) []map[string]interface{} {
-			users := locationManager.GetUsersByPresence(presence)
+		"getUsersByPresence": func(presence string, viewerId string) []map[string]interface{} {
+			users := locationPrivacy.Viewer(viewerId, false).ViewAll(locationManager.GetUsersByPresence(presence))
 
 			result := make([]map[string]interface{}, len(users))
 			for i, user := range users {
@@ -1,173 +1,603 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	webrtc "github.com/pion/webrtc/v3"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
 	msgpack "github.com/vmihailenco/msgpack/v5"
 )
 
 // ==================== USER CHANNEL TYPES ====================
 
-// SSEChannel - Canal SSE pour chaque utilisateur
+// SSEChannel - Canal SSE d'une session (un onglet, un appareil)
 type SSEChannel struct {
-	UserID   string
-	Channel  chan SSEMessage
-	IsActive bool
-	mu       sync.RWMutex
+	UserID      string
+	SessionID   string
+	Device      string
+	ConnectedAt time.Time
+	Channel     chan SSEMessage
+	IsActive    bool
+	Options     SubscribeOptions
+	Dropped     uint64
+	Reason      string // why the channel was closed by the server
+	mu          sync.RWMutex
+}
+
+// Send a message according to the backpressure policy
+func (ch *SSEChannel) send(message SSEMessage) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if !ch.IsActive {
+		return
+	}
+
+	if ch.Options.Filter != nil && !ch.Options.Filter.Match(sseMessageToEvent(ch.UserID, message)) {
+		return
+	}
+
+	dropped, disconnect := offer(ch.Channel, message, ch.Options)
+	if dropped > 0 {
+		ch.Dropped += uint64(dropped)
+		log.Printf("⚠️  SSE channel full for user %s session %s (%d dropped)", ch.UserID, ch.SessionID, ch.Dropped)
+	}
+
+	if disconnect {
+		ch.Reason = reasonSlowConsumer
+		ch.closeLocked()
+	}
+}
+
+func (ch *SSEChannel) active() bool {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	return ch.IsActive
+}
+
+func (ch *SSEChannel) closeLocked() {
+	if ch.IsActive {
+		ch.IsActive = false
+		close(ch.Channel)
+	}
+}
+
+type SSEChannelStats struct {
+	UserID     string             `json:"user_id"`
+	SessionID  string             `json:"session_id"`
+	Policy     BackpressurePolicy `json:"policy"`
+	BufferSize int                `json:"buffer_size"`
+	Pending    int                `json:"pending"`
+	Dropped    uint64             `json:"dropped"`
 }
 
 type SSEMessage struct {
-	Type      string                 `json:"type"`
-	RequestID string                 `json:"request_id,omitempty"`
-	Data      map[string]interface{} `json:"data"`
-	Timestamp int64                  `json:"timestamp"`
+	ID        uint64                 `json:"id" msgpack:"id"`
+	Type      string                 `json:"type" msgpack:"type"`
+	RequestID string                 `json:"request_id,omitempty" msgpack:"request_id,omitempty"`
+	Session   string                 `json:"session,omitempty" msgpack:"session,omitempty"` // set when sent to a single session
+	Data      map[string]interface{} `json:"data" msgpack:"data"`
+	Timestamp int64                  `json:"timestamp" msgpack:"timestamp"`
 }
 
-// UserRoom - Room WebRTC dédiée à chaque utilisateur
+// UserRoom - Room WebRTC dédiée à chaque session d'un utilisateur
 type UserRoom struct {
 	UserID       string
+	SessionID    string
+	Device       string
+	RemoteAddr   string // of the signaling request, for the bridged API requests
 	Participant  *Participant
 	DataChannel  *webrtc.DataChannel
 	IsConnected  bool
+	fragments    *fragmentAssembler
+	out          chan []byte   // send queue, see writeLoop; nil until open
+	outDone      chan struct{} // closed when the writer stops
+	outClosed    bool
+	queued       int // bytes in out
+	CreatedAt    time.Time
 	LastActivity time.Time
 	mu           sync.RWMutex
 }
 
 // APIRequest - Requête API via WebRTC DataChannel
 type APIRequest struct {
-	RequestID string                 `msgpack:"request_id"`
-	Method    string                 `msgpack:"method"` // GET, POST, PATCH, DELETE
-	Endpoint  string                 `msgpack:"endpoint"`
-	Body      map[string]interface{} `msgpack:"body,omitempty"`
-	Query     map[string]string      `msgpack:"query,omitempty"`
+	RequestID string                 `json:"request_id" msgpack:"request_id"`
+	Method    string                 `json:"method" msgpack:"method"` // GET, POST, PATCH, DELETE
+	Endpoint  string                 `json:"endpoint" msgpack:"endpoint"`
+	Body      map[string]interface{} `json:"body,omitempty" msgpack:"body,omitempty"`
+	Query     map[string]string      `json:"query,omitempty" msgpack:"query,omitempty"`
+	TimeoutMs int64                  `json:"timeout_ms,omitempty" msgpack:"timeout_ms,omitempty"` // shorter than the server timeout only
 }
 
 type APIResponse struct {
-	RequestID  string                 `msgpack:"request_id"`
-	StatusCode int                    `msgpack:"status_code"`
-	Data       map[string]interface{} `msgpack:"data"`
-	Error      string                 `msgpack:"error,omitempty"`
-	Timestamp  int64                  `msgpack:"timestamp"`
+	RequestID  string                 `json:"request_id" msgpack:"request_id"`
+	StatusCode int                    `json:"status_code" msgpack:"status_code"`
+	Data       map[string]interface{} `json:"data" msgpack:"data"`
+	Error      string                 `json:"error,omitempty" msgpack:"error,omitempty"`
+	Timestamp  int64                  `json:"timestamp" msgpack:"timestamp"`
+}
+
+// SessionInfo - Connexion active d'un utilisateur
+type SessionInfo struct {
+	SessionID   string    `json:"session_id"`
+	Transport   string    `json:"transport"` // sse, webrtc, websocket
+	Device      string    `json:"device,omitempty"`
+	Connected   bool      `json:"connected"` // false while a user room waits for its answer
+	ConnectedAt time.Time `json:"connected_at"`
 }
 
 // ==================== USER CHANNEL MANAGER ====================
 
 type UserChannelManager struct {
-	sseChannels map[string]*SSEChannel
-	userRooms   map[string]*UserRoom
+	sseChannels map[string]map[string]*SSEChannel // userID -> sessionID
+	userRooms   map[string]map[string]*UserRoom   // userID -> sessionID
+	wsClients   map[string]map[*WSClient]struct{}
+	history     *EventLog     // recent SSE messages per user, for replay
+	store       EventStore    // optional persistence
+	relay       *BrokerPubSub // optional, reaches the sessions on other nodes
+	rpc         *RPCScheduler
 	app         core.App
 	mu          sync.RWMutex
 }
 
 func NewUserChannelManager(app core.App) *UserChannelManager {
 	return &UserChannelManager{
-		sseChannels: make(map[string]*SSEChannel),
-		userRooms:   make(map[string]*UserRoom),
+		sseChannels: make(map[string]map[string]*SSEChannel),
+		userRooms:   make(map[string]map[string]*UserRoom),
+		wsClients:   make(map[string]map[*WSClient]struct{}),
+		history:     NewEventLog(100, time.Hour),
+		rpc:         NewRPCScheduler(DefaultRPCLimits),
 		app:         app,
 	}
 }
 
+// Persist SSE messages so that replay survives restarts
+func (ucm *UserChannelManager) SetEventStore(store EventStore) {
+	ucm.mu.Lock()
+	defer ucm.mu.Unlock()
+	ucm.store = store
+}
+
+// Deliver the user messages across nodes: every message sent to the user
+// sessions is forwarded on user.<id>.sse, and the messages forwarded by the
+// other nodes are delivered to the sessions connected here.
+func (ucm *UserChannelManager) EnableRelay(bp *BrokerPubSub) {
+	ucm.mu.Lock()
+	ucm.relay = bp
+	ucm.mu.Unlock()
+
+	sub := bp.Subscribe(UserTopic("*", "sse"), SubscribeOptions{BufferSize: maxSubscriptionBuffer})
+	go func() {
+		for event := range sub.C {
+			ucm.deliverRelayed(event)
+		}
+	}()
+}
+
+func (ucm *UserChannelManager) forward(event PubSubMessage) {
+	ucm.mu.RLock()
+	relay := ucm.relay
+	ucm.mu.RUnlock()
+
+	if relay != nil {
+		relay.Forward(event)
+	}
+}
+
+// Message forwarded by another node
+func (ucm *UserChannelManager) deliverRelayed(event PubSubMessage) {
+	tokens := strings.Split(event.Topic, ".")
+	if len(tokens) != 3 {
+		return
+	}
+	userID := tokens[1]
+	message := eventToSSEMessage(event)
+
+	// DataChannel only messages are not replayed
+	if transport, _ := event.Payload["transport"].(string); transport == "datachannel" {
+		ucm.deliverToUserRooms(userID, message.Session, message.Type, message.Data, message.RequestID)
+		return
+	}
+
+	// Persisted by the node that sent it, kept here for the reconnections
+	ucm.history.Append(event)
+	ucm.deliverToSessions(userID, message)
+}
+
+func newSessionID() string {
+	return security.RandomString(15)
+}
+
 // ==================== SSE CHANNEL MANAGEMENT ====================
 
-// Create or get SSE channel for user
-func (ucm *UserChannelManager) GetOrCreateSSEChannel(userID string) *SSEChannel {
+// Open the SSE channel of a session, an existing channel with the same
+// session ID (reconnection) is replaced
+func (ucm *UserChannelManager) OpenSSESession(userID, sessionID, device string, opts ...SubscribeOptions) *SSEChannel {
+	if sessionID == "" {
+		sessionID = newSessionID()
+	}
+
+	options := resolveSubscribeOptions(opts)
+	channel := &SSEChannel{
+		UserID:      userID,
+		SessionID:   sessionID,
+		Device:      device,
+		ConnectedAt: time.Now(),
+		Channel:     make(chan SSEMessage, options.BufferSize),
+		IsActive:    true,
+		Options:     options,
+	}
+
 	ucm.mu.Lock()
 	defer ucm.mu.Unlock()
 
-	if channel, exists := ucm.sseChannels[userID]; exists {
-		return channel
+	sessions := ucm.sseChannels[userID]
+	if sessions == nil {
+		sessions = make(map[string]*SSEChannel)
+		ucm.sseChannels[userID] = sessions
 	}
 
-	channel := &SSEChannel{
-		UserID:   userID,
-		Channel:  make(chan SSEMessage, 100),
-		IsActive: true,
+	if previous, exists := sessions[sessionID]; exists {
+		previous.mu.Lock()
+		previous.Reason = "replaced"
+		previous.closeLocked()
+		previous.mu.Unlock()
 	}
 
-	ucm.sseChannels[userID] = channel
-	log.Printf("📡 SSE Channel created for user: %s", userID)
+	sessions[sessionID] = channel
+	log.Printf("📡 SSE Channel created for user: %s (session %s, %d active)", userID, sessionID, len(sessions))
 
 	return channel
 }
 
-// Send message to user's SSE channel
-func (ucm *UserChannelManager) SendToSSE(userID string, msgType string, data map[string]interface{}, requestID string) {
+// Any active SSE channel of the user, or a new session
+func (ucm *UserChannelManager) GetOrCreateSSEChannel(userID string, opts ...SubscribeOptions) *SSEChannel {
 	ucm.mu.RLock()
-	channel, exists := ucm.sseChannels[userID]
+	for _, channel := range ucm.sseChannels[userID] {
+		if channel.active() {
+			ucm.mu.RUnlock()
+			return channel
+		}
+	}
 	ucm.mu.RUnlock()
 
-	if !exists || !channel.IsActive {
-		return
+	return ucm.OpenSSESession(userID, "", "", opts...)
+}
+
+// Send message to all the SSE and WebSocket sessions of the user
+func (ucm *UserChannelManager) SendToSSE(userID string, msgType string, data map[string]interface{}, requestID string) {
+	ucm.sendToSessions(userID, "", msgType, data, requestID)
+}
+
+// Send message to a single session (ex: the response to a request_id),
+// whatever its transport. Without session ID, same as SendToSSE
+func (ucm *UserChannelManager) SendToSession(userID, sessionID string, msgType string, data map[string]interface{}, requestID string) {
+	ucm.sendToSessions(userID, sessionID, msgType, data, requestID)
+	if sessionID != "" {
+		ucm.sendToUserRooms(userID, sessionID, msgType, data, requestID)
 	}
+}
 
+func (ucm *UserChannelManager) sendToSessions(userID, sessionID string, msgType string, data map[string]interface{}, requestID string) {
 	message := SSEMessage{
+		ID:        nextEventID(),
 		Type:      msgType,
 		RequestID: requestID,
+		Session:   sessionID,
 		Data:      data,
 		Timestamp: time.Now().Unix(),
 	}
 
-	select {
-	case channel.Channel <- message:
-	default:
-		log.Printf("⚠️  SSE channel full for user %s", userID)
+	// Logged even when the user is offline, so a reconnecting client gets it
+	event := sseMessageToEvent(userID, message)
+	ucm.history.Append(event)
+
+	ucm.mu.RLock()
+	store := ucm.store
+	ucm.mu.RUnlock()
+
+	if store != nil {
+		store.Append(event)
+	}
+
+	ucm.deliverToSessions(userID, message)
+	ucm.forward(event)
+}
+
+// SSE and WebSocket sessions connected to this node
+func (ucm *UserChannelManager) deliverToSessions(userID string, message SSEMessage) {
+	ucm.mu.RLock()
+	channels := make([]*SSEChannel, 0, len(ucm.sseChannels[userID]))
+	for id, channel := range ucm.sseChannels[userID] {
+		if message.Session == "" || id == message.Session {
+			channels = append(channels, channel)
+		}
+	}
+	ucm.mu.RUnlock()
+
+	ucm.sendToWebSockets(userID, message.Session, message)
+
+	for _, channel := range channels {
+		channel.send(message)
+	}
+}
+
+// SSE messages sent to a session after afterID (Last-Event-ID), the messages
+// targeted at other sessions are skipped
+func (ucm *UserChannelManager) ReplaySSE(userID, sessionID string, afterID uint64) []SSEMessage {
+	topic := UserTopic(userID, "sse")
+
+	ucm.mu.RLock()
+	store := ucm.store
+	ucm.mu.RUnlock()
+
+	var events []PubSubMessage
+	if store != nil {
+		var err error
+		events, err = store.Since(topic, afterID, 500)
+		if err != nil {
+			log.Printf("Error replaying SSE messages from store: %v", err)
+			events = nil
+		}
+	}
+	if events == nil {
+		events = ucm.history.Since(topic, afterID)
+	}
+
+	messages := make([]SSEMessage, 0, len(events))
+	for _, event := range events {
+		msg := eventToSSEMessage(event)
+		if msg.Session != "" && msg.Session != sessionID {
+			continue
+		}
+		messages = append(messages, msg)
 	}
+	return messages
 }
 
-// Close SSE channel
+// SSE messages are logged as events on the user.<id>.sse topic
+func sseMessageToEvent(userID string, msg SSEMessage) PubSubMessage {
+	payload := map[string]interface{}{
+		"type":       msg.Type,
+		"request_id": msg.RequestID,
+		"data":       msg.Data,
+		"timestamp":  msg.Timestamp,
+	}
+	if msg.Session != "" {
+		payload["session"] = msg.Session
+	}
+
+	return PubSubMessage{
+		ID:      msg.ID,
+		Topic:   UserTopic(userID, "sse"),
+		Payload: payload,
+	}
+}
+
+func eventToSSEMessage(event PubSubMessage) SSEMessage {
+	msg := SSEMessage{ID: event.ID}
+	msg.Type, _ = event.Payload["type"].(string)
+	msg.RequestID, _ = event.Payload["request_id"].(string)
+	msg.Session, _ = event.Payload["session"].(string)
+	msg.Data, _ = event.Payload["data"].(map[string]interface{})
+
+	switch ts := event.Payload["timestamp"].(type) {
+	case int64:
+		msg.Timestamp = ts
+	case float64:
+		msg.Timestamp = int64(ts)
+	}
+
+	return msg
+}
+
+// Close all the SSE channels of the user
 func (ucm *UserChannelManager) CloseSSEChannel(userID string) {
 	ucm.mu.Lock()
 	defer ucm.mu.Unlock()
 
-	if channel, exists := ucm.sseChannels[userID]; exists {
-		channel.IsActive = false
-		close(channel.Channel)
+	for _, channel := range ucm.sseChannels[userID] {
+		channel.mu.Lock()
+		channel.closeLocked()
+		channel.mu.Unlock()
+	}
+	if _, exists := ucm.sseChannels[userID]; exists {
 		delete(ucm.sseChannels, userID)
-		log.Printf("🔌 SSE Channel closed for user: %s", userID)
+		log.Printf("🔌 SSE Channels closed for user: %s", userID)
 	}
 }
 
-// ==================== USER ROOM (WebRTC) MANAGEMENT ====================
+// Close a single SSE session, the other sessions of the user stay open
+func (ucm *UserChannelManager) CloseSSESession(userID, sessionID string) {
+	ucm.mu.RLock()
+	channel, exists := ucm.sseChannels[userID][sessionID]
+	ucm.mu.RUnlock()
+
+	if exists {
+		ucm.closeSSEChannel(channel)
+	}
+}
 
-// Create or get user's dedicated room
-func (ucm *UserChannelManager) GetOrCreateUserRoom(userID string) (*UserRoom, error) {
+// Close a channel and forget it, unless its session was reopened meanwhile
+func (ucm *UserChannelManager) closeSSEChannel(channel *SSEChannel) {
 	ucm.mu.Lock()
 	defer ucm.mu.Unlock()
 
-	if room, exists := ucm.userRooms[userID]; exists {
-		return room, nil
+	channel.mu.Lock()
+	channel.closeLocked()
+	channel.mu.Unlock()
+
+	sessions := ucm.sseChannels[channel.UserID]
+	if sessions[channel.SessionID] != channel {
+		return
+	}
+
+	delete(sessions, channel.SessionID)
+	if len(sessions) == 0 {
+		delete(ucm.sseChannels, channel.UserID)
+	}
+	log.Printf("🔌 SSE Channel closed for user: %s (session %s)", channel.UserID, channel.SessionID)
+}
+
+// Buffer state of the SSE channels
+func (ucm *UserChannelManager) SSEStats() []SSEChannelStats {
+	ucm.mu.RLock()
+	defer ucm.mu.RUnlock()
+
+	stats := make([]SSEChannelStats, 0, len(ucm.sseChannels))
+	for userID, sessions := range ucm.sseChannels {
+		for sessionID, channel := range sessions {
+			channel.mu.RLock()
+			stats = append(stats, SSEChannelStats{
+				UserID:     userID,
+				SessionID:  sessionID,
+				Policy:     channel.Options.Policy,
+				BufferSize: channel.Options.BufferSize,
+				Pending:    len(channel.Channel),
+				Dropped:    channel.Dropped,
+			})
+			channel.mu.RUnlock()
+		}
+	}
+	return stats
+}
+
+// Active sessions of a user, all transports
+func (ucm *UserChannelManager) Sessions(userID string) []SessionInfo {
+	ucm.mu.RLock()
+	defer ucm.mu.RUnlock()
+
+	sessions := []SessionInfo{}
+
+	for _, channel := range ucm.sseChannels[userID] {
+		channel.mu.RLock()
+		if channel.IsActive {
+			sessions = append(sessions, SessionInfo{
+				SessionID:   channel.SessionID,
+				Transport:   "sse",
+				Device:      channel.Device,
+				Connected:   true,
+				ConnectedAt: channel.ConnectedAt,
+			})
+		}
+		channel.mu.RUnlock()
+	}
+
+	for _, room := range ucm.userRooms[userID] {
+		room.mu.RLock()
+		sessions = append(sessions, SessionInfo{
+			SessionID:   room.SessionID,
+			Transport:   "webrtc",
+			Device:      room.Device,
+			Connected:   room.IsConnected,
+			ConnectedAt: room.CreatedAt,
+		})
+		room.mu.RUnlock()
+	}
+
+	for client := range ucm.wsClients[userID] {
+		sessions = append(sessions, SessionInfo{
+			SessionID:   client.SessionID,
+			Transport:   "websocket",
+			Device:      client.Device,
+			Connected:   true,
+			ConnectedAt: client.ConnectedAt,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+
+	return sessions
+}
+
+// ==================== USER ROOM (WebRTC) MANAGEMENT ====================
+
+// Create the dedicated room of a session, an existing room with the same
+// session ID is closed and replaced
+func (ucm *UserChannelManager) CreateUserRoom(userID, sessionID, device string) *UserRoom {
+	if sessionID == "" {
+		sessionID = newSessionID()
 	}
 
 	room := &UserRoom{
 		UserID:       userID,
+		SessionID:    sessionID,
+		Device:       device,
 		IsConnected:  false,
+		fragments:    newFragmentAssembler(),
+		CreatedAt:    time.Now(),
 		LastActivity: time.Now(),
 	}
 
-	ucm.userRooms[userID] = room
-	log.Printf("🎯 User Room created for: %s", userID)
+	ucm.mu.Lock()
+	rooms := ucm.userRooms[userID]
+	if rooms == nil {
+		rooms = make(map[string]*UserRoom)
+		ucm.userRooms[userID] = rooms
+	}
+	previous := rooms[sessionID]
+	rooms[sessionID] = room
+	ucm.mu.Unlock()
+
+	if previous != nil {
+		previous.close()
+	}
+
+	log.Printf("🎯 User Room created for: %s (session %s)", userID, sessionID)
 
-	return room, nil
+	return room
 }
 
-// Connect user to their dedicated room
-func (ucm *UserChannelManager) ConnectToUserRoom(userID string) (*webrtc.SessionDescription, error) {
-	room, err := ucm.GetOrCreateUserRoom(userID)
-	if err != nil {
-		return nil, err
+func (room *UserRoom) close() {
+	room.mu.RLock()
+	participant := room.Participant
+	room.mu.RUnlock()
+
+	if participant != nil && participant.PeerConn != nil {
+		participant.PeerConn.Close()
+	}
+}
+
+// Forget a room, unless its session was reopened meanwhile
+func (ucm *UserChannelManager) removeUserRoom(room *UserRoom) {
+	ucm.mu.Lock()
+	defer ucm.mu.Unlock()
+
+	rooms := ucm.userRooms[room.UserID]
+	if rooms[room.SessionID] != room {
+		return
+	}
+
+	delete(rooms, room.SessionID)
+	if len(rooms) == 0 {
+		delete(ucm.userRooms, room.UserID)
 	}
+}
+
+// Connect a session to a new dedicated room, returns the session ID and the offer
+func (ucm *UserChannelManager) ConnectToUserRoom(userID, sessionID, device, remoteAddr string) (string, *webrtc.SessionDescription, error) {
+	room := ucm.CreateUserRoom(userID, sessionID, device)
+	sessionID = room.SessionID
+
+	room.mu.Lock()
+	room.RemoteAddr = remoteAddr
+	room.mu.Unlock()
 
 	// Create peer connection
 	pc, err := createPeerConnection()
 	if err != nil {
-		return nil, err
+		ucm.removeUserRoom(room)
+		return "", nil, err
 	}
 
 	participantID := generateID()
@@ -180,55 +610,53 @@ func (ucm *UserChannelManager) ConnectToUserRoom(userID string) (*webrtc.Session
 	// Create DataChannel
 	dc, err := pc.CreateDataChannel("api", nil)
 	if err != nil {
-		return nil, err
+		pc.Close()
+		ucm.removeUserRoom(room)
+		return "", nil, err
 	}
 
 	participant.DataChannel = dc
 
 	// Setup DataChannel handlers
 	dc.OnOpen(func() {
-		log.Printf("✅ User Room DataChannel opened for: %s", userID)
+		log.Printf("✅ User Room DataChannel opened for: %s (session %s)", userID, sessionID)
 		room.mu.Lock()
 		room.IsConnected = true
 		room.LastActivity = time.Now()
+		room.startWriterLocked(dc)
 		room.mu.Unlock()
 
 		// Send welcome message
-		ucm.SendToUserRoom(userID, "welcome", map[string]interface{}{
-			"message": "Connected to your dedicated room",
-			"user_id": userID,
+		ucm.sendToRoom(room, "welcome", map[string]interface{}{
+			"message":    "Connected to your dedicated room",
+			"user_id":    userID,
+			"session_id": sessionID,
 		}, "")
-
-		// Update presence to online
-		if locationManager != nil {
-			userLoc, exists := locationManager.GetLocation(userID)
-			if exists {
-				locationManager.UpdateLocation(userID, userLoc.Location, "online")
-			}
-		}
+		go ucm.deliverPendingNotifications(userID, sessionID)
+		presenceTracker.Connected(userID)
 	})
 
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
 		room.mu.Lock()
 		room.LastActivity = time.Now()
 		room.mu.Unlock()
+		presenceTracker.Heartbeat(userID)
 
-		ucm.handleUserRoomMessage(userID, msg.Data)
+		ucm.handleUserRoomMessage(room, msg.Data)
 	})
 
 	dc.OnClose(func() {
-		log.Printf("🔌 User Room DataChannel closed for: %s", userID)
+		log.Printf("🔌 User Room DataChannel closed for: %s (session %s)", userID, sessionID)
 		room.mu.Lock()
 		room.IsConnected = false
+		room.closeQueueLocked()
 		room.mu.Unlock()
 
-		// Update presence to offline
-		if locationManager != nil {
-			userLoc, exists := locationManager.GetLocation(userID)
-			if exists {
-				locationManager.UpdateLocation(userID, userLoc.Location, "offline")
-			}
-		}
+		ucm.removeUserRoom(room)
+		pc.Close()
+
+		// Offline once the last session is gone
+		presenceTracker.Disconnected(userID)
 	})
 
 	room.mu.Lock()
@@ -238,52 +666,98 @@ func (ucm *UserChannelManager) ConnectToUserRoom(userID string) (*webrtc.Session
 
 	// Create offer
 	offer, err := pc.CreateOffer(nil)
-	if err != nil {
-		return nil, err
+	if err == nil {
+		err = pc.SetLocalDescription(offer)
 	}
-
-	if err := pc.SetLocalDescription(offer); err != nil {
-		return nil, err
+	if err != nil {
+		pc.Close()
+		ucm.removeUserRoom(room)
+		return "", nil, err
 	}
 
-	return &offer, nil
+	return sessionID, &offer, nil
 }
 
-// Handle answer from client
-func (ucm *UserChannelManager) HandleUserRoomAnswer(userID string, answer webrtc.SessionDescription) error {
+// Handle answer from client. Without session ID, the latest room waiting
+// for an answer is used
+func (ucm *UserChannelManager) HandleUserRoomAnswer(userID, sessionID string, answer webrtc.SessionDescription) error {
 	ucm.mu.RLock()
-	room, exists := ucm.userRooms[userID]
+	var room *UserRoom
+	if sessionID != "" {
+		room = ucm.userRooms[userID][sessionID]
+	} else {
+		for _, candidate := range ucm.userRooms[userID] {
+			candidate.mu.RLock()
+			pending := candidate.Participant != nil && candidate.Participant.PeerConn.RemoteDescription() == nil
+			candidate.mu.RUnlock()
+			if pending && (room == nil || candidate.CreatedAt.After(room.CreatedAt)) {
+				room = candidate
+			}
+		}
+	}
 	ucm.mu.RUnlock()
 
-	if !exists {
+	if room == nil {
 		return fmt.Errorf("user room not found")
 	}
 
 	room.mu.RLock()
-	pc := room.Participant.PeerConn
+	participant := room.Participant
 	room.mu.RUnlock()
 
-	return pc.SetRemoteDescription(answer)
+	if participant == nil {
+		return fmt.Errorf("user room not ready")
+	}
+
+	return participant.PeerConn.SetRemoteDescription(answer)
 }
 
-// Send message to user's room via DataChannel
+// Send message to all the connected rooms of the user via DataChannel
 func (ucm *UserChannelManager) SendToUserRoom(userID string, msgType string, data map[string]interface{}, requestID string) {
+	ucm.sendToUserRooms(userID, "", msgType, data, requestID)
+}
+
+// Send to the rooms of the user, or only to the given session
+func (ucm *UserChannelManager) sendToUserRooms(userID, sessionID string, msgType string, data map[string]interface{}, requestID string) {
+	ucm.deliverToUserRooms(userID, sessionID, msgType, data, requestID)
+
 	ucm.mu.RLock()
-	room, exists := ucm.userRooms[userID]
+	relay := ucm.relay
 	ucm.mu.RUnlock()
-
-	if !exists || !room.IsConnected {
+	if relay == nil {
 		return
 	}
 
-	room.mu.RLock()
-	dc := room.DataChannel
-	room.mu.RUnlock()
+	event := sseMessageToEvent(userID, SSEMessage{
+		ID:        nextEventID(),
+		Type:      msgType,
+		RequestID: requestID,
+		Session:   sessionID,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	})
+	event.Payload["transport"] = "datachannel"
+	relay.Forward(event)
+}
 
-	if dc == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
-		return
+// User rooms connected to this node
+func (ucm *UserChannelManager) deliverToUserRooms(userID, sessionID string, msgType string, data map[string]interface{}, requestID string) {
+	ucm.mu.RLock()
+	rooms := make([]*UserRoom, 0, len(ucm.userRooms[userID]))
+	for id, room := range ucm.userRooms[userID] {
+		if sessionID == "" || id == sessionID {
+			rooms = append(rooms, room)
+		}
 	}
+	ucm.mu.RUnlock()
 
+	for _, room := range rooms {
+		ucm.sendToRoom(room, msgType, data, requestID)
+	}
+}
+
+// Queued for the DataChannel writer, never waits for the peer
+func (ucm *UserChannelManager) sendToRoom(room *UserRoom, msgType string, data map[string]interface{}, requestID string) {
 	response := map[string]interface{}{
 		"type":       msgType,
 		"request_id": requestID,
@@ -297,441 +771,162 @@ func (ucm *UserChannelManager) SendToUserRoom(userID string, msgType string, dat
 		return
 	}
 
-	if err := dc.Send(payload); err != nil {
-		log.Printf("Error sending to user room: %v", err)
-	}
+	room.enqueue(payload)
 }
 
 // Handle incoming messages from user room
-func (ucm *UserChannelManager) handleUserRoomMessage(userID string, data []byte) {
-	var req APIRequest
-	if err := msgpack.Unmarshal(data, &req); err != nil {
+func (ucm *UserChannelManager) handleUserRoomMessage(room *UserRoom, data []byte) {
+	var frame struct {
+		Type string `msgpack:"type"`
+	}
+	if err := msgpack.Unmarshal(data, &frame); err != nil {
 		log.Printf("Error unmarshaling request: %v", err)
 		return
 	}
 
-	log.Printf("📨 API Request via WebRTC from %s: %s %s", userID, req.Method, req.Endpoint)
-
-	// Execute API request
-	go ucm.executeAPIRequest(userID, req)
-}
-
-// Execute API request received via WebRTC
-func (ucm *UserChannelManager) executeAPIRequest(userID string, req APIRequest) {
-	response := APIResponse{
-		RequestID: req.RequestID,
-		Timestamp: time.Now().Unix(),
-	}
-
-	// Route to appropriate handler
-	result, statusCode, err := ucm.routeAPIRequest(userID, req)
-
-	response.StatusCode = statusCode
-	if err != nil {
-		response.Error = err.Error()
-	} else {
-		response.Data = result
-	}
-
-	// Send response
-	payload, _ := msgpack.Marshal(response)
-
-	ucm.mu.RLock()
-	room, exists := ucm.userRooms[userID]
-	ucm.mu.RUnlock()
-
-	if exists && room.IsConnected && room.DataChannel != nil {
-		room.DataChannel.Send(payload)
-	}
-}
-
-// Route API requests to appropriate handlers
-func (ucm *UserChannelManager) routeAPIRequest(userID string, req APIRequest) (map[string]interface{}, int, error) {
-	switch req.Endpoint {
-	// Location APIs
-	case "/location/update":
-		return ucm.handleLocationUpdate(userID, req.Body)
-	case "/location/nearby":
-		return ucm.handleLocationNearby(userID, req.Body)
-	case "/location/polygon":
-		return ucm.handleLocationPolygon(userID, req.Body)
-
-	// Social APIs
-	case "/posts":
-		if req.Method == "GET" {
-			return ucm.handleGetPosts(userID, req.Query)
-		} else if req.Method == "POST" {
-			return ucm.handleCreatePost(userID, req.Body)
+	// Large requests arrive in fragments
+	if frame.Type == "fragment" {
+		var fragment dataChannelFragment
+		if err := msgpack.Unmarshal(data, &fragment); err != nil {
+			log.Printf("Error unmarshaling fragment: %v", err)
+			return
 		}
-	case "/posts/like":
-		return ucm.handleLikePost(userID, req.Body)
-	case "/posts/comment":
-		return ucm.handleCommentPost(userID, req.Body)
-
-	// Marketplace APIs
-	case "/articles/buy":
-		return ucm.handleBuyArticle(userID, req.Body)
-	case "/articles":
-		return ucm.handleGetArticles(userID, req.Query)
-
-	// Presence
-	case "/presence/update":
-		return ucm.handlePresenceUpdate(userID, req.Body)
-
-	// Rooms
-	case "/rooms/join":
-		return ucm.handleJoinRoom(userID, req.Body)
-	case "/rooms/leave":
-		return ucm.handleLeaveRoom(userID, req.Body)
-
-	default:
-		return nil, 404, fmt.Errorf("endpoint not found: %s", req.Endpoint)
-	}
-
-	return nil, 500, fmt.Errorf("unhandled request")
-}
-
-// ==================== API HANDLERS (WebRTC) ====================
-
-func (ucm *UserChannelManager) handleLocationUpdate(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	locationData, ok := body["location"].(map[string]interface{})
-	if !ok {
-		return nil, 400, fmt.Errorf("invalid location data")
-	}
-
-	point, ok := locationData["point"].(map[string]interface{})
-	if !ok {
-		return nil, 400, fmt.Errorf("invalid point data")
-	}
-
-	location := Location{
-		Point: Point{
-			Lat: point["lat"].(float64),
-			Lng: point["lng"].(float64),
-		},
-		Accuracy:  getFloat64(locationData, "accuracy"),
-		Altitude:  getFloat64(locationData, "altitude"),
-		Speed:     getFloat64(locationData, "speed"),
-		Heading:   getFloat64(locationData, "heading"),
-		Timestamp: time.Now(),
-	}
-
-	presence := getString(body, "presence", "online")
-
-	if err := locationManager.UpdateLocation(userID, location, presence); err != nil {
-		return nil, 500, err
-	}
-
-	return map[string]interface{}{
-		"success": true,
-		"user_id": userID,
-	}, 200, nil
-}
 
-func (ucm *UserChannelManager) handleLocationNearby(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	point, ok := body["point"].(map[string]interface{})
-	if !ok {
-		return nil, 400, fmt.Errorf("invalid point")
-	}
-
-	radius := getFloat64(body, "radius")
-
-	p := Point{
-		Lat: point["lat"].(float64),
-		Lng: point["lng"].(float64),
-	}
-
-	nearby := locationManager.FindNearby(p, radius, userID)
-
-	users := make([]map[string]interface{}, len(nearby))
-	for i, u := range nearby {
-		users[i] = map[string]interface{}{
-			"user_id":  u.UserID,
-			"location": u.Location,
-			"presence": u.Presence,
+		message, err := room.fragments.Add(fragment)
+		if err != nil {
+			log.Printf("Dropping fragmented message from %s: %v", room.UserID, err)
+			return
 		}
-	}
-
-	return map[string]interface{}{
-		"count": len(nearby),
-		"users": users,
-	}, 200, nil
-}
-
-func (ucm *UserChannelManager) handleLocationPolygon(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	polygonData, ok := body["polygon"].([]interface{})
-	if !ok {
-		return nil, 400, fmt.Errorf("invalid polygon")
-	}
-
-	polygon := make([]Point, len(polygonData))
-	for i, p := range polygonData {
-		point := p.(map[string]interface{})
-		polygon[i] = Point{
-			Lat: point["lat"].(float64),
-			Lng: point["lng"].(float64),
+		if message == nil {
+			return // incomplete
 		}
-	}
-
-	users := locationManager.FindInPolygon(polygon)
+		data = message
 
-	result := make([]map[string]interface{}, len(users))
-	for i, u := range users {
-		result[i] = map[string]interface{}{
-			"user_id":  u.UserID,
-			"location": u.Location,
-			"presence": u.Presence,
+		// The type of the reassembled message, not of its fragments
+		frame.Type = ""
+		if err := msgpack.Unmarshal(data, &frame); err != nil {
+			log.Printf("Error unmarshaling fragmented message from %s: %v", room.UserID, err)
+			return
+		}
+		if frame.Type == "fragment" {
+			log.Printf("Dropping nested fragment from %s", room.UserID)
+			return
 		}
 	}
 
-	return map[string]interface{}{
-		"count": len(users),
-		"users": result,
-	}, 200, nil
-}
-
-func (ucm *UserChannelManager) handleGetPosts(userID string, query map[string]string) (map[string]interface{}, int, error) {
-	filter := query["filter"]
-	if filter == "" {
-		filter = "isPublic = true"
-	}
-
-	posts, err := ucm.app.FindRecordsByFilter("posts", filter, "-created", 20, 0)
-	if err != nil {
-		return nil, 500, err
+	// Heartbeat, the presence is already refreshed by OnMessage
+	if frame.Type == "ping" {
+		room.sendFrame(map[string]interface{}{"type": "pong", "timestamp": time.Now().Unix()})
+		return
 	}
 
-	result := make([]map[string]interface{}, len(posts))
-	for i, post := range posts {
-		result[i] = recordToMap(post)
+	var req APIRequest
+	if err := msgpack.Unmarshal(data, &req); err != nil {
+		log.Printf("Error unmarshaling request: %v", err)
+		return
 	}
 
-	return map[string]interface{}{
-		"posts": result,
-		"count": len(posts),
-	}, 200, nil
-}
-
-func (ucm *UserChannelManager) handleCreatePost(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	r, err := ucm.app.FindCollectionByNameOrId("posts")
-	if err != nil {
-		return nil, 500, err
+	// {"type":"cancel","request_id":"..."} stops a queued or running request
+	if frame.Type == "cancel" {
+		if !ucm.rpc.Cancel(room.UserID, room.SessionID, req.RequestID) {
+			log.Printf("Cancel of unknown request %s from %s", req.RequestID, room.UserID)
+		}
+		return
 	}
-	post := core.NewRecord(r)
 
-	post.Set("user", userID)
-	post.Set("type", getString(body, "type", "html"))
-	post.Set("content", body["content"])
-	post.Set("isPublic", getBool(body, "isPublic", true))
-	post.Set("likesCount", 0)
-	post.Set("commentsCount", 0)
+	log.Printf("📨 API Request via WebRTC from %s (session %s): %s %s", room.UserID, room.SessionID, req.Method, req.Endpoint)
 
-	if err := ucm.app.Save(post); err != nil {
-		return nil, 500, err
+	if err := rateLimiter.AllowRPC(room.UserID); err != nil {
+		room.sendFrame(APIResponse{
+			RequestID:  req.RequestID,
+			StatusCode: 429,
+			Error:      err.Error(),
+			Timestamp:  time.Now().Unix(),
+		})
+		return
 	}
 
-	// Broadcast event
-	pubsub.Publish("post_events", PubSubMessage{
-		Topic: "post_events",
-		Payload: map[string]interface{}{
-			"type":    "new_post",
-			"post_id": post.Id,
-			"user_id": userID,
-		},
+	// Execute API request, at most rpc.Concurrency at a time per user
+	err := ucm.rpc.Submit(room.UserID, room.SessionID, req.RequestID, req.TimeoutMs, func(ctx context.Context) {
+		ucm.executeAPIRequest(ctx, room, req)
 	})
-
-	return map[string]interface{}{
-		"success": true,
-		"post_id": post.Id,
-		"post":    recordToMap(post),
-	}, 201, nil
-}
-
-func (ucm *UserChannelManager) handleLikePost(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	postID := getString(body, "post_id", "")
-	reaction := getString(body, "reaction", "like")
-
-	if postID == "" {
-		return nil, 400, fmt.Errorf("post_id required")
-	}
-	r, err := ucm.app.FindCollectionByNameOrId("likes")
-	if err != nil {
-		return nil, 500, err
-	}
-
-	like := core.NewRecord(r)
-	like.Set("user", userID)
-	like.Set("post", postID)
-	like.Set("reaction", reaction)
-
-	if err := ucm.app.Save(like); err != nil {
-		return nil, 500, err
-	}
-
-	// Update post likes count
-	post, _ := ucm.app.FindRecordById("posts", postID)
-	if post != nil {
-		count := post.GetInt("likesCount")
-		post.Set("likesCount", count+1)
-		ucm.app.Save(post)
-	}
-
-	return map[string]interface{}{
-		"success": true,
-		"like_id": like.Id,
-	}, 200, nil
-}
-
-func (ucm *UserChannelManager) handleCommentPost(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	postID := getString(body, "post_id", "")
-	content := getString(body, "content", "")
-
-	if postID == "" || content == "" {
-		return nil, 400, fmt.Errorf("post_id and content required")
-	}
-
-	r, err := ucm.app.FindCollectionByNameOrId("comments")
 	if err != nil {
-		return nil, 500, err
-	}
-	comment := core.NewRecord(r)
-	comment.Set("user", userID)
-	comment.Set("post", postID)
-	comment.Set("content", content)
-
-	if err := ucm.app.Save(comment); err != nil {
-		return nil, 500, err
-	}
-
-	// Update comments count
-	post, _ := ucm.app.FindRecordById("posts", postID)
-	if post != nil {
-		count := post.GetInt("commentsCount")
-		post.Set("commentsCount", count+1)
-		ucm.app.Save(post)
+		room.sendFrame(APIResponse{
+			RequestID:  req.RequestID,
+			StatusCode: rpcErrorStatus(err),
+			Error:      err.Error(),
+			Timestamp:  time.Now().Unix(),
+		})
 	}
-
-	return map[string]interface{}{
-		"success":    true,
-		"comment_id": comment.Id,
-	}, 201, nil
 }
 
-func (ucm *UserChannelManager) handleBuyArticle(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	articleID := getString(body, "article_id", "")
-
-	if articleID == "" {
-		return nil, 400, fmt.Errorf("article_id required")
-	}
-
-	article, err := ucm.app.FindRecordById("articles", articleID)
-	if err != nil {
-		return nil, 404, fmt.Errorf("article not found")
-	}
-
-	quantite := article.GetInt("quantite")
-	if quantite <= 0 {
-		return nil, 400, fmt.Errorf("out of stock")
-	}
-
-	prix := article.GetFloat("prix")
-
-	// Create vente
-
-	r, err := ucm.app.FindCollectionByNameOrId("ventesArticle")
-	if err != nil {
-		return nil, 500, err
-	}
+// Execute API request received via WebRTC, the response goes back to the
+// session that sent it. Streamed responses are sent as chunk frames
+// followed by an end frame.
+func (ucm *UserChannelManager) executeAPIRequest(ctx context.Context, room *UserRoom, req APIRequest) {
+	room.mu.RLock()
+	remoteAddr := room.RemoteAddr
+	room.mu.RUnlock()
 
-	vente := core.NewRecord(r)
-	vente.Set("article", articleID)
-	vente.Set("montant", prix)
-	vente.Set("status", "encours")
-	vente.Set("user", userID)
+	seq := 0
+	result, statusCode, streamed, err := routerBridge.DispatchStream(ctx, room.UserID, remoteAddr, req, func(chunk []byte) {
+		payload, err := msgpack.Marshal(APIStreamFrame{
+			Type:      "chunk",
+			RequestID: req.RequestID,
+			Seq:       seq,
+			Data:      chunk,
+		})
+		if err != nil {
+			log.Printf("Error marshaling response: %v", err)
+			return
+		}
+		room.enqueueWait(ctx, payload)
+		seq++
+	})
 
-	if err := ucm.app.Save(vente); err != nil {
-		return nil, 500, err
+	if streamed {
+		end := APIStreamFrame{
+			Type:       "end",
+			RequestID:  req.RequestID,
+			Seq:        seq,
+			StatusCode: statusCode,
+		}
+		if err != nil {
+			end.Error = err.Error()
+		}
+		room.sendFrame(end)
+		return
 	}
 
-	// Update stock
-	article.Set("quantite", quantite-1)
-	ucm.app.Save(article)
-
-	return map[string]interface{}{
-		"success":  true,
-		"vente_id": vente.Id,
-		"amount":   prix,
-	}, 200, nil
-}
-
-func (ucm *UserChannelManager) handleGetArticles(userID string, query map[string]string) (map[string]interface{}, int, error) {
-	filter := query["filter"]
-	if filter == "" {
-		filter = "quantite > 0"
+	response := APIResponse{
+		RequestID:  req.RequestID,
+		StatusCode: statusCode,
+		Timestamp:  time.Now().Unix(),
 	}
-
-	articles, err := ucm.app.FindRecordsByFilter("articles", filter, "-created", 20, 0)
 	if err != nil {
-		return nil, 500, err
-	}
-
-	result := make([]map[string]interface{}, len(articles))
-	for i, article := range articles {
-		result[i] = recordToMap(article)
+		response.Error = err.Error()
+	} else {
+		response.Data = result
 	}
 
-	return map[string]interface{}{
-		"articles": result,
-		"count":    len(articles),
-	}, 200, nil
+	room.sendFrame(response)
 }
 
-func (ucm *UserChannelManager) handlePresenceUpdate(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	presence := getString(body, "presence", "online")
-
-	user, err := ucm.app.FindRecordById("users", userID)
+// Queue a frame for the DataChannel of the room, fragmented by the writer
+func (room *UserRoom) sendFrame(frame interface{}) {
+	payload, err := msgpack.Marshal(frame)
 	if err != nil {
-		return nil, 404, err
-	}
-
-	user.Set("presence", presence)
-	user.Set("lastSeen", time.Now())
-
-	if err := ucm.app.Save(user); err != nil {
-		return nil, 500, err
-	}
-
-	return map[string]interface{}{
-		"success":  true,
-		"presence": presence,
-	}, 200, nil
-}
-
-func (ucm *UserChannelManager) handleJoinRoom(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	roomID := getString(body, "room_id", "")
-	if roomID == "" {
-		return nil, 400, fmt.Errorf("room_id required")
+		log.Printf("Error marshaling response: %v", err)
+		return
 	}
 
-	// Logic to join room (simplified)
-	return map[string]interface{}{
-		"success": true,
-		"room_id": roomID,
-		"message": "Joined room",
-	}, 200, nil
+	room.enqueue(payload)
 }
 
-func (ucm *UserChannelManager) handleLeaveRoom(userID string, body map[string]interface{}) (map[string]interface{}, int, error) {
-	roomID := getString(body, "room_id", "")
-	if roomID == "" {
-		return nil, 400, fmt.Errorf("room_id required")
-	}
-
-	return map[string]interface{}{
-		"success": true,
-		"room_id": roomID,
-		"message": "Left room",
-	}, 200, nil
+// Route API requests through the HTTP router, authenticated as the user
+func (ucm *UserChannelManager) routeAPIRequest(ctx context.Context, userID, remoteAddr string, req APIRequest) (map[string]interface{}, int, error) {
+	data, status, _, err := routerBridge.DispatchStream(ctx, userID, remoteAddr, req, nil)
+	return data, status, err
 }
 
 // ==================== HTTP HANDLERS ====================
@@ -740,40 +935,84 @@ func (ucm *UserChannelManager) handleLeaveRoom(userID string, body map[string]in
 func handleUserSSE(c *core.RequestEvent) error {
 	userID := c.Get("userID").(string)
 
+	opts, err := subscribeOptionsFromRequest(c)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
 	c.Response.Header().Set("Content-Type", "text/event-stream")
 	c.Response.Header().Set("Cache-Control", "no-cache")
 	c.Response.Header().Set("Connection", "keep-alive")
 	c.Response.Header().Set("X-Accel-Buffering", "no")
 
-	channel := userChannelManager.GetOrCreateSSEChannel(userID)
+	// ?session= lets a reconnecting device keep its session
+	query := c.Request.URL.Query()
+	channel := userChannelManager.OpenSSESession(userID, query.Get("session"), sessionDevice(c), opts)
+	sessionID := channel.SessionID
+
+	presenceTracker.Connected(userID)
 
-	log.Printf("📡 SSE connection established for user: %s", userID)
+	// Closed with the request, the other sessions of the user stay open
+	go func() {
+		<-c.Request.Context().Done()
+		userChannelManager.closeSSEChannel(channel)
+		presenceTracker.Disconnected(userID)
+	}()
+
+	log.Printf("📡 SSE connection established for user: %s (session %s)", userID, sessionID)
 
 	// Send initial connection message
 	data, _ := json.Marshal(map[string]interface{}{
-		"type":    "connected",
-		"user_id": userID,
-		"message": "SSE channel ready",
+		"type":       "connected",
+		"user_id":    userID,
+		"session_id": sessionID,
+		"message":    "SSE channel ready",
 	})
-	fmt.Fprintf(c.Response, "data: %s\n\n", data)
-	if f, ok := c.Response.(http.Flusher); ok {
-		f.Flush()
+	writeSSEEvent(c, 0, data)
+
+	// Replay what was missed while disconnected
+	var lastSent uint64
+	if lastID := lastEventIDFromRequest(c); lastID > 0 {
+		for _, msg := range userChannelManager.ReplaySSE(userID, sessionID, lastID) {
+			if !opts.Filter.Match(sseMessageToEvent(userID, msg)) {
+				continue
+			}
+
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			writeSSEEvent(c, msg.ID, data)
+			lastSent = msg.ID
+		}
 	}
 
+	// Notifications stored while the user was offline
+	go userChannelManager.deliverPendingNotifications(userID, sessionID)
+
 	// Listen for messages
 	for msg := range channel.Channel {
+		if msg.ID <= lastSent {
+			continue // already replayed
+		}
+
 		data, err := json.Marshal(msg)
 		if err != nil {
 			continue
 		}
 
-		fmt.Fprintf(c.Response, "data: %s\n\n", data)
-		if f, ok := c.Response.(http.Flusher); ok {
-			f.Flush()
-		}
+		writeSSEEvent(c, msg.ID, data)
 	}
 
-	userChannelManager.CloseSSEChannel(userID)
+	// Tell the client why the stream ends
+	channel.mu.RLock()
+	reason, dropped := channel.Reason, channel.Dropped
+	channel.mu.RUnlock()
+	if reason != "" {
+		writeSSEDisconnect(c, reason, dropped)
+	}
+
+	userChannelManager.closeSSEChannel(channel)
 	return nil
 }
 
@@ -781,14 +1020,16 @@ func handleUserSSE(c *core.RequestEvent) error {
 func handleConnectUserRoom(c *core.RequestEvent) error {
 	userID := c.Get("userID").(string)
 
-	offer, err := userChannelManager.ConnectToUserRoom(userID)
+	query := c.Request.URL.Query()
+	sessionID, offer, err := userChannelManager.ConnectToUserRoom(userID, query.Get("session"), sessionDevice(c), transportRemoteAddr(c))
 	if err != nil {
 		return c.JSON(500, map[string]string{"error": err.Error()})
 	}
 
 	return c.JSON(200, map[string]interface{}{
-		"user_id": userID,
-		"sdp":     offer,
+		"user_id":    userID,
+		"session_id": sessionID,
+		"sdp":        offer,
 	})
 }
 
@@ -796,18 +1037,76 @@ func handleConnectUserRoom(c *core.RequestEvent) error {
 func handleUserRoomAnswer(c *core.RequestEvent) error {
 	userID := c.Get("userID").(string)
 
-	var answer webrtc.SessionDescription
+	var answer struct {
+		webrtc.SessionDescription
+		SessionID string `json:"session_id"`
+	}
 	if err := c.BindBody(&answer); err != nil {
 		return c.JSON(400, map[string]string{"error": "invalid SDP"})
 	}
 
-	if err := userChannelManager.HandleUserRoomAnswer(userID, answer); err != nil {
+	sessionID := answer.SessionID
+	if sessionID == "" {
+		sessionID = c.Request.URL.Query().Get("session")
+	}
+
+	if err := userChannelManager.HandleUserRoomAnswer(userID, sessionID, answer.SessionDescription); err != nil {
 		return c.JSON(500, map[string]string{"error": err.Error()})
 	}
 
 	return c.JSON(200, map[string]string{"status": "connected"})
 }
 
+// List the active sessions of the user (SSE, user rooms, WebSockets)
+func handleUserSessions(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	sessions := userChannelManager.Sessions(userID)
+
+	return c.JSON(200, map[string]interface{}{
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+// Device label of a session: ?device=, else the User-Agent
+func sessionDevice(c *core.RequestEvent) string {
+	device := c.Request.URL.Query().Get("device")
+	if device == "" {
+		device = c.Request.UserAgent()
+	}
+	if len(device) > 200 {
+		device = device[:200]
+	}
+	return device
+}
+
+// Update the user's presence status
+func handleUpdatePresence(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	var req struct {
+		Presence string `json:"presence"`
+	}
+	if err := c.BindBody(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+	if req.Presence == "" {
+		req.Presence = "online"
+	}
+	if !validPresence(req.Presence) {
+		return c.JSON(400, map[string]string{"error": "presence must be online, away, busy or offline"})
+	}
+
+	// Saved on the user and sent as presence_changed
+	presenceTracker.SetManual(userID, req.Presence)
+
+	return c.JSON(200, map[string]interface{}{
+		"success":  true,
+		"presence": req.Presence,
+	})
+}
+
 // Utility functions
 func getString(m map[string]interface{}, key, defaultVal string) string {
 	if val, ok := m[key].(string); ok {
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrCreateSSEChannelSkipsClosed(t *testing.T) {
+	app := newTestApp(t)
+	ucm := NewUserChannelManager(app)
+
+	slow := ucm.OpenSSESession("alice", "s1", "", SubscribeOptions{BufferSize: 1, Policy: PolicyDisconnect})
+
+	// A slow consumer is closed by send(), without the manager lock
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			slow.send(SSEMessage{Type: "ping"})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		ucm.GetOrCreateSSEChannel("alice")
+	}
+	wg.Wait()
+
+	require.False(t, slow.active())
+	assert.Equal(t, reasonSlowConsumer, slow.Reason)
+
+	channel := ucm.GetOrCreateSSEChannel("alice")
+	assert.NotSame(t, slow, channel)
+	assert.True(t, channel.active())
+	assert.Same(t, channel, ucm.GetOrCreateSSEChannel("alice"))
+}
+
+func TestConnectToUserRoom(t *testing.T) {
+	app := newTestApp(t)
+
+	saved := userChannelManager
+	userChannelManager = NewUserChannelManager(app)
+	t.Cleanup(func() { userChannelManager = saved })
+
+	ucm := userChannelManager
+	sessionID, offer, err := ucm.ConnectToUserRoom("alice", "", "test", "198.51.100.4:0")
+	require.NoError(t, err)
+	require.NotNil(t, offer)
+	assert.NotEmpty(t, sessionID)
+
+	ucm.mu.RLock()
+	room := ucm.userRooms["alice"][sessionID]
+	ucm.mu.RUnlock()
+	require.NotNil(t, room)
+	assert.Equal(t, "198.51.100.4:0", room.RemoteAddr)
+
+	room.Participant.PeerConn.Close()
+	ucm.removeUserRoom(room)
+
+	ucm.mu.RLock()
+	defer ucm.mu.RUnlock()
+	assert.Empty(t, ucm.userRooms["alice"])
+}
@@ -0,0 +1,610 @@
+package app
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// ==================== WEB PUSH ====================
+
+// Notifications of users without a connected session are sent with Web Push
+// (RFC 8030), to every browser subscription of the user: payloads encrypted
+// with aes128gcm (RFC 8291), VAPID authentication (RFC 8292).
+
+const (
+	maxPushPayload = 4096 - 16 - 4 - 1 - 65 - 16 - 1 // record size minus header, tag and delimiter
+	pushTTL        = 24 * time.Hour
+	vapidTokenTTL  = 12 * time.Hour
+)
+
+var errPushEndpointForbidden = errors.New("push endpoint not allowed")
+
+// Push services are public https servers. The endpoints come from the
+// browsers, they must not make the server reach its own network.
+func checkPushEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return fmt.Errorf("invalid endpoint")
+	}
+	if port := u.Port(); port != "" && port != "443" {
+		return errPushEndpointForbidden
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errPushEndpointForbidden
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicPushIP(ip) {
+		return errPushEndpointForbidden
+	}
+	return nil
+}
+
+// Shared address space (RFC 6598), not covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicPushIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// HTTP client refusing to connect to non public addresses, names resolving
+// to one included
+func newPushHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicPushIP(ip) {
+				return fmt.Errorf("%w: %s", errPushEndpointForbidden, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // the check applies to the push service itself
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// VAPIDKeys - Clés du serveur d'application, encodées en base64url comme
+// attendu par PushManager.subscribe({applicationServerKey})
+type VAPIDKeys struct {
+	PublicKey  string `json:"publicKey"`  // uncompressed P-256 point
+	PrivateKey string `json:"privateKey"` // raw P-256 scalar
+}
+
+func GenerateVAPIDKeys() (VAPIDKeys, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return VAPIDKeys{}, err
+	}
+
+	return VAPIDKeys{
+		PublicKey:  base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+		PrivateKey: base64.RawURLEncoding.EncodeToString(key.Bytes()),
+	}, nil
+}
+
+// Load the keys saved in the data dir, generated on first start
+func LoadOrCreateVAPIDKeys(app core.App) (VAPIDKeys, error) {
+	var keys VAPIDKeys
+	path := filepath.Join(app.DataDir(), "vapid_keys.json")
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return keys, fmt.Errorf("invalid VAPID keys file %s: %w", path, err)
+		}
+		return keys, nil
+	}
+	if !os.IsNotExist(err) {
+		return keys, err
+	}
+
+	keys, err = GenerateVAPIDKeys()
+	if err != nil {
+		return keys, err
+	}
+
+	data, _ = json.MarshalIndent(keys, "", "  ")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return keys, err
+	}
+
+	log.Printf("🔑 VAPID keys generated in %s", path)
+	return keys, nil
+}
+
+// PushSubscription - Abonnement d'un navigateur (PushSubscription.toJSON())
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// WebPushService - Envoi des notifications aux abonnements Web Push
+type WebPushService struct {
+	publicKey  []byte
+	privateKey *ecdsa.PrivateKey
+	subject    string // mailto: or https: contact of the push services
+	client     *http.Client
+	app        core.App
+}
+
+func NewWebPushService(app core.App, keys VAPIDKeys, subject string) (*WebPushService, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(keys.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+
+	// The ECDSA key of the same scalar, to sign the VAPID tokens
+	point := key.PublicKey().Bytes()
+	signer := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(point[1:33]),
+			Y:     new(big.Int).SetBytes(point[33:]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}
+
+	if subject == "" {
+		subject = "mailto:admin@localhost"
+	}
+
+	return &WebPushService{
+		publicKey:  point,
+		privateKey: signer,
+		subject:    subject,
+		client:     newPushHTTPClient(),
+		app:        app,
+	}, nil
+}
+
+// Replace the HTTP client, and with it the check of the push service
+// addresses (a local push service in tests)
+func (wp *WebPushService) SetHTTPClient(client *http.Client) {
+	wp.client = client
+}
+
+// Public key to give to the browsers, base64url
+func (wp *WebPushService) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(wp.publicKey)
+}
+
+// Send a notification to every subscription of the user, unless opted out.
+// Subscriptions gone from the push service (404, 410) are deleted.
+func (wp *WebPushService) Notify(userID, eventType, notificationID string, data map[string]interface{}) {
+	if wp == nil || !wp.Enabled(userID) {
+		return
+	}
+
+	records, err := wp.app.FindAllRecords("pushSubscriptions", dbx.HashExp{"user": userID})
+	if err != nil || len(records) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":            eventType,
+		"notification_id": notificationID,
+		"data":            data,
+	})
+	if err == nil && len(payload) > maxPushPayload {
+		// Too large: the client fetches it from the inbox
+		payload, err = json.Marshal(map[string]interface{}{
+			"type":            eventType,
+			"notification_id": notificationID,
+		})
+	}
+	if err != nil {
+		log.Printf("Error marshaling push payload: %v", err)
+		return
+	}
+
+	for _, record := range records {
+		var sub PushSubscription
+		sub.Endpoint = record.GetString("endpoint")
+		sub.Keys.P256dh = record.GetString("p256dh")
+		sub.Keys.Auth = record.GetString("auth")
+
+		status, err := wp.Send(sub, payload)
+		switch {
+		case status == http.StatusNotFound || status == http.StatusGone:
+			if err := wp.app.Delete(record); err != nil {
+				log.Printf("Error deleting push subscription %s: %v", record.Id, err)
+			}
+		case err != nil:
+			log.Printf("Error sending push to %s: %v", userID, err)
+		default:
+			record.Set("lastUsedAt", time.Now())
+			wp.app.Save(record)
+		}
+	}
+}
+
+// Check the user's opt-out, enabled without settings
+func (wp *WebPushService) Enabled(userID string) bool {
+	if wp == nil {
+		return false
+	}
+
+	settings, err := wp.app.FindFirstRecordByFilter("pushSettings", "user = {:user}", dbx.Params{"user": userID})
+	if err != nil {
+		return true
+	}
+	return settings.GetBool("enabled")
+}
+
+// Encrypt and post a payload, returns the status of the push service
+func (wp *WebPushService) Send(sub PushSubscription, payload []byte) (int, error) {
+	body, err := encryptPushPayload(sub, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	token, err := wp.vapidToken(sub.Endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(pushTTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+	req.Header.Set("Authorization", "vapid t="+token+", k="+wp.PublicKey())
+
+	resp, err := wp.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("push service returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// VAPID JWT (ES256) for the origin of the push service
+func (wp *WebPushService) vapidToken(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid push endpoint")
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidTokenTTL).Unix(),
+		"sub": wp.subject,
+	})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, wp.privateKey, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// aes128gcm content coding with the keys of the subscription (RFC 8291)
+func encryptPushPayload(sub PushSubscription, payload []byte) ([]byte, error) {
+	if len(payload) > maxPushPayload {
+		return nil, fmt.Errorf("push payload too large (%d bytes)", len(payload))
+	}
+
+	uaPublic, err := decodePushKey(sub.Keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	authSecret, err := decodePushKey(sub.Keys.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth secret: %w", err)
+	}
+
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+
+	// Ephemeral key of this message
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asKey.PublicKey().Bytes()
+
+	shared, err := asKey.ECDH(uaKey)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keyInfo := "WebPush: info\x00" + string(uaPublic) + string(asPublic)
+	prkKey, err := hkdf.Extract(sha256.New, shared, authSecret)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := hkdf.Expand(sha256.New, prkKey, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Single record: payload followed by the last record delimiter
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	// Header: salt, record size, key id (the ephemeral public key)
+	body := make([]byte, 0, 21+len(asPublic)+len(plaintext)+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, 4096)
+	body = append(body, byte(len(asPublic)))
+	body = append(body, asPublic...)
+
+	return gcm.Seal(body, nonce, plaintext, nil), nil
+}
+
+// Browsers give base64url keys, padded or not
+func decodePushKey(key string) ([]byte, error) {
+	if decoded, err := base64.RawURLEncoding.DecodeString(key); err == nil {
+		return decoded, nil
+	}
+	if decoded, err := base64.URLEncoding.DecodeString(key); err == nil {
+		return decoded, nil
+	}
+	return base64.StdEncoding.DecodeString(key)
+}
+
+// ==================== HTTP HANDLERS ====================
+
+func handleVAPIDPublicKey(c *core.RequestEvent) error {
+	if webPush == nil {
+		return c.JSON(503, map[string]string{"error": "web push not configured"})
+	}
+
+	return c.JSON(200, map[string]string{"publicKey": webPush.PublicKey()})
+}
+
+// Register the PushSubscription of a browser, {endpoint, keys: {p256dh, auth}, device}
+func handleSubscribePush(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	var req struct {
+		PushSubscription
+		Device string `json:"device"`
+	}
+	if err := c.BindBody(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	if err := checkPushEndpoint(req.Endpoint); err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	p256dh, err := decodePushKey(req.Keys.P256dh)
+	if err != nil || len(p256dh) != 65 {
+		return c.JSON(400, map[string]string{"error": "invalid p256dh key"})
+	}
+	auth, err := decodePushKey(req.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return c.JSON(400, map[string]string{"error": "invalid auth secret"})
+	}
+
+	if req.Device == "" {
+		req.Device = sessionDevice(c)
+	}
+
+	// Same endpoint: the browser renewed its keys. Taking over the endpoint
+	// of another user would redirect their notifications, the other user
+	// has to unsubscribe first (on logout).
+	record, err := c.App.FindFirstRecordByFilter("pushSubscriptions", "endpoint = {:endpoint}", dbx.Params{"endpoint": req.Endpoint})
+	if err == nil && record.GetString("user") != userID {
+		return c.JSON(409, map[string]string{"error": "endpoint registered by another user"})
+	}
+	if err != nil {
+		collection, err := c.App.FindCollectionByNameOrId("pushSubscriptions")
+		if err != nil {
+			return c.JSON(500, map[string]string{"error": err.Error()})
+		}
+		record = core.NewRecord(collection)
+		record.Set("endpoint", req.Endpoint)
+	}
+
+	record.Set("user", userID)
+	record.Set("p256dh", req.Keys.P256dh)
+	record.Set("auth", req.Keys.Auth)
+	record.Set("device", req.Device)
+
+	if err := c.App.Save(record); err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"id":       record.Id,
+		"endpoint": record.GetString("endpoint"),
+		"device":   record.GetString("device"),
+	})
+}
+
+// Remove a subscription, {endpoint}
+func handleUnsubscribePush(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	var req struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := c.BindBody(&req); err != nil || req.Endpoint == "" {
+		return c.JSON(400, map[string]string{"error": "endpoint required"})
+	}
+
+	record, err := c.App.FindFirstRecordByFilter(
+		"pushSubscriptions",
+		"endpoint = {:endpoint} && user = {:user}",
+		dbx.Params{"endpoint": req.Endpoint, "user": userID},
+	)
+	if err != nil {
+		return c.JSON(404, map[string]string{"error": "subscription not found"})
+	}
+
+	if err := c.App.Delete(record); err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(200, map[string]bool{"success": true})
+}
+
+func handleGetPushSettings(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	subscriptions, _ := c.App.CountRecords("pushSubscriptions", dbx.HashExp{"user": userID})
+
+	return c.JSON(200, map[string]interface{}{
+		"enabled":       webPush.Enabled(userID),
+		"subscriptions": subscriptions,
+	})
+}
+
+// Opt in/out of Web Push for all devices, {enabled}
+func handleUpdatePushSettings(c *core.RequestEvent) error {
+	userID := c.Get("userID").(string)
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.BindBody(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	settings, err := c.App.FindFirstRecordByFilter("pushSettings", "user = {:user}", dbx.Params{"user": userID})
+	if err != nil {
+		collection, err := c.App.FindCollectionByNameOrId("pushSettings")
+		if err != nil {
+			return c.JSON(500, map[string]string{"error": err.Error()})
+		}
+		settings = core.NewRecord(collection)
+		settings.Set("user", userID)
+	}
+
+	settings.Set("enabled", req.Enabled)
+	if err := c.App.Save(settings); err != nil {
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(200, map[string]interface{}{"enabled": req.Enabled})
+}
+
+// ==================== SETUP COLLECTIONS ====================
+
+func SetupPushCollections(app core.App) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		subscriptions := core.NewBaseCollection("pushSubscriptions")
+		subscriptions.Fields.Add(
+			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1, CascadeDelete: true},
+			&core.URLField{Name: "endpoint", Required: true},
+			&core.TextField{Name: "p256dh", Required: true},
+			&core.TextField{Name: "auth", Required: true},
+			&core.TextField{Name: "device"},
+			&core.DateField{Name: "lastUsedAt"},
+		)
+		subscriptions.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_push_subscriptions_endpoint ON pushSubscriptions (endpoint)",
+			"CREATE INDEX idx_push_subscriptions_user ON pushSubscriptions (user)",
+		}
+		subscriptions.ListRule = types.Pointer("user = @request.auth.id")
+		subscriptions.ViewRule = types.Pointer("user = @request.auth.id")
+		subscriptions.DeleteRule = types.Pointer("user = @request.auth.id")
+
+		if err := txApp.Save(subscriptions); err != nil {
+			return err
+		}
+
+		settings := core.NewBaseCollection("pushSettings")
+		settings.Fields.Add(
+			&core.RelationField{Name: "user", Required: true, CollectionId: "_pb_users_auth_", MaxSelect: 1, CascadeDelete: true},
+			&core.BoolField{Name: "enabled"},
+		)
+		settings.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_push_settings_user ON pushSettings (user)",
+		}
+		settings.ListRule = types.Pointer("user = @request.auth.id")
+		settings.ViewRule = types.Pointer("user = @request.auth.id")
+
+		return txApp.Save(settings)
+	})
+}
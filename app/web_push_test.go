@@ -0,0 +1,111 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPushEndpoint(t *testing.T) {
+	for _, endpoint := range []string{
+		"https://fcm.googleapis.com/fcm/send/abc",
+		"https://updates.push.services.mozilla.com/wpush/v2/abc",
+		"https://push.example.com:443/abc",
+		"https://8.8.8.8/abc",
+	} {
+		assert.NoError(t, checkPushEndpoint(endpoint), endpoint)
+	}
+
+	for _, endpoint := range []string{
+		"http://fcm.googleapis.com/fcm/send/abc",
+		"https:///abc",
+		"https://push.example.com:8443/abc",
+		"https://localhost/abc",
+		"https://api.localhost./abc",
+		"https://127.0.0.1/abc",
+		"https://10.1.2.3/abc",
+		"https://192.168.1.1/abc",
+		"https://169.254.169.254/latest/meta-data",
+		"https://100.64.0.1/abc",
+		"https://0.0.0.0/abc",
+		"https://[::1]/abc",
+		"https://[fd00::1]/abc",
+		"https://[fe80::1]/abc",
+		"https://[::ffff:127.0.0.1]/abc",
+	} {
+		assert.Error(t, checkPushEndpoint(endpoint), endpoint)
+	}
+}
+
+func TestPushClientRefusesLocalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached the local server")
+	}))
+	defer server.Close()
+
+	// Also what a public name resolving to a local address gets
+	_, err := newPushHTTPClient().Post(server.URL, "application/octet-stream", nil)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errPushEndpointForbidden), err.Error())
+}
+
+func TestSubscribePush(t *testing.T) {
+	app := newTestApp(t)
+	require.NoError(t, SetupPushCollections(app))
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	r, err := apis.NewRouter(app)
+	require.NoError(t, err)
+	r.POST("/api/push/subscriptions", func(e *core.RequestEvent) error {
+		e.Set("userID", e.Request.Header.Get("X-User"))
+		return handleSubscribePush(e)
+	})
+	mux, err := r.BuildMux()
+	require.NoError(t, err)
+
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	auth := make([]byte, 16)
+	rand.Read(auth)
+
+	subscribe := func(userID, endpoint string) int {
+		body, _ := json.Marshal(map[string]interface{}{
+			"endpoint": endpoint,
+			"keys": map[string]string{
+				"p256dh": base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+				"auth":   base64.RawURLEncoding.EncodeToString(auth),
+			},
+		})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/push/subscriptions", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User", userID)
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	endpoint := "https://push.example.com/device1"
+	assert.Equal(t, 200, subscribe(alice, endpoint))
+	// Renewed keys
+	assert.Equal(t, 200, subscribe(alice, endpoint))
+	// Someone else's browser endpoint
+	assert.Equal(t, 409, subscribe(bob, endpoint))
+	assert.Equal(t, 400, subscribe(bob, "https://169.254.169.254/latest"))
+
+	records, err := app.FindAllRecords("pushSubscriptions")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, alice, records[0].GetString("user"))
+}
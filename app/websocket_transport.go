@@ -0,0 +1,440 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	msgpack "github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/net/websocket"
+)
+
+// ==================== WEBSOCKET FRAMES ====================
+
+// Frames are JSON text frames, or msgpack binary frames with ?encoding=msgpack.
+//
+// Client -> server:
+//
+//	{"type":"subscribe","topic":"room.*.chat","last_event_id":123,"filter":"type = 'comment'"}
+//	{"type":"unsubscribe","id":"<subscription id>"}
+//	{"type":"request","request_id":"1","method":"POST","endpoint":"/location/update","body":{...},"timeout_ms":5000}
+//	{"type":"cancel","request_id":"1"}
+//	{"type":"ping"}
+//
+// Server -> client: the user channel messages as sent over the DataChannel
+// ({type, request_id, data, timestamp, id}) plus the frames below.
+type WSClientFrame struct {
+	Type        string `json:"type" msgpack:"type"`
+	ID          string `json:"id,omitempty" msgpack:"id,omitempty"`
+	Topic       string `json:"topic,omitempty" msgpack:"topic,omitempty"`
+	LastEventID uint64 `json:"last_event_id,omitempty" msgpack:"last_event_id,omitempty"`
+	Filter      string `json:"filter,omitempty" msgpack:"filter,omitempty"`
+	APIRequest  `msgpack:",inline"`
+}
+
+// Topic message
+type WSEventFrame struct {
+	Type    string                 `json:"type" msgpack:"type"` // event
+	ID      string                 `json:"id" msgpack:"id"`     // subscription id
+	Topic   string                 `json:"topic" msgpack:"topic"`
+	EventID uint64                 `json:"event_id" msgpack:"event_id"`
+	Payload map[string]interface{} `json:"payload" msgpack:"payload"`
+}
+
+// session, subscribed, unsubscribed, pong, error, disconnect
+type WSControlFrame struct {
+	Type    string `json:"type" msgpack:"type"`
+	ID      string `json:"id,omitempty" msgpack:"id,omitempty"`
+	Topic   string `json:"topic,omitempty" msgpack:"topic,omitempty"`
+	Error   string `json:"error,omitempty" msgpack:"error,omitempty"`
+	Reason  string `json:"reason,omitempty" msgpack:"reason,omitempty"`
+	Dropped uint64 `json:"dropped,omitempty" msgpack:"dropped,omitempty"`
+}
+
+// Response to a request frame
+type WSResponseFrame struct {
+	Type        string `json:"type" msgpack:"type"` // response
+	APIResponse `msgpack:",inline"`
+}
+
+var msgpackCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		data, err := msgpack.Marshal(v)
+		return data, websocket.BinaryFrame, err
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		return msgpack.Unmarshal(data, v)
+	},
+}
+
+// Decode text frames as JSON and binary frames as msgpack
+var wsFrameCodec = websocket.Codec{
+	Marshal: websocket.JSON.Marshal,
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		if payloadType == websocket.BinaryFrame {
+			return msgpack.Unmarshal(data, v)
+		}
+		return json.Unmarshal(data, v)
+	},
+}
+
+// ==================== WEBSOCKET CLIENT ====================
+
+// WSClient - Connexion WebSocket d'un utilisateur
+type WSClient struct {
+	UserID      string
+	SessionID   string
+	Device      string
+	RemoteAddr  string // for the bridged API requests
+	ConnectedAt time.Time
+	conn        *websocket.Conn
+	codec       websocket.Codec
+	access      *TopicAccess
+	options     SubscribeOptions
+	out         chan interface{}
+	subs        map[string]*Subscription
+	dropped     uint64
+	reason      string
+	closed      bool
+	done        chan struct{}
+	mu          sync.Mutex
+}
+
+// Queue a frame according to the backpressure policy
+func (wc *WSClient) send(frame interface{}) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	if wc.closed {
+		return
+	}
+
+	dropped, disconnect := offer(wc.out, frame, wc.options)
+	wc.dropped += uint64(dropped)
+
+	if disconnect {
+		wc.reason = reasonSlowConsumer
+		wc.closeLocked()
+	}
+}
+
+func (wc *WSClient) closeLocked() {
+	if !wc.closed {
+		wc.closed = true
+		close(wc.out)
+	}
+}
+
+func (wc *WSClient) close() {
+	wc.mu.Lock()
+	subs := wc.subs
+	wc.subs = make(map[string]*Subscription)
+	wc.closeLocked()
+	wc.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
+func (wc *WSClient) writeLoop() {
+	defer close(wc.done)
+
+	for frame := range wc.out {
+		wc.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := wc.codec.Send(wc.conn, frame); err != nil {
+			wc.conn.Close()
+			// Keep draining so that send() never blocks on a dead connection
+			for range wc.out {
+			}
+			return
+		}
+	}
+
+	wc.mu.Lock()
+	reason, dropped := wc.reason, wc.dropped
+	wc.mu.Unlock()
+
+	if reason != "" {
+		wc.codec.Send(wc.conn, WSControlFrame{Type: "disconnect", Reason: reason, Dropped: dropped})
+	}
+	wc.conn.Close()
+}
+
+func (wc *WSClient) readLoop() {
+	for {
+		var frame WSClientFrame
+		if err := wsFrameCodec.Receive(wc.conn, &frame); err != nil {
+			return
+		}
+		presenceTracker.Heartbeat(wc.UserID)
+
+		switch frame.Type {
+		case "subscribe":
+			wc.subscribe(frame)
+		case "unsubscribe":
+			wc.unsubscribe(frame)
+		case "request":
+			wc.submit(frame.APIRequest)
+		case "cancel":
+			if !userChannelManager.rpc.Cancel(wc.UserID, wc.SessionID, frame.RequestID) {
+				wc.send(WSControlFrame{Type: "error", ID: frame.RequestID, Error: "request not found"})
+			}
+		case "ping":
+			wc.send(WSControlFrame{Type: "pong", ID: frame.ID})
+		default:
+			wc.send(WSControlFrame{Type: "error", ID: frame.ID, Error: fmt.Sprintf("unknown frame type %q", frame.Type)})
+		}
+	}
+}
+
+func (wc *WSClient) subscribe(frame WSClientFrame) {
+	topic := frame.Topic
+	if err := ValidateTopicPattern(topic); err != nil {
+		wc.send(WSControlFrame{Type: "error", ID: frame.ID, Topic: topic, Error: err.Error()})
+		return
+	}
+
+	if !IsWildcardTopic(topic) && !wc.access.AllowsTopic(topic) {
+		wc.send(WSControlFrame{Type: "error", ID: frame.ID, Topic: topic, Error: "not allowed to subscribe to this topic"})
+		return
+	}
+
+	filter, err := CompileEventFilter(frame.Filter)
+	if err != nil {
+		wc.send(WSControlFrame{Type: "error", ID: frame.ID, Topic: topic, Error: err.Error()})
+		return
+	}
+
+	options := wc.options
+	options.Filter = filter
+	sub := pubsub.Subscribe(topic, options)
+
+	wc.mu.Lock()
+	if wc.closed {
+		wc.mu.Unlock()
+		sub.Unsubscribe()
+		return
+	}
+	wc.subs[sub.ID] = sub
+	wc.mu.Unlock()
+
+	wc.send(WSControlFrame{Type: "subscribed", ID: sub.ID, Topic: topic})
+
+	go func() {
+		send := func(msg PubSubMessage) {
+			if wc.access.Allows(msg) && filter.Match(msg) {
+				wc.send(WSEventFrame{Type: "event", ID: sub.ID, Topic: msg.Topic, EventID: msg.ID, Payload: msg.Payload})
+			}
+		}
+
+		var lastSent uint64
+		if frame.LastEventID > 0 {
+			for _, msg := range pubsub.Replay(topic, frame.LastEventID) {
+				send(msg)
+				lastSent = msg.ID
+			}
+		}
+
+		for msg := range sub.C {
+			if msg.ID <= lastSent {
+				continue
+			}
+			send(msg)
+		}
+
+		if reason := sub.Reason(); reason != "" {
+			wc.mu.Lock()
+			delete(wc.subs, sub.ID)
+			wc.mu.Unlock()
+			wc.send(WSControlFrame{Type: "unsubscribed", ID: sub.ID, Topic: topic, Reason: reason, Dropped: sub.Dropped()})
+		}
+	}()
+}
+
+func (wc *WSClient) unsubscribe(frame WSClientFrame) {
+	wc.mu.Lock()
+	sub, exists := wc.subs[frame.ID]
+	delete(wc.subs, frame.ID)
+	wc.mu.Unlock()
+
+	if !exists {
+		wc.send(WSControlFrame{Type: "error", ID: frame.ID, Error: "subscription not found"})
+		return
+	}
+
+	sub.Unsubscribe()
+	wc.send(WSControlFrame{Type: "unsubscribed", ID: sub.ID, Topic: sub.Topic})
+}
+
+// Queue a request in the user's RPC pool, shared with the DataChannel,
+// after the "rpc" rate limit
+func (wc *WSClient) submit(req APIRequest) {
+	err := rateLimiter.AllowRPC(wc.UserID)
+	if err == nil {
+		err = userChannelManager.rpc.Submit(wc.UserID, wc.SessionID, req.RequestID, req.TimeoutMs, func(ctx context.Context) {
+			wc.request(ctx, req)
+		})
+	}
+	if err != nil {
+		wc.send(WSResponseFrame{Type: "response", APIResponse: APIResponse{
+			RequestID:  req.RequestID,
+			StatusCode: rpcErrorStatus(err),
+			Error:      err.Error(),
+			Timestamp:  time.Now().Unix(),
+		}})
+	}
+}
+
+// Same routing as the DataChannel API
+func (wc *WSClient) request(ctx context.Context, req APIRequest) {
+	log.Printf("📨 API Request via WebSocket from %s: %s %s", wc.UserID, req.Method, req.Endpoint)
+
+	response := APIResponse{
+		RequestID: req.RequestID,
+		Timestamp: time.Now().Unix(),
+	}
+
+	result, statusCode, err := userChannelManager.routeAPIRequest(ctx, wc.UserID, wc.RemoteAddr, req)
+
+	response.StatusCode = statusCode
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	wc.send(WSResponseFrame{Type: "response", APIResponse: response})
+}
+
+// ==================== USER CHANNEL ====================
+
+func (ucm *UserChannelManager) addWebSocket(client *WSClient) {
+	ucm.mu.Lock()
+	defer ucm.mu.Unlock()
+
+	if ucm.wsClients[client.UserID] == nil {
+		ucm.wsClients[client.UserID] = make(map[*WSClient]struct{})
+	}
+	ucm.wsClients[client.UserID][client] = struct{}{}
+}
+
+func (ucm *UserChannelManager) removeWebSocket(client *WSClient) {
+	ucm.mu.Lock()
+	defer ucm.mu.Unlock()
+
+	if clients, exists := ucm.wsClients[client.UserID]; exists {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(ucm.wsClients, client.UserID)
+		}
+	}
+}
+
+// Forward a user channel message to the user's WebSocket connections,
+// or only to the given session
+func (ucm *UserChannelManager) sendToWebSockets(userID, sessionID string, message SSEMessage) {
+	ucm.mu.RLock()
+	clients := make([]*WSClient, 0, len(ucm.wsClients[userID]))
+	for client := range ucm.wsClients[userID] {
+		if sessionID == "" || client.SessionID == sessionID {
+			clients = append(clients, client)
+		}
+	}
+	ucm.mu.RUnlock()
+
+	for _, client := range clients {
+		client.send(message)
+	}
+}
+
+// ==================== HTTP HANDLER ====================
+
+// WebSocket multiplexing topic subscriptions, the user channel and API requests
+func handleWebSocket(c *core.RequestEvent) error {
+	// Browsers can't set headers on WebSocket requests
+	if err := loadQueryTokenAuth(c); err != nil || c.Auth == nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	info, err := c.RequestInfo()
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	opts, err := subscribeOptionsFromRequest(c)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	codec := websocket.JSON
+	if c.Request.URL.Query().Get("encoding") == "msgpack" {
+		codec = msgpackCodec
+	}
+
+	userID := c.Auth.Id
+	lastEventID := lastEventIDFromRequest(c)
+
+	sessionID := c.Request.URL.Query().Get("session")
+	if sessionID == "" {
+		sessionID = newSessionID()
+	}
+	device := sessionDevice(c)
+	remoteAddr := transportRemoteAddr(c)
+
+	server := websocket.Server{
+		// Origin is not checked, auth relies on the token
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			client := &WSClient{
+				UserID:      userID,
+				SessionID:   sessionID,
+				Device:      device,
+				RemoteAddr:  remoteAddr,
+				ConnectedAt: time.Now(),
+				conn:        conn,
+				codec:       codec,
+				access:      topicAuthorizer.NewAccess(info),
+				options:     opts,
+				out:         make(chan interface{}, opts.BufferSize),
+				subs:        make(map[string]*Subscription),
+				done:        make(chan struct{}),
+			}
+
+			go client.writeLoop()
+			client.send(WSControlFrame{Type: "session", ID: sessionID})
+
+			// Registered before the replay so nothing is lost, clients dedupe on id
+			userChannelManager.addWebSocket(client)
+			presenceTracker.Connected(userID)
+			if lastEventID > 0 {
+				for _, msg := range userChannelManager.ReplaySSE(userID, sessionID, lastEventID) {
+					client.send(msg)
+				}
+			}
+
+			go userChannelManager.deliverPendingNotifications(userID, sessionID)
+
+			log.Printf("🔗 WebSocket connected for user: %s (session %s)", userID, sessionID)
+
+			client.readLoop()
+
+			userChannelManager.removeWebSocket(client)
+			presenceTracker.Disconnected(userID)
+			client.close()
+			<-client.done
+
+			log.Printf("🔌 WebSocket closed for user: %s (session %s)", userID, sessionID)
+		},
+	}
+
+	server.ServeHTTP(c.Response, c.Request)
+	return nil
+}
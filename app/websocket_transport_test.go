@@ -0,0 +1,217 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/net/websocket"
+)
+
+// Serve /api/ws with fresh globals, restored when the test ends
+func newTestWebSocketServer(t *testing.T) (core.App, *httptest.Server) {
+	t.Helper()
+
+	app := newTestApp(t)
+
+	savedPubSub, savedAuthorizer, savedChannels := pubsub, topicAuthorizer, userChannelManager
+	savedPresence, savedLimiter := presenceTracker, rateLimiter
+	pubsub = NewMemoryPubSub()
+	topicAuthorizer = NewTopicAuthorizer(app)
+	userChannelManager = NewUserChannelManager(app)
+	// No offline timer firing after the app is cleaned up
+	presenceTracker = NewPresenceTracker(app, PresenceOptions{OfflineGrace: time.Hour})
+	rateLimiter = NewRateLimiter(app)
+	t.Cleanup(func() {
+		pubsub, topicAuthorizer, userChannelManager = savedPubSub, savedAuthorizer, savedChannels
+		presenceTracker, rateLimiter = savedPresence, savedLimiter
+	})
+
+	r, err := apis.NewRouter(app)
+	require.NoError(t, err)
+	// The handler returns once the connection is closed, the globals must
+	// not be restored before that
+	var handlers sync.WaitGroup
+	r.GET("/api/ws", func(c *core.RequestEvent) error {
+		handlers.Add(1)
+		defer handlers.Done()
+		return handleWebSocket(c)
+	})
+	mux, err := r.BuildMux()
+	require.NoError(t, err)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	t.Cleanup(handlers.Wait)
+
+	return app, server
+}
+
+func authToken(t *testing.T, app core.App, userID string) string {
+	t.Helper()
+
+	user, err := app.FindRecordById("users", userID)
+	require.NoError(t, err)
+	token, err := user.NewAuthToken()
+	require.NoError(t, err)
+	return token
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ws?" + query
+	conn, err := websocket.Dial(url, "", server.URL)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Read frames until one of the given type, skipping presence updates and such
+func readFrame(t *testing.T, conn *websocket.Conn, codec websocket.Codec, frameType string) map[string]interface{} {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for {
+		var frame map[string]interface{}
+		require.NoError(t, codec.Receive(conn, &frame), "waiting for a %q frame", frameType)
+		if frame["type"] == frameType {
+			return frame
+		}
+	}
+}
+
+func TestWebSocketRequiresAuth(t *testing.T) {
+	_, server := newTestWebSocketServer(t)
+
+	for _, query := range []string{"", "token=invalid"} {
+		resp, err := http.Get(server.URL + "/api/ws?" + query)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 401, resp.StatusCode, query)
+	}
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+	app, server := newTestWebSocketServer(t)
+	alice := createTestUser(t, app, "alice@example.com")
+	bob := createTestUser(t, app, "bob@example.com")
+
+	conn := dialWebSocket(t, server, "token="+authToken(t, app, alice)+"&session=s1")
+	session := readFrame(t, conn, websocket.JSON, "session")
+	assert.Equal(t, "s1", session["id"])
+
+	require.NoError(t, websocket.JSON.Send(conn, WSClientFrame{Type: "subscribe", Topic: UserTopic(alice, "chat")}))
+	subscribed := readFrame(t, conn, websocket.JSON, "subscribed")
+	subID := subscribed["id"].(string)
+	assert.Equal(t, UserTopic(alice, "chat"), subscribed["topic"])
+
+	pubsub.Publish(UserTopic(alice, "chat"), PubSubMessage{Payload: map[string]interface{}{"text": "hi"}})
+	event := readFrame(t, conn, websocket.JSON, "event")
+	assert.Equal(t, subID, event["id"])
+	assert.Equal(t, UserTopic(alice, "chat"), event["topic"])
+	assert.Equal(t, map[string]interface{}{"text": "hi"}, event["payload"])
+
+	// Someone else's topic
+	require.NoError(t, websocket.JSON.Send(conn, WSClientFrame{Type: "subscribe", ID: "x", Topic: UserTopic(bob, "chat")}))
+	denied := readFrame(t, conn, websocket.JSON, "error")
+	assert.Equal(t, "x", denied["id"])
+	assert.Equal(t, "not allowed to subscribe to this topic", denied["error"])
+
+	require.NoError(t, websocket.JSON.Send(conn, WSClientFrame{Type: "subscribe", Topic: "room..chat"}))
+	assert.NotEmpty(t, readFrame(t, conn, websocket.JSON, "error")["error"])
+
+	require.NoError(t, websocket.JSON.Send(conn, WSClientFrame{Type: "unsubscribe", ID: subID}))
+	unsubscribed := readFrame(t, conn, websocket.JSON, "unsubscribed")
+	assert.Equal(t, subID, unsubscribed["id"])
+
+	require.NoError(t, websocket.JSON.Send(conn, WSClientFrame{Type: "unsubscribe", ID: subID}))
+	assert.Equal(t, "subscription not found", readFrame(t, conn, websocket.JSON, "error")["error"])
+}
+
+func TestWebSocketControlFrames(t *testing.T) {
+	app, server := newTestWebSocketServer(t)
+	alice := createTestUser(t, app, "alice@example.com")
+
+	conn := dialWebSocket(t, server, "token="+authToken(t, app, alice))
+	readFrame(t, conn, websocket.JSON, "session")
+
+	require.NoError(t, websocket.JSON.Send(conn, WSClientFrame{Type: "ping", ID: "p1"}))
+	assert.Equal(t, "p1", readFrame(t, conn, websocket.JSON, "pong")["id"])
+
+	require.NoError(t, websocket.JSON.Send(conn, WSClientFrame{Type: "bogus", ID: "b1"}))
+	unknown := readFrame(t, conn, websocket.JSON, "error")
+	assert.Equal(t, "b1", unknown["id"])
+	assert.Contains(t, unknown["error"], "bogus")
+
+	require.NoError(t, websocket.JSON.Send(conn, WSClientFrame{Type: "cancel", APIRequest: APIRequest{RequestID: "r1"}}))
+	cancel := readFrame(t, conn, websocket.JSON, "error")
+	assert.Equal(t, "r1", cancel["id"])
+	assert.Equal(t, "request not found", cancel["error"])
+}
+
+func TestWebSocketUserChannel(t *testing.T) {
+	app, server := newTestWebSocketServer(t)
+	alice := createTestUser(t, app, "alice@example.com")
+
+	first := dialWebSocket(t, server, "token="+authToken(t, app, alice)+"&session=s1")
+	readFrame(t, first, websocket.JSON, "session")
+	second := dialWebSocket(t, server, "token="+authToken(t, app, alice)+"&session=s2")
+	readFrame(t, second, websocket.JSON, "session")
+
+	userChannelManager.SendToSSE(alice, "hello", map[string]interface{}{"n": 1.0}, "")
+	for _, conn := range []*websocket.Conn{first, second} {
+		assert.Equal(t, map[string]interface{}{"n": 1.0}, readFrame(t, conn, websocket.JSON, "hello")["data"])
+	}
+
+	// Only the targeted session
+	userChannelManager.SendToSession(alice, "s2", "reply", map[string]interface{}{"n": 2.0}, "r1")
+	reply := readFrame(t, second, websocket.JSON, "reply")
+	assert.Equal(t, "r1", reply["request_id"])
+	assert.Equal(t, "s2", reply["session"])
+
+	userChannelManager.SendToSSE(alice, "marker", nil, "")
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var frame map[string]interface{}
+		require.NoError(t, websocket.JSON.Receive(first, &frame))
+		require.NotEqual(t, "reply", frame["type"], "sent to another session")
+		if frame["type"] == "marker" {
+			break
+		}
+	}
+}
+
+func TestWebSocketMsgpack(t *testing.T) {
+	app, server := newTestWebSocketServer(t)
+	alice := createTestUser(t, app, "alice@example.com")
+
+	conn := dialWebSocket(t, server, "token="+authToken(t, app, alice)+"&encoding=msgpack")
+	readFrame(t, conn, msgpackCodec, "session")
+
+	// Binary frames are decoded as msgpack
+	data, err := msgpack.Marshal(WSClientFrame{Type: "subscribe", Topic: UserTopic(alice, "chat")})
+	require.NoError(t, err)
+	conn.PayloadType = websocket.BinaryFrame
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+	readFrame(t, conn, msgpackCodec, "subscribed")
+
+	pubsub.Publish(UserTopic(alice, "chat"), PubSubMessage{Payload: map[string]interface{}{"text": "hi"}})
+	event := readFrame(t, conn, msgpackCodec, "event")
+	assert.Equal(t, map[string]interface{}{"text": "hi"}, event["payload"])
+
+	// Text frames are still JSON
+	require.NoError(t, websocket.JSON.Send(conn, WSClientFrame{Type: "ping", ID: "p1"}))
+	assert.Equal(t, "p1", readFrame(t, conn, msgpackCodec, "pong")["id"])
+}
@@ -1,5 +1,13 @@
 // ==================== EXEMPLES GÉOLOCALISATION ====================
 
+// Confidentialité : location.getLocation, findNearby et getUsersByPresence
+// appliquent les réglages des utilisateurs pour le viewerId passé en dernier
+// argument. Le script est cru sur parole : passez l'utilisateur authentifié de
+// l'événement (event.user_id), jamais une valeur reçue d'un client. Sans
+// viewerId, seules les positions publiques (visibility "everyone", hors mode
+// fantôme) sont renvoyées : les anciens appels sans viewerId voient moins
+// d'utilisateurs qu'avant.
+
 // ==================== HOOK: pb_hooks/location-tracker.js ====================
 // Suivi en temps réel des localisations et déclenchement d'actions
 
@@ -40,7 +48,7 @@ function handleLocationUpdate(event) {
   });
 
   // Vérifier les utilisateurs à proximité
-  const nearby = location.findNearby(location.point.lat, location.point.lng, 100); // 100m
+  const nearby = location.findNearby(location.point.lat, location.point.lng, 100, userId); // 100m, vus par userId
   
   if (nearby.length > 0) {
     logger.info(`👥 Found ${nearby.length} users nearby`);
@@ -131,6 +139,7 @@ function main() {
 }
 
 function checkProximityMatches() {
+  // Sans viewerId : seuls les utilisateurs à la position publique
   const onlineUsers = location.getUsersByPresence("online");
   
   if (onlineUsers.length < 2) {
@@ -287,7 +296,8 @@ function main() {
 function handleEmergencyAlert(alert) {
   logger.warn(`🚨 EMERGENCY ALERT: ${alert.type} at (${alert.lat}, ${alert.lng})`);
 
-  // Trouver tous les utilisateurs dans un rayon de 5km
+  // Trouver les utilisateurs dans un rayon de 5km. Sans viewerId seules les
+  // positions publiques sont vues : les utilisateurs cachés ne sont pas prévenus
   const affectedUsers = location.findNearby(alert.lat, alert.lng, 5000);
 
   logger.warn(`Found ${affectedUsers.length} users in danger zone`);
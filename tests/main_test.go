@@ -2,10 +2,20 @@ package main
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -75,8 +85,9 @@ func TestPubSub(t *testing.T) {
 	ps := tania.NewPubSub()
 
 	// Test subscribe
-	ch := ps.Subscribe("test_topic")
-	assert.NotNil(t, ch)
+	sub := ps.Subscribe("test_topic")
+	assert.NotNil(t, sub)
+	assert.Equal(t, 1, ps.SubscriberCount("test_topic"))
 
 	// Test publish
 	go ps.Publish("test_topic", tania.PubSubMessage{
@@ -88,12 +99,21 @@ func TestPubSub(t *testing.T) {
 
 	// Test receive
 	select {
-	case msg := <-ch:
+	case msg := <-sub.C:
 		assert.Equal(t, "test_topic", msg.Topic)
 		assert.Equal(t, "hello", msg.Payload["message"])
 	case <-time.After(1 * time.Second):
 		t.Fatal("timeout waiting for message")
 	}
+
+	// Test unsubscribe (publishing afterwards must not panic)
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	assert.Equal(t, 0, ps.SubscriberCount("test_topic"))
+	ps.Publish("test_topic", tania.PubSubMessage{Topic: "test_topic"})
+
+	_, open := <-sub.C
+	assert.False(t, open)
 }
 
 // ==================== LOCATION TESTS ====================
@@ -103,6 +123,7 @@ func TestLocationManager(t *testing.T) {
 	defer app.Cleanup()
 
 	lm := tania.NewLocationManager(app)
+	defer lm.Wait()
 
 	// Test update location
 	location := tania.Location{
@@ -127,6 +148,7 @@ func TestFindNearby(t *testing.T) {
 	defer app.Cleanup()
 
 	lm := tania.NewLocationManager(app)
+	defer lm.Wait()
 
 	// Add test locations
 	loc1 := tania.Location{Point: tania.Point{Lat: 48.8566, Lng: 2.3522}, Accuracy: 10, Timestamp: time.Now()}
@@ -183,6 +205,7 @@ func TestGeofence(t *testing.T) {
 	defer app.Cleanup()
 
 	lm := tania.NewLocationManager(app)
+	defer lm.Wait()
 
 	// Create geofence
 	fence := &tania.GeoFence{
@@ -445,6 +468,191 @@ func TestSSEChannel(t *testing.T) {
 	assert.False(t, channel.IsActive)
 }
 
+func TestSSEMultipleSessions(t *testing.T) {
+	app := setupTestApp(t)
+	defer app.Cleanup()
+
+	ucm := tania.NewUserChannelManager(app)
+
+	// Two devices of the same user
+	phone := ucm.OpenSSESession("user_1", "phone", "iPhone")
+	laptop := ucm.OpenSSESession("user_1", "laptop", "Firefox")
+	assert.Len(t, ucm.Sessions("user_1"), 2)
+
+	// Broadcast reaches both sessions
+	ucm.SendToSSE("user_1", "test_event", map[string]interface{}{"message": "hello"}, "")
+	for _, channel := range []*tania.SSEChannel{phone, laptop} {
+		select {
+		case msg := <-channel.Channel:
+			assert.Equal(t, "test_event", msg.Type)
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for SSE message")
+		}
+	}
+
+	// Targeted response only reaches its session
+	ucm.SendToSession("user_1", "laptop", "response", map[string]interface{}{}, "req_1")
+	select {
+	case msg := <-laptop.Channel:
+		assert.Equal(t, "req_1", msg.RequestID)
+		assert.Equal(t, "laptop", msg.Session)
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for SSE message")
+	}
+	assert.Len(t, phone.Channel, 0)
+
+	// Closing one session keeps the other
+	ucm.CloseSSESession("user_1", "phone")
+	assert.False(t, phone.IsActive)
+	assert.True(t, laptop.IsActive)
+	assert.Len(t, ucm.Sessions("user_1"), 1)
+
+	// Replay skips the messages targeted at other sessions
+	assert.Len(t, ucm.ReplaySSE("user_1", "phone", 1), 1)
+	assert.Len(t, ucm.ReplaySSE("user_1", "laptop", 1), 2)
+}
+
+// ==================== WEB PUSH TESTS ====================
+
+// Stand-in push service: decrypts the aes128gcm payloads with the keys of
+// the browser subscription
+type testPushEndpoint struct {
+	server   *httptest.Server
+	key      *ecdh.PrivateKey
+	auth     []byte
+	status   int
+	received []map[string]interface{}
+	headers  []http.Header
+	mu       sync.Mutex
+}
+
+func newTestPushEndpoint(t *testing.T) *testPushEndpoint {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+
+	endpoint := &testPushEndpoint{key: key, auth: auth, status: http.StatusCreated}
+	endpoint.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payload, err := endpoint.decrypt(body)
+		if err != nil {
+			t.Errorf("push payload: %v", err)
+		}
+
+		endpoint.mu.Lock()
+		defer endpoint.mu.Unlock()
+		var message map[string]interface{}
+		json.Unmarshal(payload, &message)
+		endpoint.received = append(endpoint.received, message)
+		endpoint.headers = append(endpoint.headers, r.Header.Clone())
+		w.WriteHeader(endpoint.status)
+	}))
+
+	return endpoint
+}
+
+func (e *testPushEndpoint) decrypt(body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, fmt.Errorf("body too short")
+	}
+	salt, idLen := body[:16], int(body[20])
+	asKey, err := ecdh.P256().NewPublicKey(body[21 : 21+idLen])
+	if err != nil {
+		return nil, err
+	}
+	shared, _ := e.key.ECDH(asKey)
+
+	info := "WebPush: info\x00" + string(e.key.PublicKey().Bytes()) + string(asKey.Bytes())
+	prkKey, _ := hkdf.Extract(sha256.New, shared, e.auth)
+	ikm, _ := hkdf.Expand(sha256.New, prkKey, info, 32)
+	prk, _ := hkdf.Extract(sha256.New, ikm, salt)
+	cek, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Padding, then the 0x02 delimiter of the last record
+	plaintext = bytes.TrimRight(plaintext, "\x00")
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 0x02 {
+		return nil, fmt.Errorf("missing record delimiter")
+	}
+	return plaintext[:len(plaintext)-1], nil
+}
+
+func (e *testPushEndpoint) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.received)
+}
+
+func TestWebPush(t *testing.T) {
+	app := setupTestApp(t)
+	defer app.Cleanup()
+
+	if err := tania.SetupPushCollections(app); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint := newTestPushEndpoint(t)
+	defer endpoint.server.Close()
+
+	userID, _, err := createTestUser(app, "push@example.com", "password123")
+	assert.NoError(t, err)
+
+	subscriptions, _ := app.FindCollectionByNameOrId("pushSubscriptions")
+	subscription := core.NewRecord(subscriptions)
+	subscription.Set("user", userID)
+	subscription.Set("endpoint", endpoint.server.URL+"/push/device1")
+	subscription.Set("p256dh", base64.RawURLEncoding.EncodeToString(endpoint.key.PublicKey().Bytes()))
+	subscription.Set("auth", base64.RawURLEncoding.EncodeToString(endpoint.auth))
+	assert.NoError(t, app.Save(subscription))
+
+	keys, err := tania.GenerateVAPIDKeys()
+	assert.NoError(t, err)
+	wp, err := tania.NewWebPushService(app, keys, "mailto:test@example.com")
+	assert.NoError(t, err)
+	// The default client refuses local addresses
+	wp.SetHTTPClient(endpoint.server.Client())
+
+	// Encrypted payload and VAPID headers
+	wp.Notify(userID, "new_follower", "notif_1", map[string]interface{}{"follower_id": "user_2"})
+	assert.Equal(t, 1, endpoint.count())
+	assert.Equal(t, "new_follower", endpoint.received[0]["type"])
+	assert.Equal(t, "notif_1", endpoint.received[0]["notification_id"])
+	assert.Equal(t, "aes128gcm", endpoint.headers[0].Get("Content-Encoding"))
+	assert.Contains(t, endpoint.headers[0].Get("Authorization"), "k="+keys.PublicKey)
+
+	// Opt-out
+	settingsCollection, _ := app.FindCollectionByNameOrId("pushSettings")
+	settings := core.NewRecord(settingsCollection)
+	settings.Set("user", userID)
+	settings.Set("enabled", false)
+	assert.NoError(t, app.Save(settings))
+
+	wp.Notify(userID, "new_follower", "notif_2", nil)
+	assert.Equal(t, 1, endpoint.count())
+
+	// Expired subscriptions are removed
+	settings.Set("enabled", true)
+	assert.NoError(t, app.Save(settings))
+	endpoint.mu.Lock()
+	endpoint.status = http.StatusGone
+	endpoint.mu.Unlock()
+
+	wp.Notify(userID, "new_follower", "notif_3", nil)
+	assert.Equal(t, 2, endpoint.count())
+	_, err = app.FindRecordById("pushSubscriptions", subscription.Id)
+	assert.Error(t, err)
+}
+
 // ==================== SCRIPT MANAGER TESTS ====================
 
 func TestScriptManager(t *testing.T) {
@@ -627,6 +835,7 @@ func BenchmarkFindNearby(b *testing.B) {
 	defer app.Cleanup()
 
 	lm := tania.NewLocationManager(app)
+	defer lm.Wait()
 
 	// Add 1000 users
 	for i := 0; i < 1000; i++ {
@@ -648,10 +857,11 @@ func BenchmarkFindNearby(b *testing.B) {
 
 func BenchmarkPubSubPublish(b *testing.B) {
 	ps := tania.NewPubSub()
-	ch := ps.Subscribe("test")
+	sub := ps.Subscribe("test")
+	defer sub.Unsubscribe()
 
 	go func() {
-		for range ch {
+		for range sub.C {
 			// Consume messages
 		}
 	}()